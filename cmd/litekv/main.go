@@ -1,7 +1,16 @@
 package main
 
-import "litekv/internal/server"
+import (
+	"log"
+	"os"
+
+	"litekv/internal/config"
+	"litekv/internal/server"
+)
 
 func main() {
+	if err := config.ParseArgs(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
 	server.StartServer()
 }
@@ -0,0 +1,225 @@
+package commands
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"litekv/internal/protocol"
+	"litekv/internal/store"
+)
+
+var errBitOffset = errors.New("bit offset is not an integer or out of range")
+
+// parseBitOffset parses an offset for SETBIT and GETBIT.
+func parseBitOffset(s string) (uint64, error) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n > store.MaxBitOffset {
+		return 0, errBitOffset
+	}
+	return n, nil
+}
+
+// setbit implements SETBIT key offset value.
+func setbit(parsed []string, client *Client) (string, error) {
+	offset, err := parseBitOffset(parsed[2])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	if parsed[3] != "0" && parsed[3] != "1" {
+		return protocol.SerializeError("bit is not an integer or out of range"), errors.New("bit is not an integer or out of range")
+	}
+	return serializeTypedInteger(store.SetBit(client.DB, parsed[1], offset, int(parsed[3][0]-'0')))
+}
+
+// getbit implements GETBIT key offset.
+func getbit(parsed []string, client *Client) (string, error) {
+	offset, err := parseBitOffset(parsed[2])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	return serializeTypedInteger(store.GetBit(client.DB, parsed[1], offset))
+}
+
+// parseBitRange parses the [start [end [BYTE|BIT]]] arguments of BITCOUNT
+// and BITPOS.
+func parseBitRange(args []string) (store.BitRange, error) {
+	var r store.BitRange
+	if len(args) > 3 {
+		return r, errors.New("syntax error")
+	}
+	var err error
+	if len(args) > 0 {
+		if r.Start, err = strconv.Atoi(args[0]); err != nil {
+			return r, errors.New("value is not an integer or out of range")
+		}
+		r.HasStart = true
+	}
+	if len(args) > 1 {
+		if r.End, err = strconv.Atoi(args[1]); err != nil {
+			return r, errors.New("value is not an integer or out of range")
+		}
+		r.HasEnd = true
+	}
+	if len(args) > 2 {
+		switch strings.ToUpper(args[2]) {
+		case "BIT":
+			r.Bit = true
+		case "BYTE":
+		default:
+			return r, errors.New("syntax error")
+		}
+	}
+	return r, nil
+}
+
+// bitcount implements BITCOUNT key [start end [BYTE|BIT]].
+func bitcount(parsed []string, client *Client) (string, error) {
+	if len(parsed) == 3 {
+		return protocol.SerializeError("syntax error"), errors.New("syntax error")
+	}
+	r, err := parseBitRange(parsed[2:])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	return serializeTypedInteger(store.BitCount(client.DB, parsed[1], r))
+}
+
+// bitpos implements BITPOS key bit [start [end [BYTE|BIT]]].
+func bitpos(parsed []string, client *Client) (string, error) {
+	if parsed[2] != "0" && parsed[2] != "1" {
+		return protocol.SerializeError("The bit argument must be 1 or 0."), errors.New("The bit argument must be 1 or 0.")
+	}
+	r, err := parseBitRange(parsed[3:])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	return serializeTypedInteger(store.BitPos(client.DB, parsed[1], int(parsed[2][0]-'0'), r))
+}
+
+// bitop implements BITOP AND|OR|XOR|NOT destkey key [key ...].
+func bitop(parsed []string, client *Client) (string, error) {
+	op := strings.ToUpper(parsed[1])
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(parsed) != 4 {
+			return protocol.SerializeError("BITOP NOT must be called with a single source key."), errors.New("BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return protocol.SerializeError("syntax error"), errors.New("syntax error")
+	}
+	return serializeTypedInteger(store.BitOp(client.DB, op, parsed[2], parsed[3:]))
+}
+
+// parseBitFieldType parses a BITFIELD type such as i8 or u16: signed
+// integers can be 1 to 64 bits wide, unsigned ones 1 to 63.
+func parseBitFieldType(s string, op *store.BitFieldOp) error {
+	err := errors.New("Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	if len(s) < 2 {
+		return err
+	}
+	switch s[0] {
+	case 'i', 'I':
+		op.Signed = true
+	case 'u', 'U':
+	default:
+		return err
+	}
+	n, convErr := strconv.Atoi(s[1:])
+	if convErr != nil || n < 1 || n > 64 || (n == 64 && !op.Signed) {
+		return err
+	}
+	op.Bits = n
+	return nil
+}
+
+// parseBitFieldOffset parses a BITFIELD offset, either in bits or, with a
+// leading #, in multiples of the type width.
+func parseBitFieldOffset(s string, op *store.BitFieldOp) error {
+	multiply := strings.HasPrefix(s, "#")
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, "#"), 10, 64)
+	if err != nil || n > store.MaxBitOffset {
+		return errBitOffset
+	}
+	if multiply {
+		n *= uint64(op.Bits)
+	}
+	if n+uint64(op.Bits)-1 > store.MaxBitOffset {
+		return errBitOffset
+	}
+	op.Offset = n
+	return nil
+}
+
+// bitfield implements BITFIELD key [GET type offset] [SET type offset value]
+// [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL] ... and
+// BITFIELD_RO key [GET type offset ...].
+func bitfield(parsed []string, client *Client) (string, error) {
+	readOnly := parsed[0] == "BITFIELD_RO"
+	ops := make([]store.BitFieldOp, 0)
+	overflow := "WRAP"
+	args := parsed[2:]
+	for len(args) > 0 {
+		sub := strings.ToUpper(args[0])
+		if sub == "OVERFLOW" && len(args) >= 2 && !readOnly {
+			overflow = strings.ToUpper(args[1])
+			if overflow != "WRAP" && overflow != "SAT" && overflow != "FAIL" {
+				return protocol.SerializeError("Invalid OVERFLOW type specified"), errors.New("Invalid OVERFLOW type specified")
+			}
+			args = args[2:]
+			continue
+		}
+		if readOnly && sub != "GET" {
+			return protocol.SerializeError("BITFIELD_RO only supports the GET subcommand"), errors.New("BITFIELD_RO only supports the GET subcommand")
+		}
+		width := 4
+		if sub == "GET" {
+			width = 3
+		} else if sub != "SET" && sub != "INCRBY" {
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+		if len(args) < width {
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+		op := store.BitFieldOp{Op: sub, Overflow: overflow}
+		if err := parseBitFieldType(args[1], &op); err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		if err := parseBitFieldOffset(args[2], &op); err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		if width == 4 {
+			value, err := strconv.ParseInt(args[3], 10, 64)
+			if err != nil {
+				return protocol.SerializeError("value is not an integer or out of range"), errors.New("value is not an integer or out of range")
+			}
+			op.Value = value
+		}
+		ops = append(ops, op)
+		args = args[width:]
+	}
+	response := make([]string, len(ops))
+	if readOnly {
+		values, err := store.BitFieldRO(client.DB, parsed[1], ops)
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		for i, v := range values {
+			response[i] = protocol.SerializeInteger(int(v))
+		}
+		return protocol.SerializeRawArray(response), nil
+	}
+	values, ok, err := store.BitField(client.DB, parsed[1], ops)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	for i, v := range values {
+		if ok[i] {
+			response[i] = protocol.SerializeInteger(int(v))
+		} else {
+			response[i] = protocol.SerializeNull()
+		}
+	}
+	return protocol.SerializeRawArray(response), nil
+}
@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"time"
+
+	"litekv/internal/store"
+)
+
+// blockFor calls try until it returns true, waiting between attempts for
+// one of keys to be written. It gives up and returns false once timeout
+// expires (0 waits forever) or the client disconnects. Other connections
+// keep being served meanwhile since only this client's goroutine waits.
+func blockFor(client *Client, keys []string, timeout time.Duration, try func() (string, bool)) (string, bool) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	var gone <-chan struct{}
+	for {
+		// register before trying so a write in between is not missed
+		w := store.Block(client.DB, keys)
+		if response, ok := try(); ok {
+			store.Unblock(w)
+			return response, true
+		}
+		if gone == nil {
+			var stop func()
+			gone, stop = client.watchDisconnect()
+			defer stop()
+		}
+		select {
+		case <-w.C:
+			store.Unblock(w)
+		case <-expired:
+			store.Unblock(w)
+			return "", false
+		case <-gone:
+			store.Unblock(w)
+			return "", false
+		}
+	}
+}
+
+// blockPop pops for p, waiting up to timeout (0 waits forever) for one of
+// its keys to receive data. Clients waiting on the same key are served in
+// the order they blocked; see store.BPop.
+func blockPop(client *Client, p store.BlockedPop, timeout time.Duration) (store.PopResult, bool, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	var gone <-chan struct{}
+	for {
+		result, ok, w, err := store.BPop(client.DB, p)
+		if err != nil || ok {
+			return result, ok, err
+		}
+		if gone == nil {
+			var stop func()
+			gone, stop = client.watchDisconnect()
+			defer stop()
+		}
+		giveUp := false
+		select {
+		case <-w.C:
+		case <-expired:
+			giveUp = true
+		case <-gone:
+			giveUp = true
+		}
+		// the waiter may have been served right before the timeout
+		if store.Unblock(w) {
+			return w.Popped(), true, nil
+		}
+		if giveUp {
+			return store.PopResult{}, false, nil
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"litekv/internal/protocol"
+	"litekv/internal/store"
+)
+
+func serializeBools(values []bool) string {
+	response := make([]string, len(values))
+	for i, v := range values {
+		if v {
+			response[i] = protocol.SerializeInteger(1)
+		} else {
+			response[i] = protocol.SerializeInteger(0)
+		}
+	}
+	return protocol.SerializeRawArray(response)
+}
+
+// bfreserve implements BF.RESERVE key error_rate capacity [EXPANSION
+// expansion] [NONSCALING].
+func bfreserve(parsed []string, client *Client) (string, error) {
+	opts := store.BloomOptions{Expansion: 2}
+	var err error
+	if opts.ErrorRate, err = strconv.ParseFloat(parsed[2], 64); err != nil || opts.ErrorRate <= 0 || opts.ErrorRate >= 1 {
+		return protocol.SerializeError("(0 < error rate range < 1)"), errors.New("(0 < error rate range < 1)")
+	}
+	if opts.Capacity, err = strconv.Atoi(parsed[3]); err != nil || opts.Capacity <= 0 {
+		return protocol.SerializeError("(capacity should be larger than 0)"), errors.New("(capacity should be larger than 0)")
+	}
+	expansion := false
+	args := parsed[4:]
+	for len(args) > 0 {
+		switch option := strings.ToUpper(args[0]); {
+		case option == "EXPANSION" && len(args) >= 2:
+			if opts.Expansion, err = strconv.Atoi(args[1]); err != nil || opts.Expansion < 1 {
+				return protocol.SerializeError("(expansion should be greater or equal to 1)"), errors.New("(expansion should be greater or equal to 1)")
+			}
+			expansion = true
+			args = args[2:]
+		case option == "NONSCALING":
+			opts.NonScaling = true
+			args = args[1:]
+		default:
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+	}
+	if expansion && opts.NonScaling {
+		return protocol.SerializeError("Nonscaling filters cannot expand"), errors.New("Nonscaling filters cannot expand")
+	}
+	if err := store.BFReserve(client.DB, parsed[1], opts); err != nil {
+		return serializeTypedError(err), err
+	}
+	return protocol.SerializeSimpleString("OK"), nil
+}
+
+// bfadd implements BF.ADD key item and BF.MADD key item [item ...].
+func bfadd(parsed []string, client *Client) (string, error) {
+	added, err := store.BFAdd(client.DB, parsed[1], parsed[2:])
+	if parsed[0] == "BF.ADD" {
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		if added[0] {
+			return protocol.SerializeInteger(1), nil
+		}
+		return protocol.SerializeInteger(0), nil
+	}
+	if err != nil && added == nil {
+		return serializeTypedError(err), err
+	}
+	// items past a full non scaling filter get the error in their slot
+	response := make([]string, len(parsed)-2)
+	for i := range response {
+		switch {
+		case i >= len(added):
+			response[i] = protocol.SerializeError(err.Error())
+		case added[i]:
+			response[i] = protocol.SerializeInteger(1)
+		default:
+			response[i] = protocol.SerializeInteger(0)
+		}
+	}
+	return protocol.SerializeRawArray(response), nil
+}
+
+// bfexists implements BF.EXISTS key item and BF.MEXISTS key item [item ...].
+func bfexists(parsed []string, client *Client) (string, error) {
+	found, err := store.BFExists(client.DB, parsed[1], parsed[2:])
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if parsed[0] == "BF.EXISTS" {
+		if found[0] {
+			return protocol.SerializeInteger(1), nil
+		}
+		return protocol.SerializeInteger(0), nil
+	}
+	return serializeBools(found), nil
+}
@@ -0,0 +1,54 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"time"
+)
+
+// Client is the per-connection state kept by the server and handed to Route.
+type Client struct {
+	Conn net.Conn
+	// Reader buffers what was read from Conn. Blocking commands peek at it
+	// to notice the client going away while they wait.
+	Reader *bufio.Reader
+	// DB is the database selected with SELECT.
+	DB int
+}
+
+// watchDisconnect returns a channel closed if the client disconnects. The
+// returned stop function must be called before Reader is used again: it
+// interrupts the pending read with a deadline and waits for it to return.
+// Commands pipelined after the blocking one stay buffered.
+func (c *Client) watchDisconnect() (<-chan struct{}, func()) {
+	gone := make(chan struct{})
+	if c.Reader == nil {
+		return gone, func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			n := c.Reader.Buffered() + 1
+			if n > c.Reader.Size() {
+				// the buffer is full of pipelined commands, nothing to watch
+				return
+			}
+			_, err := c.Reader.Peek(n)
+			if err == nil {
+				continue
+			}
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				close(gone)
+			}
+			return
+		}
+	}()
+	return gone, func() {
+		c.Conn.SetReadDeadline(time.Now())
+		<-done
+		c.Conn.SetReadDeadline(time.Time{})
+	}
+}
@@ -2,17 +2,129 @@ package commands
 
 import (
 	"errors"
-	"net"
 	"strconv"
+	"strings"
 	"time"
 
+	"litekv/internal/config"
 	"litekv/internal/persistence"
 	"litekv/internal/protocol"
 	"litekv/internal/pubsub"
 	"litekv/internal/store"
 )
 
-func Route(parsed []string, conn net.Conn) (string, error) {
+// denyOOM lists the commands that may grow memory usage and are refused once
+// maxmemory is reached and nothing else can be evicted.
+var denyOOM = map[string]bool{
+	"SET":     true,
+	"SETEX":   true,
+	"LPUSH":   true,
+	"RPUSH":   true,
+	"HSET":    true,
+	"HSETNX":  true,
+	"HINCRBY": true,
+	"SADD":    true,
+	"ZADD":    true,
+	"ZINCRBY": true,
+	"COPY":    true,
+
+	"ZUNIONSTORE": true,
+	"ZINTERSTORE": true,
+	"ZDIFFSTORE":  true,
+	"ZRANGESTORE": true,
+	"XADD":        true,
+	"BLMOVE":      true,
+	"LPUSHX":      true,
+	"RPUSHX":      true,
+	"LINSERT":     true,
+	"LSET":        true,
+	"LMOVE":       true,
+
+	"HINCRBYFLOAT": true,
+	"HSETEX":       true,
+	"SUNIONSTORE":  true,
+	"SINTERSTORE":  true,
+	"SDIFFSTORE":   true,
+	"SETBIT":       true,
+	"BITOP":        true,
+	"BITFIELD":     true,
+	"PFADD":        true,
+	"PFMERGE":      true,
+
+	"GEOADD":         true,
+	"GEOSEARCHSTORE": true,
+	"JSON.SET":       true,
+	"JSON.ARRAPPEND": true,
+	"BF.RESERVE":     true,
+	"BF.ADD":         true,
+	"BF.MADD":        true,
+	"CMS.INITBYDIM":  true,
+	"TS.CREATE":      true,
+	"TS.ADD":         true,
+	"TS.MADD":        true,
+
+	"SMOVE":          true,
+	"MOVE":           true,
+	"HEXPIRE":        true,
+	"HPEXPIRE":       true,
+	"HEXPIREAT":      true,
+	"HPEXPIREAT":     true,
+	"XREADGROUP":     true,
+	"XCLAIM":         true,
+	"XAUTOCLAIM":     true,
+	"JSON.NUMINCRBY": true,
+	"CMS.MERGE":      true,
+}
+
+// denyOOMSubcommands is denyOOM for commands whose other subcommands free
+// memory, such as XGROUP DESTROY.
+var denyOOMSubcommands = map[string]map[string]bool{
+	"XGROUP": {"CREATE": true, "CREATECONSUMER": true},
+}
+
+// growsMemory reports whether parsed is refused by denyOOM or
+// denyOOMSubcommands once maxmemory is reached.
+func growsMemory(parsed []string) bool {
+	if denyOOM[parsed[0]] {
+		return true
+	}
+	subcommands, ok := denyOOMSubcommands[parsed[0]]
+	return ok && len(parsed) > 1 && subcommands[strings.ToUpper(parsed[1])]
+}
+
+// parseDB parses a database index and checks it is in range.
+func parseDB(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("value is not an integer or out of range")
+	}
+	if n < 0 || n >= store.Databases() {
+		return 0, errors.New("DB index is out of range")
+	}
+	return n, nil
+}
+
+// serializeTypedError replies to an error of the commands of a typed value,
+// with the WRONGTYPE code when the key holds another type.
+func serializeTypedError(err error) string {
+	if errors.Is(err, store.ErrWrongType) {
+		return protocol.SerializeErrorCode("WRONGTYPE", err.Error())
+	}
+	return protocol.SerializeError(err.Error())
+}
+
+// serializeTypedInteger replies with n, or with err as serializeTypedError.
+func serializeTypedInteger(n int, err error) (string, error) {
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return protocol.SerializeInteger(n), nil
+}
+
+func Route(parsed []string, client *Client) (string, error) {
+	if !store.FreeMemoryIfNeeded() && growsMemory(parsed) {
+		return protocol.SerializeErrorCode("OOM", "command not allowed when used memory > 'maxmemory'."), errors.New("out of memory")
+	}
 	if string(parsed[0]) == "PING" {
 		return protocol.SerializeSimpleString("PONG"), nil
 	}
@@ -20,7 +132,10 @@ func Route(parsed []string, conn net.Conn) (string, error) {
 		if len(parsed) < 2 {
 			return protocol.SerializeError("Wrong number of arguments"), errors.New("GET requires a key")
 		}
-		data, ok := store.Get(parsed[1])
+		data, ok, err := store.Get(client.DB, parsed[1])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
 		if ok {
 			response := protocol.SerializeBulkString(data)
 			return response, nil
@@ -30,7 +145,7 @@ func Route(parsed []string, conn net.Conn) (string, error) {
 		if len(parsed) <= 2 {
 			return protocol.SerializeError("Wrong number of arguments"), errors.New("Error Setting data")
 		}
-		if store.Set(string(parsed[1]), string(parsed[2])) {
+		if store.Set(client.DB, string(parsed[1]), string(parsed[2])) {
 			return protocol.SerializeSimpleString("OK"), nil
 		}
 		return protocol.SerializeError("Internal Error"), errors.New("Error Setting data")
@@ -39,7 +154,7 @@ func Route(parsed []string, conn net.Conn) (string, error) {
 			return protocol.SerializeError("Wrong number of arguments"), errors.New("DEL requires a key")
 		}
 
-		if store.Delete(parsed[1]) {
+		if store.Delete(client.DB, parsed[1]) {
 			return protocol.SerializeInteger(1), nil
 		}
 		return protocol.SerializeInteger(0), nil
@@ -47,7 +162,7 @@ func Route(parsed []string, conn net.Conn) (string, error) {
 		if len(parsed) < 2 {
 			return protocol.SerializeError("Wrong number of arguments"), errors.New("EXISTS requires a key")
 		}
-		if store.Exists(parsed[1]) {
+		if store.Exists(client.DB, parsed[1]) {
 			return protocol.SerializeInteger(1), nil
 		}
 		return protocol.SerializeInteger(0), nil
@@ -60,24 +175,21 @@ func Route(parsed []string, conn net.Conn) (string, error) {
 			return protocol.SerializeError("Value is not an integer or out of range"), errors.New("invalid expiry")
 		}
 		expiry := time.Now().Add(time.Duration(seconds) * time.Second)
-		store.SetWithExpiry(string(parsed[1]), string(parsed[3]), expiry)
+		store.SetWithExpiry(client.DB, string(parsed[1]), string(parsed[3]), expiry)
 		return protocol.SerializeSimpleString("OK"), nil
 	} else if string(parsed[0]) == "TTL" {
 		if len(parsed) < 2 {
 			return protocol.SerializeError("Wrong number of arguments"), errors.New("TTL requires more arguments")
 		}
-		data, ok := store.GetTTL(parsed[1])
-		if ok {
-			ttl := int(time.Until(data).Truncate(time.Second).Seconds())
-			if store.Exists(parsed[1]) {
-				return protocol.SerializeInteger(-1), nil
-			}
-			if ttl <= 0 {
-				return protocol.SerializeInteger(-2), nil
-			}
-			return protocol.SerializeInteger(ttl), nil
+		when, hasTTL, exists := store.GetTTL(client.DB, parsed[1])
+		if !exists {
+			return protocol.SerializeInteger(-2), nil
+		}
+		if !hasTTL {
+			return protocol.SerializeInteger(-1), nil
 		}
-		return protocol.SerializeInteger(-2), errors.New("TTL fetch error")
+		// rounded to the nearest second, as Redis does
+		return protocol.SerializeInteger(int((time.Until(when) + time.Second/2) / time.Second)), nil
 	} else if string(parsed[0]) == "EXPIRE" {
 		if len(parsed) < 3 {
 			return protocol.SerializeError("Wrong number of arguments"), errors.New("EXPIRE requires more arguments")
@@ -87,120 +199,921 @@ func Route(parsed []string, conn net.Conn) (string, error) {
 			return protocol.SerializeError("Value is not an integer or out of range"), errors.New("invalid expiry")
 		}
 		expiry := time.Now().Add(time.Duration(seconds) * time.Second)
-		if store.SetExpire(parsed[1], expiry) {
+		if store.SetExpire(client.DB, parsed[1], expiry) {
 			return protocol.SerializeInteger(1), nil
 		}
 		return protocol.SerializeInteger(0), errors.New("Key doesn't exists")
-	} else if string(parsed[0]) == "LPUSH" {
+	} else if string(parsed[0]) == "SETBIT" {
+		if len(parsed) != 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'SETBIT' command"), errors.New("Wrong number of arguments for 'SETBIT' command")
+		}
+		return setbit(parsed, client)
+	} else if string(parsed[0]) == "GETBIT" {
+		if len(parsed) != 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'GETBIT' command"), errors.New("Wrong number of arguments for 'GETBIT' command")
+		}
+		return getbit(parsed, client)
+	} else if string(parsed[0]) == "BITCOUNT" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'BITCOUNT' command"), errors.New("Wrong number of arguments for 'BITCOUNT' command")
+		}
+		return bitcount(parsed, client)
+	} else if string(parsed[0]) == "BITPOS" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'BITPOS' command"), errors.New("Wrong number of arguments for 'BITPOS' command")
+		}
+		return bitpos(parsed, client)
+	} else if string(parsed[0]) == "BITOP" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'BITOP' command"), errors.New("Wrong number of arguments for 'BITOP' command")
+		}
+		return bitop(parsed, client)
+	} else if string(parsed[0]) == "BITFIELD" || string(parsed[0]) == "BITFIELD_RO" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		return bitfield(parsed, client)
+	} else if string(parsed[0]) == "PFADD" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'PFADD' command"), errors.New("Wrong number of arguments for 'PFADD' command")
+		}
+		return pfadd(parsed, client)
+	} else if string(parsed[0]) == "PFCOUNT" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'PFCOUNT' command"), errors.New("Wrong number of arguments for 'PFCOUNT' command")
+		}
+		return pfcount(parsed, client)
+	} else if string(parsed[0]) == "PFMERGE" {
 		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'PFMERGE' command"), errors.New("Wrong number of arguments for 'PFMERGE' command")
+		}
+		return pfmerge(parsed, client)
+	} else if string(parsed[0]) == "GEOADD" {
+		if len(parsed) < 5 {
+			return protocol.SerializeError("Wrong number of arguments for 'GEOADD' command"), errors.New("Wrong number of arguments for 'GEOADD' command")
+		}
+		return geoadd(parsed, client)
+	} else if string(parsed[0]) == "GEODIST" {
+		if len(parsed) != 4 && len(parsed) != 5 {
+			return protocol.SerializeError("Wrong number of arguments for 'GEODIST' command"), errors.New("Wrong number of arguments for 'GEODIST' command")
+		}
+		return geodist(parsed, client)
+	} else if string(parsed[0]) == "GEOPOS" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'GEOPOS' command"), errors.New("Wrong number of arguments for 'GEOPOS' command")
+		}
+		return geopos(parsed, client)
+	} else if string(parsed[0]) == "GEOHASH" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'GEOHASH' command"), errors.New("Wrong number of arguments for 'GEOHASH' command")
+		}
+		return geohash(parsed, client)
+	} else if string(parsed[0]) == "GEOSEARCH" || string(parsed[0]) == "GEOSEARCHSTORE" {
+		if (parsed[0] == "GEOSEARCH" && len(parsed) < 7) || (parsed[0] == "GEOSEARCHSTORE" && len(parsed) < 8) {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		return geosearch(parsed, client)
+	} else if string(parsed[0]) == "JSON.SET" {
+		if len(parsed) != 4 && len(parsed) != 5 {
+			return protocol.SerializeError("Wrong number of arguments for 'JSON.SET' command"), errors.New("Wrong number of arguments for 'JSON.SET' command")
+		}
+		return jsonset(parsed, client)
+	} else if string(parsed[0]) == "JSON.GET" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'JSON.GET' command"), errors.New("Wrong number of arguments for 'JSON.GET' command")
+		}
+		return jsonget(parsed, client)
+	} else if string(parsed[0]) == "JSON.MGET" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'JSON.MGET' command"), errors.New("Wrong number of arguments for 'JSON.MGET' command")
+		}
+		return jsonmget(parsed, client)
+	} else if string(parsed[0]) == "JSON.DEL" || string(parsed[0]) == "JSON.FORGET" {
+		if len(parsed) != 2 && len(parsed) != 3 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		return jsondel(parsed, client)
+	} else if string(parsed[0]) == "JSON.TYPE" {
+		if len(parsed) != 2 && len(parsed) != 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'JSON.TYPE' command"), errors.New("Wrong number of arguments for 'JSON.TYPE' command")
+		}
+		return jsontype(parsed, client)
+	} else if string(parsed[0]) == "JSON.NUMINCRBY" {
+		if len(parsed) != 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'JSON.NUMINCRBY' command"), errors.New("Wrong number of arguments for 'JSON.NUMINCRBY' command")
+		}
+		return jsonnumincrby(parsed, client)
+	} else if string(parsed[0]) == "JSON.ARRAPPEND" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'JSON.ARRAPPEND' command"), errors.New("Wrong number of arguments for 'JSON.ARRAPPEND' command")
+		}
+		return jsonarrappend(parsed, client)
+	} else if string(parsed[0]) == "JSON.OBJKEYS" {
+		if len(parsed) != 2 && len(parsed) != 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'JSON.OBJKEYS' command"), errors.New("Wrong number of arguments for 'JSON.OBJKEYS' command")
+		}
+		return jsonobjkeys(parsed, client)
+	} else if string(parsed[0]) == "BF.RESERVE" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'BF.RESERVE' command"), errors.New("Wrong number of arguments for 'BF.RESERVE' command")
+		}
+		return bfreserve(parsed, client)
+	} else if string(parsed[0]) == "BF.ADD" {
+		if len(parsed) != 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'BF.ADD' command"), errors.New("Wrong number of arguments for 'BF.ADD' command")
+		}
+		return bfadd(parsed, client)
+	} else if string(parsed[0]) == "BF.MADD" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'BF.MADD' command"), errors.New("Wrong number of arguments for 'BF.MADD' command")
+		}
+		return bfadd(parsed, client)
+	} else if string(parsed[0]) == "BF.EXISTS" {
+		if len(parsed) != 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'BF.EXISTS' command"), errors.New("Wrong number of arguments for 'BF.EXISTS' command")
+		}
+		return bfexists(parsed, client)
+	} else if string(parsed[0]) == "BF.MEXISTS" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'BF.MEXISTS' command"), errors.New("Wrong number of arguments for 'BF.MEXISTS' command")
+		}
+		return bfexists(parsed, client)
+	} else if string(parsed[0]) == "CMS.INITBYDIM" {
+		if len(parsed) != 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'CMS.INITBYDIM' command"), errors.New("Wrong number of arguments for 'CMS.INITBYDIM' command")
+		}
+		return cmsinitbydim(parsed, client)
+	} else if string(parsed[0]) == "CMS.INCRBY" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'CMS.INCRBY' command"), errors.New("Wrong number of arguments for 'CMS.INCRBY' command")
+		}
+		return cmsincrby(parsed, client)
+	} else if string(parsed[0]) == "CMS.QUERY" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'CMS.QUERY' command"), errors.New("Wrong number of arguments for 'CMS.QUERY' command")
+		}
+		return cmsquery(parsed, client)
+	} else if string(parsed[0]) == "CMS.MERGE" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'CMS.MERGE' command"), errors.New("Wrong number of arguments for 'CMS.MERGE' command")
+		}
+		return cmsmerge(parsed, client)
+	} else if string(parsed[0]) == "TS.CREATE" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'TS.CREATE' command"), errors.New("Wrong number of arguments for 'TS.CREATE' command")
+		}
+		return tscreate(parsed, client)
+	} else if string(parsed[0]) == "TS.ADD" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'TS.ADD' command"), errors.New("Wrong number of arguments for 'TS.ADD' command")
+		}
+		return tsadd(parsed, client)
+	} else if string(parsed[0]) == "TS.MADD" {
+		if len(parsed) < 4 || (len(parsed)-1)%3 != 0 {
+			return protocol.SerializeError("Wrong number of arguments for 'TS.MADD' command"), errors.New("Wrong number of arguments for 'TS.MADD' command")
+		}
+		return tsmadd(parsed, client)
+	} else if string(parsed[0]) == "TS.GET" {
+		if len(parsed) != 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'TS.GET' command"), errors.New("Wrong number of arguments for 'TS.GET' command")
+		}
+		return tsget(parsed, client)
+	} else if string(parsed[0]) == "TS.RANGE" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'TS.RANGE' command"), errors.New("Wrong number of arguments for 'TS.RANGE' command")
+		}
+		return tsrange(parsed, client)
+	} else if string(parsed[0]) == "TS.MRANGE" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'TS.MRANGE' command"), errors.New("Wrong number of arguments for 'TS.MRANGE' command")
+		}
+		return tsmrange(parsed, client)
+	} else if string(parsed[0]) == "LPUSH" {
+		if len(parsed) < 3 {
 			return protocol.SerializeError("Wrong number of arguments for 'LPUSH' command"), errors.New("Wrong number of arguments for LPUSH command")
 		}
-		response := store.LPush(parsed[1], parsed[2])
-		return protocol.SerializeInteger(response), nil
+		return serializeTypedInteger(store.LPush(client.DB, parsed[1], parsed[2:]...))
 
 	} else if string(parsed[0]) == "RPUSH" {
-		if len(parsed) < 2 {
+		if len(parsed) < 3 {
 			return protocol.SerializeError("Wrong number of arguments for 'RPUSH' command"), errors.New("Wrong number of arguments for RPUSH command")
 		}
-		response := store.RPush(parsed[1], parsed[2])
-		return protocol.SerializeInteger(response), nil
-	} else if string(parsed[0]) == "LPOP" {
-		if len(parsed) < 1 {
-			return protocol.SerializeError("Wrong number of arguments for 'LPOP' command"), errors.New("Wrong number of arguments for LPOP command")
-		}
-		response, ok := store.LPop(parsed[1])
-		if ok {
-			return protocol.SerializeBulkString(response), nil
+		return serializeTypedInteger(store.RPush(client.DB, parsed[1], parsed[2:]...))
+	} else if string(parsed[0]) == "LPUSHX" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'LPUSHX' command"), errors.New("Wrong number of arguments for 'LPUSHX' command")
 		}
-		return protocol.SerializeNull(), errors.New("Error LPOP data")
-	} else if string(parsed[0]) == "RPOP" {
-		if len(parsed) < 1 {
-			return protocol.SerializeError("Wrong number of arguments for 'LPOP' command"), errors.New("Wrong number of arguments for LPOP command")
+		return serializeTypedInteger(store.LPushX(client.DB, parsed[1], parsed[2:]...))
+	} else if string(parsed[0]) == "RPUSHX" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'RPUSHX' command"), errors.New("Wrong number of arguments for 'RPUSHX' command")
 		}
-		response, ok := store.RPop(parsed[1])
-		if ok {
-			return protocol.SerializeBulkString(response), nil
+		return serializeTypedInteger(store.RPushX(client.DB, parsed[1], parsed[2:]...))
+	} else if string(parsed[0]) == "LPOP" || string(parsed[0]) == "RPOP" {
+		if len(parsed) != 2 && len(parsed) != 3 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
 		}
-		return protocol.SerializeNull(), errors.New("Error LPOP data")
+		return lpop(parsed, client)
 	} else if string(parsed[0]) == "LRANGE" {
-		if len(parsed) < 3 {
+		if len(parsed) != 4 {
 			return protocol.SerializeError("Wrong number of arguments for 'LRANGE' command"), errors.New("Wrong number of arguments for LRANGE command")
 		}
-		start, _ := strconv.Atoi(parsed[2])
-		end, _ := strconv.Atoi(parsed[3])
-		response, ok := store.LRange(parsed[1], start, end)
-		if ok {
-			return protocol.SerializeArray(response), nil
+		start, err1 := strconv.Atoi(parsed[2])
+		end, err2 := strconv.Atoi(parsed[3])
+		if err1 != nil || err2 != nil {
+			return protocol.SerializeError("value is not an integer or out of range"), errors.New("value is not an integer or out of range")
 		}
-		return protocol.SerializeNull(), nil
+		response, err := store.LRange(client.DB, parsed[1], start, end)
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		return protocol.SerializeArray(response), nil
 	} else if string(parsed[0]) == "LLEN" {
-		if len(parsed) < 1 {
+		if len(parsed) != 2 {
 			return protocol.SerializeError("Wrong number of arguments for 'LLEN' command"), errors.New("Wrong number of arguments for 'LLEN' command")
 		}
-		response := store.LLen(parsed[1])
-		return protocol.SerializeInteger(response), nil
-	} else if string(parsed[0]) == "HSET" {
+		return serializeTypedInteger(store.LLen(client.DB, parsed[1]))
+	} else if string(parsed[0]) == "LINDEX" {
+		if len(parsed) != 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'LINDEX' command"), errors.New("Wrong number of arguments for 'LINDEX' command")
+		}
+		index, err := strconv.Atoi(parsed[2])
+		if err != nil {
+			return protocol.SerializeError("value is not an integer or out of range"), err
+		}
+		response, ok, err := store.LIndex(client.DB, parsed[1], index)
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		if !ok {
+			return protocol.SerializeNull(), nil
+		}
+		return protocol.SerializeBulkString(response), nil
+	} else if string(parsed[0]) == "LSET" {
+		if len(parsed) != 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'LSET' command"), errors.New("Wrong number of arguments for 'LSET' command")
+		}
+		index, err := strconv.Atoi(parsed[2])
+		if err != nil {
+			return protocol.SerializeError("value is not an integer or out of range"), err
+		}
+		if err := store.LSet(client.DB, parsed[1], index, parsed[3]); err != nil {
+			return serializeTypedError(err), err
+		}
+		return protocol.SerializeSimpleString("OK"), nil
+	} else if string(parsed[0]) == "LINSERT" {
+		if len(parsed) != 5 {
+			return protocol.SerializeError("Wrong number of arguments for 'LINSERT' command"), errors.New("Wrong number of arguments for 'LINSERT' command")
+		}
+		var before bool
+		switch strings.ToUpper(parsed[2]) {
+		case "BEFORE":
+			before = true
+		case "AFTER":
+		default:
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+		return serializeTypedInteger(store.LInsert(client.DB, parsed[1], before, parsed[3], parsed[4]))
+	} else if string(parsed[0]) == "LREM" {
+		if len(parsed) != 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'LREM' command"), errors.New("Wrong number of arguments for 'LREM' command")
+		}
+		count, err := strconv.Atoi(parsed[2])
+		if err != nil {
+			return protocol.SerializeError("value is not an integer or out of range"), err
+		}
+		return serializeTypedInteger(store.LRem(client.DB, parsed[1], count, parsed[3]))
+	} else if string(parsed[0]) == "LTRIM" {
+		if len(parsed) != 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'LTRIM' command"), errors.New("Wrong number of arguments for 'LTRIM' command")
+		}
+		start, err1 := strconv.Atoi(parsed[2])
+		stop, err2 := strconv.Atoi(parsed[3])
+		if err1 != nil || err2 != nil {
+			return protocol.SerializeError("value is not an integer or out of range"), errors.New("value is not an integer or out of range")
+		}
+		if err := store.LTrim(client.DB, parsed[1], start, stop); err != nil {
+			return serializeTypedError(err), err
+		}
+		return protocol.SerializeSimpleString("OK"), nil
+	} else if string(parsed[0]) == "LPOS" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'LPOS' command"), errors.New("Wrong number of arguments for 'LPOS' command")
+		}
+		return lpos(parsed, client)
+	} else if string(parsed[0]) == "LMOVE" {
+		if len(parsed) != 5 {
+			return protocol.SerializeError("Wrong number of arguments for 'LMOVE' command"), errors.New("Wrong number of arguments for 'LMOVE' command")
+		}
+		return lmove(parsed, client)
+	} else if string(parsed[0]) == "LMPOP" {
 		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'LMPOP' command"), errors.New("Wrong number of arguments for 'LMPOP' command")
+		}
+		return lmpop(parsed, client)
+	} else if string(parsed[0]) == "BLPOP" || string(parsed[0]) == "BRPOP" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		return blpop(parsed, client)
+	} else if string(parsed[0]) == "BLMOVE" {
+		if len(parsed) != 6 {
+			return protocol.SerializeError("Wrong number of arguments for 'BLMOVE' command"), errors.New("Wrong number of arguments for 'BLMOVE' command")
+		}
+		return blmove(parsed, client)
+	} else if string(parsed[0]) == "BLMPOP" {
+		if len(parsed) < 5 {
+			return protocol.SerializeError("Wrong number of arguments for 'BLMPOP' command"), errors.New("Wrong number of arguments for 'BLMPOP' command")
+		}
+		return blmpop(parsed, client)
+	} else if string(parsed[0]) == "HSET" {
+		if len(parsed) < 4 || len(parsed)%2 != 0 {
 			return protocol.SerializeError("Wrong number of arguments for 'HSET' command"), errors.New("Wrong number of arguments for 'HSET' command")
 		}
-		return protocol.SerializeInteger(store.HSet(parsed[1], parsed[2], parsed[3])), nil
+		return serializeTypedInteger(store.HSet(client.DB, parsed[1], parsed[2:]...))
+	} else if string(parsed[0]) == "HSETNX" {
+		if len(parsed) != 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'HSETNX' command"), errors.New("Wrong number of arguments for 'HSETNX' command")
+		}
+		return serializeTypedInteger(store.HSetNX(client.DB, parsed[1], parsed[2], parsed[3]))
 
 	} else if string(parsed[0]) == "HGET" {
 		if len(parsed) < 3 {
 			return protocol.SerializeError("Wrong number of arguments for 'HGET' command"), errors.New("Wrong number of arguments for 'HGET' command")
 		}
-		if response, ok := store.HGet(parsed[1], parsed[2]); ok {
-			return protocol.SerializeSimpleString(response), nil
+		response, ok, err := store.HGet(client.DB, parsed[1], parsed[2])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		if ok {
+			return protocol.SerializeBulkString(response), nil
 		}
 		return protocol.SerializeNull(), errors.New("No data found")
+	} else if string(parsed[0]) == "HMGET" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'HMGET' command"), errors.New("Wrong number of arguments for 'HMGET' command")
+		}
+		return hmget(parsed, client)
+	} else if string(parsed[0]) == "HEXISTS" {
+		if len(parsed) != 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'HEXISTS' command"), errors.New("Wrong number of arguments for 'HEXISTS' command")
+		}
+		exists, err := store.HExists(client.DB, parsed[1], parsed[2])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		if exists {
+			return protocol.SerializeInteger(1), nil
+		}
+		return protocol.SerializeInteger(0), nil
+	} else if string(parsed[0]) == "HSTRLEN" {
+		if len(parsed) != 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'HSTRLEN' command"), errors.New("Wrong number of arguments for 'HSTRLEN' command")
+		}
+		return serializeTypedInteger(store.HStrLen(client.DB, parsed[1], parsed[2]))
+	} else if string(parsed[0]) == "HINCRBY" {
+		if len(parsed) != 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'HINCRBY' command"), errors.New("Wrong number of arguments for 'HINCRBY' command")
+		}
+		return hincrby(parsed, client)
+	} else if string(parsed[0]) == "HINCRBYFLOAT" {
+		if len(parsed) != 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'HINCRBYFLOAT' command"), errors.New("Wrong number of arguments for 'HINCRBYFLOAT' command")
+		}
+		return hincrbyfloat(parsed, client)
+	} else if string(parsed[0]) == "HRANDFIELD" {
+		if len(parsed) < 2 || len(parsed) > 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'HRANDFIELD' command"), errors.New("Wrong number of arguments for 'HRANDFIELD' command")
+		}
+		return hrandfield(parsed, client)
 	} else if string(parsed[0]) == "HLEN" {
 		if len(parsed) < 2 {
 			return protocol.SerializeError("Wrong number of arguments for 'HLEN' command"), errors.New("Wrong number of arguments for 'HLEN' command")
 		}
-		return protocol.SerializeInteger(store.HLen(parsed[1])), nil
+		return serializeTypedInteger(store.HLen(client.DB, parsed[1]))
 	} else if string(parsed[0]) == "HGETALL" {
 		if len(parsed) < 2 {
 			return protocol.SerializeError("Wrong number of arguments for 'HGETALL' command"), errors.New("Wrong number of arguments for 'HGETALL' command")
 		}
-		response := store.HGetAll(parsed[1])
+		response, err := store.HGetAll(client.DB, parsed[1])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
 		return protocol.SerializeArray(response), nil
 	} else if string(parsed[0]) == "HDEL" {
 		if len(parsed) < 3 {
 			return protocol.SerializeError("Wrong number of arguments for 'HDEL' command"), errors.New("Wrong number of arguments for 'HDEL' command")
 		}
-		return protocol.SerializeInteger(store.HDel(parsed[1], parsed[2])), nil
+		return serializeTypedInteger(store.HDel(client.DB, parsed[1], parsed[2:]...))
 	} else if string(parsed[0]) == "HKEYS" {
 		if len(parsed) < 2 {
 			return protocol.SerializeError("Wrong number of arguments for 'HDEL' command"), errors.New("Wrong number of arguments for 'HDEL' command")
 		}
-		response := store.HKeys(parsed[1])
+		response, err := store.HKeys(client.DB, parsed[1])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
 		return protocol.SerializeArray(response), nil
+	} else if string(parsed[0]) == "HVALS" {
+		if len(parsed) != 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'HVALS' command"), errors.New("Wrong number of arguments for 'HVALS' command")
+		}
+		response, err := store.HVals(client.DB, parsed[1])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		return protocol.SerializeArray(response), nil
+	} else if string(parsed[0]) == "HEXPIRE" || string(parsed[0]) == "HPEXPIRE" || string(parsed[0]) == "HEXPIREAT" || string(parsed[0]) == "HPEXPIREAT" {
+		if len(parsed) < 6 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		return hexpire(parsed, client)
+	} else if string(parsed[0]) == "HTTL" || string(parsed[0]) == "HPTTL" || string(parsed[0]) == "HEXPIRETIME" || string(parsed[0]) == "HPEXPIRETIME" {
+		if len(parsed) < 5 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		return httl(parsed, client)
+	} else if string(parsed[0]) == "HPERSIST" {
+		if len(parsed) < 5 {
+			return protocol.SerializeError("Wrong number of arguments for 'HPERSIST' command"), errors.New("Wrong number of arguments for 'HPERSIST' command")
+		}
+		return hpersist(parsed, client)
+	} else if string(parsed[0]) == "HGETEX" {
+		if len(parsed) < 5 {
+			return protocol.SerializeError("Wrong number of arguments for 'HGETEX' command"), errors.New("Wrong number of arguments for 'HGETEX' command")
+		}
+		return hgetex(parsed, client)
+	} else if string(parsed[0]) == "HSETEX" {
+		if len(parsed) < 6 {
+			return protocol.SerializeError("Wrong number of arguments for 'HSETEX' command"), errors.New("Wrong number of arguments for 'HSETEX' command")
+		}
+		return hsetex(parsed, client)
+	} else if string(parsed[0]) == "HSCAN" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'HSCAN' command"), errors.New("Wrong number of arguments for 'HSCAN' command")
+		}
+		opts, err := parseScan("HSCAN", parsed[2:])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		cursor, items, err := store.HScan(client.DB, parsed[1], opts.cursor, opts.count, opts.match, opts.novalues)
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		return serializeScan(cursor, items), nil
 	} else if string(parsed[0]) == "SADD" {
 		if len(parsed) < 3 {
 			return protocol.SerializeError("Wrong number of arguments for 'SADD' command"), errors.New("Wrong number of arguments for 'SADD' command")
 		}
-		return protocol.SerializeInteger(store.SAdd(parsed[1], parsed[2])), nil
+		return serializeTypedInteger(store.SAdd(client.DB, parsed[1], parsed[2:]...))
 
 	} else if string(parsed[0]) == "SREM" {
 		if len(parsed) < 3 {
 			return protocol.SerializeError("Wrong number of arguments for 'SREM' command"), errors.New("Wrong number of arguments for 'SREM' command")
 		}
-		return protocol.SerializeInteger(store.SRem(parsed[1], parsed[2])), nil
+		return serializeTypedInteger(store.SRem(client.DB, parsed[1], parsed[2:]...))
 	} else if string(parsed[0]) == "SISMEMBER" {
 		if len(parsed) < 3 {
 			return protocol.SerializeError("Wrong number of arguments for 'SISMEMBER' command"), errors.New("Wrong number of arguments for 'SISMEMBER' command")
 		}
-		return protocol.SerializeInteger(store.SIsMember(parsed[1], parsed[2])), nil
+		return serializeTypedInteger(store.SIsMember(client.DB, parsed[1], parsed[2]))
 	} else if string(parsed[0]) == "SCARD" {
 		if len(parsed) < 2 {
 			return protocol.SerializeError("Wrong number of arguments for 'SCARD' command"), errors.New("Wrong number of arguments for 'SCARD' command")
 		}
-		return protocol.SerializeInteger(store.SCard(parsed[1])), nil
+		return serializeTypedInteger(store.SCard(client.DB, parsed[1]))
 	} else if string(parsed[0]) == "SMEMBERS" {
 		if len(parsed) < 2 {
 			return protocol.SerializeError("Wrong number of arguments for 'SMEMBERS' command"), errors.New("Wrong number of arguments for 'SMEMBERS' command")
 		}
-		return protocol.SerializeArray(store.SMembers(parsed[1])), nil
+		members, err := store.SMembers(client.DB, parsed[1])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		return protocol.SerializeArray(members), nil
+	} else if string(parsed[0]) == "SMISMEMBER" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'SMISMEMBER' command"), errors.New("Wrong number of arguments for 'SMISMEMBER' command")
+		}
+		flags, err := store.SMIsMember(client.DB, parsed[1], parsed[2:])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		return serializeIntegers(flags), nil
+	} else if string(parsed[0]) == "SUNION" || string(parsed[0]) == "SINTER" || string(parsed[0]) == "SDIFF" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		op := strings.ToLower(parsed[0][1:])
+		members, err := store.SCombine(client.DB, op, parsed[1:])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		return protocol.SerializeArray(members), nil
+	} else if string(parsed[0]) == "SUNIONSTORE" || string(parsed[0]) == "SINTERSTORE" || string(parsed[0]) == "SDIFFSTORE" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		op := strings.ToLower(strings.TrimSuffix(parsed[0][1:], "STORE"))
+		return serializeTypedInteger(store.SCombineStore(client.DB, parsed[1], op, parsed[2:]))
+	} else if string(parsed[0]) == "SINTERCARD" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'SINTERCARD' command"), errors.New("Wrong number of arguments for 'SINTERCARD' command")
+		}
+		return sintercard(parsed, client)
+	} else if string(parsed[0]) == "SMOVE" {
+		if len(parsed) != 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'SMOVE' command"), errors.New("Wrong number of arguments for 'SMOVE' command")
+		}
+		return serializeTypedInteger(store.SMove(client.DB, parsed[1], parsed[2], parsed[3]))
+	} else if string(parsed[0]) == "SPOP" || string(parsed[0]) == "SRANDMEMBER" {
+		if len(parsed) != 2 && len(parsed) != 3 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		return spop(parsed, client)
+	} else if string(parsed[0]) == "KEYS" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'KEYS' command"), errors.New("Wrong number of arguments for 'KEYS' command")
+		}
+		return protocol.SerializeArray(store.Keys(client.DB, parsed[1])), nil
+	} else if string(parsed[0]) == "SCAN" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'SCAN' command"), errors.New("Wrong number of arguments for 'SCAN' command")
+		}
+		opts, err := parseScan("SCAN", parsed[1:])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		cursor, keys := store.Scan(client.DB, opts.cursor, opts.count, opts.match, opts.typ)
+		return serializeScan(cursor, keys), nil
+	} else if string(parsed[0]) == "RANDOMKEY" {
+		if response, ok := store.RandomKey(client.DB); ok {
+			return protocol.SerializeBulkString(response), nil
+		}
+		return protocol.SerializeNull(), nil
+	} else if string(parsed[0]) == "DBSIZE" {
+		return protocol.SerializeInteger(store.DBSize(client.DB)), nil
+	} else if string(parsed[0]) == "TYPE" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'TYPE' command"), errors.New("Wrong number of arguments for 'TYPE' command")
+		}
+		return protocol.SerializeSimpleString(store.Type(client.DB, parsed[1])), nil
+	} else if string(parsed[0]) == "RENAME" || string(parsed[0]) == "RENAMENX" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		exists, renamed := store.Rename(client.DB, parsed[1], parsed[2], parsed[0] == "RENAMENX")
+		if !exists {
+			return protocol.SerializeError("no such key"), errors.New("no such key")
+		}
+		if parsed[0] == "RENAME" {
+			return protocol.SerializeSimpleString("OK"), nil
+		}
+		if renamed {
+			return protocol.SerializeInteger(1), nil
+		}
+		return protocol.SerializeInteger(0), nil
+	} else if string(parsed[0]) == "COPY" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'COPY' command"), errors.New("Wrong number of arguments for 'COPY' command")
+		}
+		replace := false
+		dstDB := client.DB
+		for i := 3; i < len(parsed); i++ {
+			switch strings.ToUpper(parsed[i]) {
+			case "REPLACE":
+				replace = true
+			case "DB":
+				if i+1 >= len(parsed) {
+					return protocol.SerializeError("syntax error"), errors.New("syntax error")
+				}
+				n, err := parseDB(parsed[i+1])
+				if err != nil {
+					return protocol.SerializeError(err.Error()), err
+				}
+				dstDB = n
+				i++
+			default:
+				return protocol.SerializeError("syntax error"), errors.New("syntax error")
+			}
+		}
+		copied, err := store.Copy(client.DB, parsed[1], dstDB, parsed[2], replace)
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		if copied {
+			return protocol.SerializeInteger(1), nil
+		}
+		return protocol.SerializeInteger(0), nil
+	} else if string(parsed[0]) == "MOVE" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'MOVE' command"), errors.New("Wrong number of arguments for 'MOVE' command")
+		}
+		dstDB, err := parseDB(parsed[2])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		if dstDB == client.DB {
+			return protocol.SerializeError("source and destination objects are the same"), errors.New("source and destination objects are the same")
+		}
+		if store.Move(client.DB, parsed[1], dstDB) {
+			return protocol.SerializeInteger(1), nil
+		}
+		return protocol.SerializeInteger(0), nil
+	} else if string(parsed[0]) == "SELECT" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'SELECT' command"), errors.New("Wrong number of arguments for 'SELECT' command")
+		}
+		db, err := parseDB(parsed[1])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		client.DB = db
+		return protocol.SerializeSimpleString("OK"), nil
+	} else if string(parsed[0]) == "SWAPDB" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'SWAPDB' command"), errors.New("Wrong number of arguments for 'SWAPDB' command")
+		}
+		a, err := parseDB(parsed[1])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		b, err := parseDB(parsed[2])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		store.SwapDB(a, b)
+		return protocol.SerializeSimpleString("OK"), nil
+	} else if string(parsed[0]) == "FLUSHDB" || string(parsed[0]) == "FLUSHALL" {
+		async := false
+		if len(parsed) > 1 {
+			switch strings.ToUpper(parsed[1]) {
+			case "ASYNC":
+				async = true
+			case "SYNC":
+			default:
+				return protocol.SerializeError("syntax error"), errors.New("syntax error")
+			}
+		}
+		if parsed[0] == "FLUSHDB" {
+			store.FlushDB(client.DB, async)
+		} else {
+			store.FlushAll(async)
+		}
+		return protocol.SerializeSimpleString("OK"), nil
+	} else if string(parsed[0]) == "OBJECT" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'OBJECT' command"), errors.New("Wrong number of arguments for 'OBJECT' command")
+		}
+		switch strings.ToUpper(parsed[1]) {
+		case "ENCODING":
+			if response, ok := store.ObjectEncoding(client.DB, parsed[2]); ok {
+				return protocol.SerializeBulkString(response), nil
+			}
+		case "IDLETIME":
+			if response, ok := store.ObjectIdleTime(client.DB, parsed[2]); ok {
+				return protocol.SerializeInteger(response), nil
+			}
+		case "FREQ":
+			if response, ok := store.ObjectFreq(client.DB, parsed[2]); ok {
+				return protocol.SerializeInteger(response), nil
+			}
+		case "REFCOUNT":
+			if response, ok := store.ObjectRefCount(client.DB, parsed[2]); ok {
+				return protocol.SerializeInteger(response), nil
+			}
+		default:
+			return protocol.SerializeError("Unknown OBJECT subcommand"), errors.New("unknown OBJECT subcommand")
+		}
+		return protocol.SerializeNull(), errors.New("No such key")
+	} else if string(parsed[0]) == "MEMORY" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'MEMORY' command"), errors.New("Wrong number of arguments for 'MEMORY' command")
+		}
+		return memory(parsed, client)
+	} else if string(parsed[0]) == "TOUCH" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'TOUCH' command"), errors.New("Wrong number of arguments for 'TOUCH' command")
+		}
+		return protocol.SerializeInteger(store.Touch(client.DB, parsed[1:])), nil
+	} else if string(parsed[0]) == "SSCAN" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'SSCAN' command"), errors.New("Wrong number of arguments for 'SSCAN' command")
+		}
+		opts, err := parseScan("SSCAN", parsed[2:])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		cursor, items, err := store.SScan(client.DB, parsed[1], opts.cursor, opts.count, opts.match)
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		return serializeScan(cursor, items), nil
+	} else if string(parsed[0]) == "ZADD" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'ZADD' command"), errors.New("Wrong number of arguments for 'ZADD' command")
+		}
+		return zadd(parsed, client)
+	} else if string(parsed[0]) == "ZINCRBY" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'ZINCRBY' command"), errors.New("Wrong number of arguments for 'ZINCRBY' command")
+		}
+		incr, err := store.ParseScore(parsed[2])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		score, _, err := store.ZIncrBy(client.DB, parsed[1], store.ZAddOptions{}, incr, parsed[3])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		return protocol.SerializeBulkString(formatScore(score)), nil
+	} else if string(parsed[0]) == "ZREM" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'ZREM' command"), errors.New("Wrong number of arguments for 'ZREM' command")
+		}
+		return serializeTypedInteger(store.ZRem(client.DB, parsed[1], parsed[2:]))
+	} else if string(parsed[0]) == "ZSCORE" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'ZSCORE' command"), errors.New("Wrong number of arguments for 'ZSCORE' command")
+		}
+		score, ok, err := store.ZScore(client.DB, parsed[1], parsed[2])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		if ok {
+			return protocol.SerializeBulkString(formatScore(score)), nil
+		}
+		return protocol.SerializeNull(), nil
+	} else if string(parsed[0]) == "ZMSCORE" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'ZMSCORE' command"), errors.New("Wrong number of arguments for 'ZMSCORE' command")
+		}
+		scores, found, err := store.ZMScore(client.DB, parsed[1], parsed[2:])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		response := make([]string, len(scores))
+		for i := range scores {
+			if found[i] {
+				response[i] = protocol.SerializeBulkString(formatScore(scores[i]))
+			} else {
+				response[i] = protocol.SerializeNull()
+			}
+		}
+		return protocol.SerializeRawArray(response), nil
+	} else if string(parsed[0]) == "ZCARD" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'ZCARD' command"), errors.New("Wrong number of arguments for 'ZCARD' command")
+		}
+		return serializeTypedInteger(store.ZCard(client.DB, parsed[1]))
+	} else if string(parsed[0]) == "ZCOUNT" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'ZCOUNT' command"), errors.New("Wrong number of arguments for 'ZCOUNT' command")
+		}
+		r, err := store.ParseScoreRange(parsed[2], parsed[3])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		return serializeTypedInteger(store.ZCount(client.DB, parsed[1], r))
+	} else if string(parsed[0]) == "ZRANK" || string(parsed[0]) == "ZREVRANK" {
+		if len(parsed) < 3 || len(parsed) > 4 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		return zrank(parsed, client)
+	} else if string(parsed[0]) == "ZRANGE" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'ZRANGE' command"), errors.New("Wrong number of arguments for 'ZRANGE' command")
+		}
+		return zrange(parsed, client)
+	} else if string(parsed[0]) == "ZSCAN" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'ZSCAN' command"), errors.New("Wrong number of arguments for 'ZSCAN' command")
+		}
+		opts, err := parseScan("ZSCAN", parsed[2:])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		cursor, items, err := store.ZScan(client.DB, parsed[1], opts.cursor, opts.count, opts.match)
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		response := make([]string, 0, len(items)*2)
+		for _, item := range items {
+			response = append(response, item.Member, formatScore(item.Score))
+		}
+		return serializeScan(cursor, response), nil
+	} else if string(parsed[0]) == "ZUNION" || string(parsed[0]) == "ZINTER" || string(parsed[0]) == "ZDIFF" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		return zcombine(parsed, client)
+	} else if string(parsed[0]) == "ZUNIONSTORE" || string(parsed[0]) == "ZINTERSTORE" || string(parsed[0]) == "ZDIFFSTORE" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		return zcombine(parsed, client)
+	} else if string(parsed[0]) == "ZRANGESTORE" {
+		if len(parsed) < 5 {
+			return protocol.SerializeError("Wrong number of arguments for 'ZRANGESTORE' command"), errors.New("Wrong number of arguments for 'ZRANGESTORE' command")
+		}
+		return zrangestore(parsed, client)
+	} else if string(parsed[0]) == "ZPOPMIN" || string(parsed[0]) == "ZPOPMAX" {
+		if len(parsed) != 2 && len(parsed) != 3 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		return zpop(parsed, client)
+	} else if string(parsed[0]) == "ZRANDMEMBER" {
+		if len(parsed) < 2 || len(parsed) > 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'ZRANDMEMBER' command"), errors.New("Wrong number of arguments for 'ZRANDMEMBER' command")
+		}
+		return zrandmember(parsed, client)
+	} else if string(parsed[0]) == "ZREMRANGEBYRANK" || string(parsed[0]) == "ZREMRANGEBYSCORE" || string(parsed[0]) == "ZREMRANGEBYLEX" {
+		if len(parsed) != 4 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		return zremrange(parsed, client)
+	} else if string(parsed[0]) == "ZLEXCOUNT" {
+		if len(parsed) != 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'ZLEXCOUNT' command"), errors.New("Wrong number of arguments for 'ZLEXCOUNT' command")
+		}
+		r, err := store.ParseLexRange(parsed[2], parsed[3])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		return serializeTypedInteger(store.ZLexCount(client.DB, parsed[1], r))
+	} else if string(parsed[0]) == "XADD" {
+		if len(parsed) < 5 {
+			return protocol.SerializeError("Wrong number of arguments for 'XADD' command"), errors.New("Wrong number of arguments for 'XADD' command")
+		}
+		return xadd(parsed, client)
+	} else if string(parsed[0]) == "XRANGE" || string(parsed[0]) == "XREVRANGE" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for '" + parsed[0] + "' command"), errors.New("Wrong number of arguments for '" + parsed[0] + "' command")
+		}
+		return xrange(parsed, client)
+	} else if string(parsed[0]) == "XLEN" {
+		if len(parsed) != 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'XLEN' command"), errors.New("Wrong number of arguments for 'XLEN' command")
+		}
+		return serializeTypedInteger(store.XLen(client.DB, parsed[1]))
+	} else if string(parsed[0]) == "XDEL" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'XDEL' command"), errors.New("Wrong number of arguments for 'XDEL' command")
+		}
+		ids, err := parseStreamIDs(parsed[2:])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		return serializeTypedInteger(store.XDel(client.DB, parsed[1], ids))
+	} else if string(parsed[0]) == "XTRIM" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'XTRIM' command"), errors.New("Wrong number of arguments for 'XTRIM' command")
+		}
+		return xtrim(parsed, client)
+	} else if string(parsed[0]) == "XREAD" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'XREAD' command"), errors.New("Wrong number of arguments for 'XREAD' command")
+		}
+		return xread(parsed, client)
+	} else if string(parsed[0]) == "XREADGROUP" {
+		if len(parsed) < 7 {
+			return protocol.SerializeError("Wrong number of arguments for 'XREADGROUP' command"), errors.New("Wrong number of arguments for 'XREADGROUP' command")
+		}
+		return xreadgroup(parsed, client)
+	} else if string(parsed[0]) == "XGROUP" {
+		if len(parsed) < 2 {
+			return protocol.SerializeError("Wrong number of arguments for 'XGROUP' command"), errors.New("Wrong number of arguments for 'XGROUP' command")
+		}
+		return xgroup(parsed, client)
+	} else if string(parsed[0]) == "XACK" {
+		if len(parsed) < 4 {
+			return protocol.SerializeError("Wrong number of arguments for 'XACK' command"), errors.New("Wrong number of arguments for 'XACK' command")
+		}
+		ids, err := parseStreamIDs(parsed[3:])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		return serializeTypedInteger(store.XAck(client.DB, parsed[1], parsed[2], ids))
+	} else if string(parsed[0]) == "XPENDING" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'XPENDING' command"), errors.New("Wrong number of arguments for 'XPENDING' command")
+		}
+		return xpending(parsed, client)
+	} else if string(parsed[0]) == "XCLAIM" {
+		if len(parsed) < 6 {
+			return protocol.SerializeError("Wrong number of arguments for 'XCLAIM' command"), errors.New("Wrong number of arguments for 'XCLAIM' command")
+		}
+		return xclaim(parsed, client)
+	} else if string(parsed[0]) == "XAUTOCLAIM" {
+		if len(parsed) < 6 {
+			return protocol.SerializeError("Wrong number of arguments for 'XAUTOCLAIM' command"), errors.New("Wrong number of arguments for 'XAUTOCLAIM' command")
+		}
+		return xautoclaim(parsed, client)
+	} else if string(parsed[0]) == "XINFO" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'XINFO' command"), errors.New("Wrong number of arguments for 'XINFO' command")
+		}
+		return xinfo(parsed, client)
 	} else if string(parsed[0]) == "SAVE" {
 		if persistence.Save() {
 			return protocol.SerializeSimpleString("OK"), nil
@@ -213,14 +1126,14 @@ func Route(parsed []string, conn net.Conn) (string, error) {
 		if len(parsed) < 2 {
 			return protocol.SerializeError("Wrong number of arguments for 'SUBSCRIBE' command"), errors.New("Wrong number of arguments for 'SUBSCRIBE' command")
 		}
-		response := pubsub.Subscribe(parsed[1], conn)
+		response := pubsub.Subscribe(parsed[1], client.Conn)
 		return protocol.SerializeArray([]string{"subscribe", parsed[1], strconv.Itoa(response)}), nil
 
 	} else if string(parsed[0]) == "UNSUBSCRIBE" {
 		if len(parsed) < 2 {
 			return protocol.SerializeError("Wrong number of arguments for 'UNSUBSCRIBE' command"), errors.New("Wrong number of arguments for 'UNSUBSCRIBE' command")
 		}
-		pubsub.Unsubscribe(parsed[1], conn)
+		pubsub.Unsubscribe(parsed[1], client.Conn)
 		return protocol.SerializeSimpleString("Unsubscribed successfully"), nil
 	} else if string(parsed[0]) == "PUBLISH" {
 		if len(parsed) < 3 {
@@ -228,6 +1141,29 @@ func Route(parsed []string, conn net.Conn) (string, error) {
 		}
 		response := pubsub.Publish(parsed[1], parsed[2])
 		return protocol.SerializeInteger(response), nil
+	} else if string(parsed[0]) == "CONFIG" {
+		if len(parsed) < 3 {
+			return protocol.SerializeError("Wrong number of arguments for 'CONFIG' command"), errors.New("Wrong number of arguments for 'CONFIG' command")
+		}
+		switch strings.ToUpper(parsed[1]) {
+		case "GET":
+			return protocol.SerializeArray(config.Get(parsed[2])), nil
+		case "SET":
+			if len(parsed) < 4 {
+				return protocol.SerializeError("Wrong number of arguments for 'CONFIG SET' command"), errors.New("Wrong number of arguments for 'CONFIG SET' command")
+			}
+			if err := config.Set(parsed[2], parsed[3]); err != nil {
+				return protocol.SerializeError(err.Error()), err
+			}
+			return protocol.SerializeSimpleString("OK"), nil
+		}
+		return protocol.SerializeError("Unknown CONFIG subcommand"), errors.New("unknown CONFIG subcommand")
+	} else if string(parsed[0]) == "INFO" {
+		section := ""
+		if len(parsed) > 1 {
+			section = parsed[1]
+		}
+		return protocol.SerializeBulkString(info(section)), nil
 	} else {
 		return protocol.SerializeError("Invalid operation"), errors.New("invalid Operation")
 	}
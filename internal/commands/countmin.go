@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"litekv/internal/protocol"
+	"litekv/internal/store"
+)
+
+// cmsMaxCounters bounds the size of a sketch to 512MB of counters.
+const cmsMaxCounters = 1 << 27
+
+func serializeCounts(counts []uint32) string {
+	response := make([]string, len(counts))
+	for i, n := range counts {
+		response[i] = protocol.SerializeInteger(int(n))
+	}
+	return protocol.SerializeRawArray(response)
+}
+
+// cmsinitbydim implements CMS.INITBYDIM key width depth.
+func cmsinitbydim(parsed []string, client *Client) (string, error) {
+	width, err := strconv.Atoi(parsed[2])
+	if err != nil || width <= 0 {
+		return protocol.SerializeError("CMS: invalid width"), errors.New("CMS: invalid width")
+	}
+	depth, err := strconv.Atoi(parsed[3])
+	if err != nil || depth <= 0 {
+		return protocol.SerializeError("CMS: invalid depth"), errors.New("CMS: invalid depth")
+	}
+	if width > cmsMaxCounters/depth {
+		return protocol.SerializeError("CMS: sketch would exceed the maximum size"), errors.New("CMS: sketch would exceed the maximum size")
+	}
+	if err := store.CMSInitByDim(client.DB, parsed[1], width, depth); err != nil {
+		return serializeTypedError(err), err
+	}
+	return protocol.SerializeSimpleString("OK"), nil
+}
+
+// cmsincrby implements CMS.INCRBY key item increment [item increment ...].
+func cmsincrby(parsed []string, client *Client) (string, error) {
+	if len(parsed)%2 != 0 {
+		return protocol.SerializeError("Wrong number of arguments for 'CMS.INCRBY' command"), errors.New("Wrong number of arguments for 'CMS.INCRBY' command")
+	}
+	items := make([]store.CMSItem, 0, (len(parsed)-2)/2)
+	for i := 2; i < len(parsed); i += 2 {
+		incr, err := strconv.ParseUint(parsed[i+1], 10, 32)
+		if err != nil {
+			return protocol.SerializeError("CMS: Cannot parse number"), errors.New("CMS: Cannot parse number")
+		}
+		items = append(items, store.CMSItem{Item: parsed[i], Incr: uint32(incr)})
+	}
+	counts, err := store.CMSIncrBy(client.DB, parsed[1], items)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return serializeCounts(counts), nil
+}
+
+// cmsquery implements CMS.QUERY key item [item ...].
+func cmsquery(parsed []string, client *Client) (string, error) {
+	counts, err := store.CMSQuery(client.DB, parsed[1], parsed[2:])
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return serializeCounts(counts), nil
+}
+
+// cmsmerge implements CMS.MERGE destination numKeys source [source ...]
+// [WEIGHTS weight [weight ...]].
+func cmsmerge(parsed []string, client *Client) (string, error) {
+	numKeys, err := strconv.Atoi(parsed[2])
+	if err != nil || numKeys <= 0 {
+		return protocol.SerializeError("CMS: invalid numkeys"), errors.New("CMS: invalid numkeys")
+	}
+	if len(parsed) < 3+numKeys {
+		return protocol.SerializeError("CMS: wrong number of keys"), errors.New("CMS: wrong number of keys")
+	}
+	keys := parsed[3 : 3+numKeys]
+	weights := make([]int64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	if args := parsed[3+numKeys:]; len(args) > 0 {
+		if strings.ToUpper(args[0]) != "WEIGHTS" || len(args)-1 != numKeys {
+			return protocol.SerializeError("CMS: wrong number of keys/weights"), errors.New("CMS: wrong number of keys/weights")
+		}
+		for i, w := range args[1:] {
+			if weights[i], err = strconv.ParseInt(w, 10, 64); err != nil || weights[i] > math.MaxInt32 || weights[i] < math.MinInt32 {
+				return protocol.SerializeError("CMS: invalid weight value"), errors.New("CMS: invalid weight value")
+			}
+		}
+	}
+	if err := store.CMSMerge(client.DB, parsed[1], keys, weights); err != nil {
+		return serializeTypedError(err), err
+	}
+	return protocol.SerializeSimpleString("OK"), nil
+}
@@ -0,0 +1,271 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"litekv/internal/protocol"
+	"litekv/internal/store"
+)
+
+// geoUnits converts the distance units of the geo commands to meters.
+var geoUnits = map[string]float64{"M": 1, "KM": 1000, "FT": 0.3048, "MI": 1609.34}
+
+func parseGeoUnit(s string) (float64, error) {
+	unit, ok := geoUnits[strings.ToUpper(s)]
+	if !ok {
+		return 0, errors.New("unsupported unit provided. please use M, KM, FT, MI")
+	}
+	return unit, nil
+}
+
+// parseGeoPoint parses a longitude and a latitude and checks they can be
+// indexed.
+func parseGeoPoint(lon string, lat string) (store.GeoPoint, error) {
+	var p store.GeoPoint
+	var err error
+	if p.Lon, err = strconv.ParseFloat(lon, 64); err != nil {
+		return p, errors.New("value is not a valid float")
+	}
+	if p.Lat, err = strconv.ParseFloat(lat, 64); err != nil {
+		return p, errors.New("value is not a valid float")
+	}
+	if !store.ValidGeoPoint(p) {
+		return p, fmt.Errorf("invalid longitude,latitude pair %f,%f", p.Lon, p.Lat)
+	}
+	return p, nil
+}
+
+func formatGeoDistance(meters float64, unit float64) string {
+	return strconv.FormatFloat(meters/unit, 'f', 4, 64)
+}
+
+func serializeGeoPoint(p store.GeoPoint) string {
+	return protocol.SerializeArray([]string{
+		strconv.FormatFloat(p.Lon, 'f', -1, 64),
+		strconv.FormatFloat(p.Lat, 'f', -1, 64),
+	})
+}
+
+// geoadd implements GEOADD key [NX|XX] [CH] longitude latitude member
+// [longitude latitude member ...].
+func geoadd(parsed []string, client *Client) (string, error) {
+	var opts store.ZAddOptions
+	args := parsed[2:]
+	for len(args) > 0 {
+		switch strings.ToUpper(args[0]) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "CH":
+			opts.CH = true
+		default:
+			goto points
+		}
+		args = args[1:]
+	}
+points:
+	if len(args) == 0 || len(args)%3 != 0 {
+		return protocol.SerializeError("syntax error"), errors.New("syntax error")
+	}
+	if opts.NX && opts.XX {
+		return protocol.SerializeError("XX and NX options at the same time are not compatible"), errors.New("XX and NX options at the same time are not compatible")
+	}
+	points := make([]store.GeoPoint, 0, len(args)/3)
+	members := make([]string, 0, len(args)/3)
+	for i := 0; i < len(args); i += 3 {
+		p, err := parseGeoPoint(args[i], args[i+1])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		points = append(points, p)
+		members = append(members, args[i+2])
+	}
+	return serializeTypedInteger(store.GeoAdd(client.DB, parsed[1], opts, points, members))
+}
+
+// geodist implements GEODIST key member1 member2 [M|KM|FT|MI].
+func geodist(parsed []string, client *Client) (string, error) {
+	unit := 1.0
+	if len(parsed) == 5 {
+		var err error
+		if unit, err = parseGeoUnit(parsed[4]); err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+	}
+	dist, ok, err := store.GeoDist(client.DB, parsed[1], parsed[2], parsed[3])
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !ok {
+		return protocol.SerializeNull(), nil
+	}
+	return protocol.SerializeBulkString(formatGeoDistance(dist, unit)), nil
+}
+
+// geopos implements GEOPOS key [member ...].
+func geopos(parsed []string, client *Client) (string, error) {
+	points, found, err := store.GeoPos(client.DB, parsed[1], parsed[2:])
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	response := make([]string, len(points))
+	for i, p := range points {
+		if found[i] {
+			response[i] = serializeGeoPoint(p)
+		} else {
+			response[i] = protocol.SerializeNullArray()
+		}
+	}
+	return protocol.SerializeRawArray(response), nil
+}
+
+// geohash implements GEOHASH key [member ...].
+func geohash(parsed []string, client *Client) (string, error) {
+	hashes, found, err := store.GeoHash(client.DB, parsed[1], parsed[2:])
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	response := make([]string, len(hashes))
+	for i, hash := range hashes {
+		if found[i] {
+			response[i] = protocol.SerializeBulkString(hash)
+		} else {
+			response[i] = protocol.SerializeNull()
+		}
+	}
+	return protocol.SerializeRawArray(response), nil
+}
+
+// geosearch implements
+//
+//	GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude
+//	  BYRADIUS radius unit|BYBOX width height unit [ASC|DESC]
+//	  [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+//
+// and GEOSEARCHSTORE destination source with the same options, where the
+// WITH flags are replaced by STOREDIST.
+func geosearch(parsed []string, client *Client) (string, error) {
+	storing := parsed[0] == "GEOSEARCHSTORE"
+	key, args := parsed[1], parsed[2:]
+	if storing {
+		key, args = parsed[2], parsed[3:]
+	}
+	var q store.GeoQuery
+	fromLonLat, byRadius := false, false
+	withCoord, withDist, withHash, storeDist := false, false, false, false
+	unit := 1.0
+	syntaxError := errors.New("syntax error")
+	for len(args) > 0 {
+		var err error
+		option := strings.ToUpper(args[0])
+		switch {
+		case option == "FROMMEMBER" && len(args) >= 2:
+			q.FromMember, q.Member = true, args[1]
+			args = args[2:]
+		case option == "FROMLONLAT" && len(args) >= 3:
+			fromLonLat = true
+			q.Center, err = parseGeoPoint(args[1], args[2])
+			args = args[3:]
+		case option == "BYRADIUS" && len(args) >= 3:
+			byRadius = true
+			if q.Radius, err = strconv.ParseFloat(args[1], 64); err != nil {
+				err = errors.New("need numeric radius")
+			} else if q.Radius < 0 {
+				err = errors.New("radius cannot be negative")
+			} else if unit, err = parseGeoUnit(args[2]); err == nil {
+				q.Radius *= unit
+			}
+			args = args[3:]
+		case option == "BYBOX" && len(args) >= 4:
+			q.Box = true
+			var widthErr, heightErr error
+			q.Width, widthErr = strconv.ParseFloat(args[1], 64)
+			q.Height, heightErr = strconv.ParseFloat(args[2], 64)
+			if widthErr != nil || heightErr != nil {
+				err = errors.New("need numeric width and height")
+			} else if q.Width < 0 || q.Height < 0 {
+				err = errors.New("height or width cannot be negative")
+			} else if unit, err = parseGeoUnit(args[3]); err == nil {
+				q.Width *= unit
+				q.Height *= unit
+			}
+			args = args[4:]
+		case option == "ASC" || option == "DESC":
+			q.Sort = option
+			args = args[1:]
+		case option == "COUNT" && len(args) >= 2:
+			if q.Count, err = strconv.Atoi(args[1]); err != nil {
+				err = errors.New("value is not an integer or out of range")
+			} else if q.Count <= 0 {
+				err = errors.New("COUNT must be > 0")
+			}
+			args = args[2:]
+		case option == "ANY":
+			q.Any = true
+			args = args[1:]
+		case option == "WITHCOORD" && !storing:
+			withCoord = true
+			args = args[1:]
+		case option == "WITHDIST" && !storing:
+			withDist = true
+			args = args[1:]
+		case option == "WITHHASH" && !storing:
+			withHash = true
+			args = args[1:]
+		case option == "STOREDIST" && storing:
+			storeDist = true
+			args = args[1:]
+		default:
+			err = syntaxError
+		}
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+	}
+	if q.FromMember == fromLonLat {
+		err := errors.New("exactly one of FROMMEMBER or FROMLONLAT can be specified for " + strings.ToLower(parsed[0]))
+		return protocol.SerializeError(err.Error()), err
+	}
+	if q.Box == byRadius {
+		err := errors.New("exactly one of BYRADIUS and BYBOX can be specified for " + strings.ToLower(parsed[0]))
+		return protocol.SerializeError(err.Error()), err
+	}
+	if q.Any && q.Count == 0 {
+		return protocol.SerializeError("the ANY argument requires COUNT argument"), errors.New("the ANY argument requires COUNT argument")
+	}
+	if q.Count > 0 && q.Sort == "" && !q.Any {
+		// the nearest count members, as Redis does
+		q.Sort = "ASC"
+	}
+
+	if storing {
+		return serializeTypedInteger(store.GeoSearchStore(client.DB, parsed[1], key, q, storeDist, unit))
+	}
+	results, err := store.GeoSearch(client.DB, key, q)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	response := make([]string, len(results))
+	for i, r := range results {
+		if !withDist && !withHash && !withCoord {
+			response[i] = protocol.SerializeBulkString(r.Member)
+			continue
+		}
+		item := []string{protocol.SerializeBulkString(r.Member)}
+		if withDist {
+			item = append(item, protocol.SerializeBulkString(formatGeoDistance(r.Dist, unit)))
+		}
+		if withHash {
+			item = append(item, protocol.SerializeInteger(int(r.Score)))
+		}
+		if withCoord {
+			item = append(item, serializeGeoPoint(r.Point))
+		}
+		response[i] = protocol.SerializeRawArray(item)
+	}
+	return protocol.SerializeRawArray(response), nil
+}
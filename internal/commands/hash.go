@@ -0,0 +1,296 @@
+package commands
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"litekv/internal/protocol"
+	"litekv/internal/store"
+)
+
+// hmget implements HMGET key field [field ...].
+func hmget(parsed []string, client *Client) (string, error) {
+	values, found, err := store.HMGet(client.DB, parsed[1], parsed[2:])
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	response := make([]string, len(values))
+	for i, value := range values {
+		if found[i] {
+			response[i] = protocol.SerializeBulkString(value)
+		} else {
+			response[i] = protocol.SerializeNull()
+		}
+	}
+	return protocol.SerializeRawArray(response), nil
+}
+
+// hincrby implements HINCRBY key field increment.
+func hincrby(parsed []string, client *Client) (string, error) {
+	delta, err := strconv.ParseInt(parsed[3], 10, 64)
+	if err != nil {
+		return protocol.SerializeError("value is not an integer or out of range"), errors.New("value is not an integer or out of range")
+	}
+	n, err := store.HIncrBy(client.DB, parsed[1], parsed[2], delta)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return protocol.SerializeInteger(int(n)), nil
+}
+
+// hincrbyfloat implements HINCRBYFLOAT key field increment.
+func hincrbyfloat(parsed []string, client *Client) (string, error) {
+	delta, err := strconv.ParseFloat(parsed[3], 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return protocol.SerializeError("value is not a valid float"), errors.New("value is not a valid float")
+	}
+	value, err := store.HIncrByFloat(client.DB, parsed[1], parsed[2], delta)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return protocol.SerializeBulkString(value), nil
+}
+
+// hrandfield implements HRANDFIELD key [count [WITHVALUES]].
+func hrandfield(parsed []string, client *Client) (string, error) {
+	if len(parsed) == 2 {
+		fields, _, err := store.HRandField(client.DB, parsed[1], 1)
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		if len(fields) == 0 {
+			return protocol.SerializeNull(), nil
+		}
+		return protocol.SerializeBulkString(fields[0]), nil
+	}
+	count, err := strconv.Atoi(parsed[2])
+	if err != nil {
+		return protocol.SerializeError("value is not an integer or out of range"), errors.New("value is not an integer or out of range")
+	}
+	withValues := false
+	if len(parsed) == 4 {
+		if strings.ToUpper(parsed[3]) != "WITHVALUES" {
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+		withValues = true
+	}
+	fields, values, err := store.HRandField(client.DB, parsed[1], count)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !withValues {
+		return protocol.SerializeArray(fields), nil
+	}
+	response := make([]string, 0, len(fields)*2)
+	for i := range fields {
+		response = append(response, fields[i], values[i])
+	}
+	return protocol.SerializeArray(response), nil
+}
+
+// parseFields parses the FIELDS numfields field [field ...] tail of the hash
+// field TTL commands. per is the number of arguments per field: 1, or 2 for
+// HSETEX field/value pairs.
+func parseFields(args []string, per int) ([]string, error) {
+	if len(args) < 2 || strings.ToUpper(args[0]) != "FIELDS" {
+		return nil, errors.New("Mandatory argument FIELDS is missing or not at the right position")
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n <= 0 {
+		return nil, errors.New("Parameter `numFields` should be greater than 0")
+	}
+	if len(args)-2 != n*per {
+		return nil, errors.New("The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+// parseExpireAt turns a relative or absolute expire time option (EX, PX,
+// EXAT, PXAT) into a time.
+func parseExpireAt(option string, arg string, command string) (time.Time, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("value is not an integer or out of range")
+	}
+	if n < 0 {
+		return time.Time{}, errors.New("invalid expire time in '" + strings.ToLower(command) + "' command")
+	}
+	switch option {
+	case "EX":
+		return time.Now().Add(time.Duration(n) * time.Second), nil
+	case "PX":
+		return time.Now().Add(time.Duration(n) * time.Millisecond), nil
+	case "EXAT":
+		return time.Unix(n, 0), nil
+	}
+	return time.UnixMilli(n), nil
+}
+
+func serializeIntegers(values []int) string {
+	response := make([]string, len(values))
+	for i, v := range values {
+		response[i] = protocol.SerializeInteger(v)
+	}
+	return protocol.SerializeRawArray(response)
+}
+
+// hexpire implements HEXPIRE, HPEXPIRE, HEXPIREAT and HPEXPIREAT key time
+// [NX|XX|GT|LT] FIELDS numfields field [field ...].
+func hexpire(parsed []string, client *Client) (string, error) {
+	option := map[string]string{"HEXPIRE": "EX", "HPEXPIRE": "PX", "HEXPIREAT": "EXAT", "HPEXPIREAT": "PXAT"}[parsed[0]]
+	at, err := parseExpireAt(option, parsed[2], parsed[0])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	rest := parsed[3:]
+	cond := ""
+	if len(rest) > 0 {
+		switch c := strings.ToUpper(rest[0]); c {
+		case "NX", "XX", "GT", "LT":
+			cond, rest = c, rest[1:]
+		}
+	}
+	fields, err := parseFields(rest, 1)
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	response, err := store.HExpire(client.DB, parsed[1], at, cond, fields)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return serializeIntegers(response), nil
+}
+
+// httl implements HTTL, HPTTL, HEXPIRETIME and HPEXPIRETIME key FIELDS
+// numfields field [field ...].
+func httl(parsed []string, client *Client) (string, error) {
+	fields, err := parseFields(parsed[2:], 1)
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	now := time.Now().UnixMilli()
+	times, err := store.HExpireTime(client.DB, parsed[1], fields)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	response := make([]int, len(times))
+	for i, at := range times {
+		if at < 0 {
+			response[i] = int(at)
+			continue
+		}
+		switch parsed[0] {
+		case "HTTL":
+			response[i] = int((max(at-now, 0) + 500) / 1000)
+		case "HPTTL":
+			response[i] = int(max(at-now, 0))
+		case "HEXPIRETIME":
+			response[i] = int(at / 1000)
+		default:
+			response[i] = int(at)
+		}
+	}
+	return serializeIntegers(response), nil
+}
+
+// hpersist implements HPERSIST key FIELDS numfields field [field ...].
+func hpersist(parsed []string, client *Client) (string, error) {
+	fields, err := parseFields(parsed[2:], 1)
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	response, err := store.HPersist(client.DB, parsed[1], fields)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return serializeIntegers(response), nil
+}
+
+// hgetex implements HGETEX key [EX seconds|PX milliseconds|EXAT
+// unix-time-seconds|PXAT unix-time-milliseconds|PERSIST] FIELDS numfields
+// field [field ...].
+func hgetex(parsed []string, client *Client) (string, error) {
+	var ttl store.FieldTTL
+	rest := parsed[2:]
+	if len(rest) > 0 {
+		switch option := strings.ToUpper(rest[0]); option {
+		case "EX", "PX", "EXAT", "PXAT":
+			if len(rest) < 2 {
+				return protocol.SerializeError("syntax error"), errors.New("syntax error")
+			}
+			at, err := parseExpireAt(option, rest[1], parsed[0])
+			if err != nil {
+				return protocol.SerializeError(err.Error()), err
+			}
+			ttl.Set, ttl.At, rest = true, at, rest[2:]
+		case "PERSIST":
+			ttl.Persist, rest = true, rest[1:]
+		}
+	}
+	fields, err := parseFields(rest, 1)
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	values, found, err := store.HGetEx(client.DB, parsed[1], fields, ttl)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	response := make([]string, len(values))
+	for i, value := range values {
+		if found[i] {
+			response[i] = protocol.SerializeBulkString(value)
+		} else {
+			response[i] = protocol.SerializeNull()
+		}
+	}
+	return protocol.SerializeRawArray(response), nil
+}
+
+// hsetex implements HSETEX key [FNX|FXX] [EX seconds|PX milliseconds|EXAT
+// unix-time-seconds|PXAT unix-time-milliseconds|KEEPTTL] FIELDS numfields
+// field value [field value ...].
+func hsetex(parsed []string, client *Client) (string, error) {
+	var ttl store.FieldTTL
+	cond := ""
+	rest := parsed[2:]
+	for len(rest) > 0 && strings.ToUpper(rest[0]) != "FIELDS" {
+		switch option := strings.ToUpper(rest[0]); option {
+		case "FNX", "FXX":
+			if cond != "" {
+				return protocol.SerializeError("syntax error"), errors.New("syntax error")
+			}
+			cond, rest = option, rest[1:]
+		case "EX", "PX", "EXAT", "PXAT":
+			if ttl.Set || ttl.Keep || len(rest) < 2 {
+				return protocol.SerializeError("syntax error"), errors.New("syntax error")
+			}
+			at, err := parseExpireAt(option, rest[1], parsed[0])
+			if err != nil {
+				return protocol.SerializeError(err.Error()), err
+			}
+			ttl.Set, ttl.At, rest = true, at, rest[2:]
+		case "KEEPTTL":
+			if ttl.Set {
+				return protocol.SerializeError("syntax error"), errors.New("syntax error")
+			}
+			ttl.Keep, rest = true, rest[1:]
+		default:
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+	}
+	fieldValues, err := parseFields(rest, 2)
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	set, err := store.HSetEx(client.DB, parsed[1], cond, fieldValues, ttl)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if set {
+		return protocol.SerializeInteger(1), nil
+	}
+	return protocol.SerializeInteger(0), nil
+}
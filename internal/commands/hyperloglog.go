@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"errors"
+
+	"litekv/internal/protocol"
+	"litekv/internal/store"
+)
+
+// serializeHLLError replies to a HyperLogLog error with the code Redis uses.
+func serializeHLLError(err error) string {
+	if errors.Is(err, store.ErrCorruptHLL) {
+		return protocol.SerializeErrorCode("INVALIDOBJ", err.Error())
+	}
+	return protocol.SerializeErrorCode("WRONGTYPE", err.Error())
+}
+
+// pfadd implements PFADD key [element ...].
+func pfadd(parsed []string, client *Client) (string, error) {
+	changed, err := store.PFAdd(client.DB, parsed[1], parsed[2:])
+	if err != nil {
+		return serializeHLLError(err), err
+	}
+	return protocol.SerializeInteger(changed), nil
+}
+
+// pfcount implements PFCOUNT key [key ...].
+func pfcount(parsed []string, client *Client) (string, error) {
+	count, err := store.PFCount(client.DB, parsed[1:])
+	if err != nil {
+		return serializeHLLError(err), err
+	}
+	return protocol.SerializeInteger(int(count)), nil
+}
+
+// pfmerge implements PFMERGE destkey [sourcekey ...].
+func pfmerge(parsed []string, client *Client) (string, error) {
+	if err := store.PFMerge(client.DB, parsed[1], parsed[2:]); err != nil {
+		return serializeHLLError(err), err
+	}
+	return protocol.SerializeSimpleString("OK"), nil
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+
+	"litekv/internal/config"
+	"litekv/internal/expiry"
+	"litekv/internal/store"
+)
+
+// info builds the INFO reply. An empty section returns every section.
+func info(section string) string {
+	section = strings.ToLower(section)
+	var b strings.Builder
+
+	if section == "" || section == "memory" {
+		used := store.UsedMemory()
+		limit := config.MaxMemory()
+		b.WriteString("# Memory\r\n")
+		b.WriteString("used_memory:" + strconv.FormatInt(used, 10) + "\r\n")
+		b.WriteString("used_memory_human:" + humanBytes(used) + "\r\n")
+		b.WriteString("maxmemory:" + strconv.FormatInt(limit, 10) + "\r\n")
+		b.WriteString("maxmemory_human:" + humanBytes(limit) + "\r\n")
+		b.WriteString("maxmemory_policy:" + config.MaxMemoryPolicy() + "\r\n")
+		b.WriteString("\r\n")
+	}
+
+	if section == "" || section == "stats" {
+		stats := expiry.GetStats()
+		b.WriteString("# Stats\r\n")
+		b.WriteString("expired_keys:" + strconv.FormatInt(stats.ExpiredKeys, 10) + "\r\n")
+		b.WriteString("expired_subkeys:" + strconv.FormatInt(stats.ExpiredFields, 10) + "\r\n")
+		b.WriteString("expire_cycles:" + strconv.FormatInt(stats.Cycles, 10) + "\r\n")
+		b.WriteString("expire_cycles_timed_out:" + strconv.FormatInt(stats.TimedOutCycles, 10) + "\r\n")
+		b.WriteString("expire_cycle_last_us:" + strconv.FormatInt(stats.LastCycle.Microseconds(), 10) + "\r\n")
+		b.WriteString("expire_cycle_cpu_milliseconds:" + strconv.FormatInt(stats.TotalCycleTime.Milliseconds(), 10) + "\r\n")
+		b.WriteString("evicted_keys:" + strconv.FormatInt(store.EvictedKeys(), 10) + "\r\n")
+		b.WriteString("\r\n")
+	}
+
+	if section == "" || section == "keyspace" {
+		b.WriteString("# Keyspace\r\n")
+		for db := 0; db < store.Databases(); db++ {
+			keys := store.DBSize(db)
+			if keys == 0 {
+				continue
+			}
+			b.WriteString("db" + strconv.Itoa(db) + ":keys=" + strconv.Itoa(keys) + ",expires=" + strconv.Itoa(store.Expires(db)) + "\r\n")
+		}
+	}
+
+	return b.String()
+}
+
+// humanBytes formats a byte count the way INFO does, e.g. 1.50M.
+func humanBytes(n int64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64) + units[i]
+}
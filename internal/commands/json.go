@@ -0,0 +1,200 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"litekv/internal/protocol"
+	"litekv/internal/store"
+)
+
+// Commands taking a path reply with one entry per match for JSONPath paths
+// ($...), and with a single value for legacy paths, where no match is an
+// error.
+
+func jsonPathArg(parsed []string, i int) string {
+	if len(parsed) > i {
+		return parsed[i]
+	}
+	return "$"
+}
+
+// jsonset implements JSON.SET key path value [NX|XX].
+func jsonset(parsed []string, client *Client) (string, error) {
+	nx, xx := false, false
+	if len(parsed) == 5 {
+		switch strings.ToUpper(parsed[4]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+	}
+	written, err := store.JSONSet(client.DB, parsed[1], parsed[2], parsed[3], nx, xx)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !written {
+		return protocol.SerializeNull(), nil
+	}
+	return protocol.SerializeSimpleString("OK"), nil
+}
+
+// jsonget implements JSON.GET key [INDENT indent] [NEWLINE newline]
+// [SPACE space] [path ...].
+func jsonget(parsed []string, client *Client) (string, error) {
+	var format store.JSONFormat
+	args := parsed[2:]
+	for len(args) >= 2 {
+		switch strings.ToUpper(args[0]) {
+		case "INDENT":
+			format.Indent = args[1]
+		case "NEWLINE":
+			format.Newline = args[1]
+		case "SPACE":
+			format.Space = args[1]
+		default:
+			goto paths
+		}
+		args = args[2:]
+	}
+paths:
+	value, ok, err := store.JSONGet(client.DB, parsed[1], args, format)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !ok {
+		return protocol.SerializeNull(), nil
+	}
+	return protocol.SerializeBulkString(value), nil
+}
+
+// jsonmget implements JSON.MGET key [key ...] path.
+func jsonmget(parsed []string, client *Client) (string, error) {
+	values, found, err := store.JSONMGet(client.DB, parsed[1:len(parsed)-1], parsed[len(parsed)-1])
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	response := make([]string, len(values))
+	for i, v := range values {
+		if found[i] {
+			response[i] = protocol.SerializeBulkString(v)
+		} else {
+			response[i] = protocol.SerializeNull()
+		}
+	}
+	return protocol.SerializeRawArray(response), nil
+}
+
+// jsondel implements JSON.DEL key [path] and its alias JSON.FORGET.
+func jsondel(parsed []string, client *Client) (string, error) {
+	removed, err := store.JSONDel(client.DB, parsed[1], jsonPathArg(parsed, 2))
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return protocol.SerializeInteger(removed), nil
+}
+
+// jsontype implements JSON.TYPE key [path].
+func jsontype(parsed []string, client *Client) (string, error) {
+	path := jsonPathArg(parsed, 2)
+	if len(parsed) == 2 {
+		path = "."
+	}
+	types, ok, err := store.JSONType(client.DB, parsed[1], path)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !ok {
+		return protocol.SerializeNull(), nil
+	}
+	if store.LegacyJSONPath(path) {
+		if len(types) == 0 {
+			return protocol.SerializeNull(), nil
+		}
+		return protocol.SerializeSimpleString(types[0]), nil
+	}
+	return protocol.SerializeArray(types), nil
+}
+
+// jsonnumincrby implements JSON.NUMINCRBY key path value.
+func jsonnumincrby(parsed []string, client *Client) (string, error) {
+	values, ok, err := store.JSONNumIncrBy(client.DB, parsed[1], parsed[2], parsed[3])
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if store.LegacyJSONPath(parsed[2]) {
+		for i := len(values) - 1; i >= 0; i-- {
+			if ok[i] {
+				return protocol.SerializeBulkString(values[i]), nil
+			}
+		}
+		err := fmt.Errorf("Path '%s' does not exist or does not hold a number", parsed[2])
+		return protocol.SerializeError(err.Error()), err
+	}
+	for i := range values {
+		if !ok[i] {
+			values[i] = "null"
+		}
+	}
+	return protocol.SerializeBulkString("[" + strings.Join(values, ",") + "]"), nil
+}
+
+// jsonarrappend implements JSON.ARRAPPEND key path value [value ...].
+func jsonarrappend(parsed []string, client *Client) (string, error) {
+	lengths, ok, err := store.JSONArrAppend(client.DB, parsed[1], parsed[2], parsed[3:])
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if store.LegacyJSONPath(parsed[2]) {
+		for i := len(lengths) - 1; i >= 0; i-- {
+			if ok[i] {
+				return protocol.SerializeInteger(lengths[i]), nil
+			}
+		}
+		err := fmt.Errorf("Path '%s' does not exist or does not hold an array", parsed[2])
+		return protocol.SerializeError(err.Error()), err
+	}
+	response := make([]string, len(lengths))
+	for i, n := range lengths {
+		if ok[i] {
+			response[i] = protocol.SerializeInteger(n)
+		} else {
+			response[i] = protocol.SerializeNull()
+		}
+	}
+	return protocol.SerializeRawArray(response), nil
+}
+
+// jsonobjkeys implements JSON.OBJKEYS key [path].
+func jsonobjkeys(parsed []string, client *Client) (string, error) {
+	path := jsonPathArg(parsed, 2)
+	if len(parsed) == 2 {
+		path = "."
+	}
+	keys, ok, exists, err := store.JSONObjKeys(client.DB, parsed[1], path)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !exists {
+		return protocol.SerializeNull(), nil
+	}
+	if store.LegacyJSONPath(path) {
+		if len(keys) == 0 || !ok[0] {
+			return protocol.SerializeNullArray(), nil
+		}
+		return protocol.SerializeArray(keys[0]), nil
+	}
+	response := make([]string, len(keys))
+	for i := range keys {
+		if ok[i] {
+			response[i] = protocol.SerializeArray(keys[i])
+		} else {
+			response[i] = protocol.SerializeNullArray()
+		}
+	}
+	return protocol.SerializeRawArray(response), nil
+}
@@ -0,0 +1,262 @@
+package commands
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"litekv/internal/protocol"
+	"litekv/internal/store"
+)
+
+// parseTimeout parses the timeout of blocking list commands, in seconds
+// with an optional fraction. 0 means no timeout.
+func parseTimeout(s string) (time.Duration, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errors.New("timeout is not a float or out of range")
+	}
+	if f < 0 {
+		return 0, errors.New("timeout is negative")
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
+
+// parseDirection parses LEFT or RIGHT, returning true for LEFT.
+func parseDirection(s string) (bool, error) {
+	switch strings.ToUpper(s) {
+	case "LEFT":
+		return true, nil
+	case "RIGHT":
+		return false, nil
+	}
+	return false, errors.New("syntax error")
+}
+
+// blpop implements BLPOP and BRPOP key [key ...] timeout.
+func blpop(parsed []string, client *Client) (string, error) {
+	timeout, err := parseTimeout(parsed[len(parsed)-1])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	p := store.BlockedPop{Keys: parsed[1 : len(parsed)-1], Left: parsed[0] == "BLPOP", Count: 1}
+	result, ok, err := blockPop(client, p, timeout)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !ok {
+		return protocol.SerializeNullArray(), nil
+	}
+	return protocol.SerializeArray([]string{result.Key, result.Values[0]}), nil
+}
+
+// blmove implements BLMOVE source destination LEFT|RIGHT LEFT|RIGHT
+// timeout.
+func blmove(parsed []string, client *Client) (string, error) {
+	left, err := parseDirection(parsed[3])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	dstLeft, err := parseDirection(parsed[4])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	timeout, err := parseTimeout(parsed[5])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	p := store.BlockedPop{Keys: parsed[1:2], Left: left, Count: 1, Move: true, Dst: parsed[2], DstLeft: dstLeft}
+	result, ok, err := blockPop(client, p, timeout)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !ok {
+		return protocol.SerializeNull(), nil
+	}
+	return protocol.SerializeBulkString(result.Values[0]), nil
+}
+
+// parseMPop parses the numkeys key [key ...] LEFT|RIGHT [COUNT count]
+// arguments shared by LMPOP and BLMPOP.
+func parseMPop(args []string) (store.BlockedPop, error) {
+	keys, rest, err := parseNumKeys(args)
+	if err == nil && len(rest) != 1 && len(rest) != 3 {
+		err = errors.New("syntax error")
+	}
+	if err != nil {
+		return store.BlockedPop{}, err
+	}
+	left, err := parseDirection(rest[0])
+	if err != nil {
+		return store.BlockedPop{}, err
+	}
+	count := 1
+	if len(rest) == 3 {
+		if strings.ToUpper(rest[1]) != "COUNT" {
+			return store.BlockedPop{}, errors.New("syntax error")
+		}
+		count, err = strconv.Atoi(rest[2])
+		if err != nil || count <= 0 {
+			return store.BlockedPop{}, errors.New("count should be greater than 0")
+		}
+	}
+	return store.BlockedPop{Keys: keys, Left: left, Count: count}, nil
+}
+
+// serializeMPop replies to LMPOP and BLMPOP with the key and the values.
+func serializeMPop(result store.PopResult) string {
+	return protocol.SerializeRawArray([]string{
+		protocol.SerializeBulkString(result.Key),
+		protocol.SerializeArray(result.Values),
+	})
+}
+
+// blmpop implements BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT
+// count].
+func blmpop(parsed []string, client *Client) (string, error) {
+	timeout, err := parseTimeout(parsed[1])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	p, err := parseMPop(parsed[2:])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	result, ok, err := blockPop(client, p, timeout)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !ok {
+		return protocol.SerializeNullArray(), nil
+	}
+	return serializeMPop(result), nil
+}
+
+// lmpop implements LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count].
+func lmpop(parsed []string, client *Client) (string, error) {
+	p, err := parseMPop(parsed[1:])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	result, ok, err := store.ListPop(client.DB, p)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !ok {
+		return protocol.SerializeNullArray(), nil
+	}
+	return serializeMPop(result), nil
+}
+
+// lmove implements LMOVE source destination LEFT|RIGHT LEFT|RIGHT.
+func lmove(parsed []string, client *Client) (string, error) {
+	left, err := parseDirection(parsed[3])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	dstLeft, err := parseDirection(parsed[4])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	p := store.BlockedPop{Keys: parsed[1:2], Left: left, Count: 1, Move: true, Dst: parsed[2], DstLeft: dstLeft}
+	result, ok, err := store.ListPop(client.DB, p)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !ok {
+		return protocol.SerializeNull(), nil
+	}
+	return protocol.SerializeBulkString(result.Values[0]), nil
+}
+
+// lpop implements LPOP and RPOP key [count]. Without a count the reply is a
+// single element, with one it is an array.
+func lpop(parsed []string, client *Client) (string, error) {
+	p := store.BlockedPop{Keys: parsed[1:2], Left: parsed[0] == "LPOP", Count: 1}
+	if len(parsed) == 3 {
+		count, err := strconv.Atoi(parsed[2])
+		if err != nil || count < 0 {
+			return protocol.SerializeError("value is out of range, must be positive"), errors.New("value is out of range, must be positive")
+		}
+		if count == 0 {
+			n, err := store.LLen(client.DB, parsed[1])
+			if err != nil {
+				return serializeTypedError(err), err
+			}
+			if n == 0 {
+				return protocol.SerializeNullArray(), nil
+			}
+			return protocol.SerializeArray([]string{}), nil
+		}
+		p.Count = count
+	}
+	result, ok, err := store.ListPop(client.DB, p)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if len(parsed) == 3 {
+		if !ok {
+			return protocol.SerializeNullArray(), nil
+		}
+		return protocol.SerializeArray(result.Values), nil
+	}
+	if !ok {
+		return protocol.SerializeNull(), nil
+	}
+	return protocol.SerializeBulkString(result.Values[0]), nil
+}
+
+// lpos implements LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN
+// len].
+func lpos(parsed []string, client *Client) (string, error) {
+	rank, count, maxLen := 1, 1, 0
+	withCount := false
+	for i := 3; i < len(parsed); i += 2 {
+		if i+1 >= len(parsed) {
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+		n, err := strconv.Atoi(parsed[i+1])
+		if err != nil {
+			return protocol.SerializeError("value is not an integer or out of range"), err
+		}
+		switch strings.ToUpper(parsed[i]) {
+		case "RANK":
+			if n == 0 {
+				err = errors.New("RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				err = errors.New("COUNT can't be negative")
+			}
+			count, withCount = n, true
+		case "MAXLEN":
+			if n < 0 {
+				err = errors.New("MAXLEN can't be negative")
+			}
+			maxLen = n
+		default:
+			err = errors.New("syntax error")
+		}
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+	}
+	matches, err := store.LPos(client.DB, parsed[1], parsed[2], rank, count, maxLen)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !withCount {
+		if len(matches) == 0 {
+			return protocol.SerializeNull(), nil
+		}
+		return protocol.SerializeInteger(matches[0]), nil
+	}
+	response := make([]string, len(matches))
+	for i, m := range matches {
+		response[i] = protocol.SerializeInteger(m)
+	}
+	return protocol.SerializeRawArray(response), nil
+}
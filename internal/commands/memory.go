@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"errors"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"litekv/internal/config"
+	"litekv/internal/protocol"
+	"litekv/internal/store"
+)
+
+// Thresholds used by MEMORY DOCTOR.
+const (
+	bigKeyThreshold   = 1 << 20 // 1MB
+	bigKeyReportLimit = 10
+	maxMemoryWarning  = 90 // percent of maxmemory
+	heapRatioWarning  = 3  // heap in use vs estimated keyspace size
+)
+
+// memory implements MEMORY USAGE, MEMORY STATS and MEMORY DOCTOR.
+func memory(parsed []string, client *Client) (string, error) {
+	switch strings.ToUpper(parsed[1]) {
+	case "USAGE":
+		if len(parsed) != 3 && len(parsed) != 5 {
+			return protocol.SerializeError("Wrong number of arguments for 'MEMORY USAGE' command"), errors.New("Wrong number of arguments for 'MEMORY USAGE' command")
+		}
+		samples := 5
+		if len(parsed) == 5 {
+			n, err := strconv.Atoi(parsed[4])
+			if strings.ToUpper(parsed[3]) != "SAMPLES" || err != nil || n < 0 {
+				return protocol.SerializeError("syntax error"), errors.New("syntax error")
+			}
+			samples = n
+		}
+		if size, ok := store.MemoryUsage(client.DB, parsed[2], samples); ok {
+			return protocol.SerializeInteger(int(size)), nil
+		}
+		return protocol.SerializeNull(), nil
+	case "STATS":
+		return protocol.SerializeRawArray(memoryStats()), nil
+	case "DOCTOR":
+		return protocol.SerializeBulkString(memoryDoctor()), nil
+	}
+	return protocol.SerializeError("Unknown MEMORY subcommand"), errors.New("unknown MEMORY subcommand")
+}
+
+func memoryStats() []string {
+	stats := store.MemoryStats()
+	var rt runtime.MemStats
+	runtime.ReadMemStats(&rt)
+
+	response := make([]string, 0)
+	add := func(name string, value int64) {
+		response = append(response, protocol.SerializeBulkString(name), protocol.SerializeInteger(int(value)))
+	}
+	add("used_memory", stats.UsedMemory)
+	add("maxmemory", config.MaxMemory())
+	add("keys.count", int64(stats.Keys))
+	if stats.Keys > 0 {
+		add("keys.bytes-per-key", stats.UsedMemory/int64(stats.Keys))
+	} else {
+		add("keys.bytes-per-key", 0)
+	}
+	add("expires.count", int64(stats.Expires))
+	add("expires.overhead", stats.ExpiresBytes)
+
+	types := make([]string, 0, len(stats.BytesByType))
+	for typ := range stats.BytesByType {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		add("type."+typ+".keys", int64(stats.KeysByType[typ]))
+		add("type."+typ+".bytes", stats.BytesByType[typ])
+	}
+
+	add("runtime.heap-alloc", int64(rt.HeapAlloc))
+	add("runtime.heap-inuse", int64(rt.HeapInuse))
+	add("runtime.heap-sys", int64(rt.HeapSys))
+	add("runtime.heap-objects", int64(rt.HeapObjects))
+	add("runtime.sys", int64(rt.Sys))
+	add("runtime.next-gc", int64(rt.NextGC))
+	add("runtime.num-gc", int64(rt.NumGC))
+	return response
+}
+
+func memoryDoctor() string {
+	used := store.UsedMemory()
+	var rt runtime.MemStats
+	runtime.ReadMemStats(&rt)
+
+	problems := make([]string, 0)
+	if limit := config.MaxMemory(); limit > 0 && used*100 >= limit*maxMemoryWarning {
+		problem := "Used memory (" + humanBytes(used) + ") is above " + strconv.Itoa(maxMemoryWarning) + "% of maxmemory (" + humanBytes(limit) + ")."
+		if config.MaxMemoryPolicy() == "noeviction" {
+			problem += " The policy is noeviction, so writes will soon fail with OOM errors."
+		}
+		problems = append(problems, problem)
+	}
+	if used > 0 && int64(rt.HeapInuse) > used*heapRatioWarning && rt.HeapInuse > bigKeyThreshold {
+		problems = append(problems, "The Go heap in use ("+humanBytes(int64(rt.HeapInuse))+") is more than "+
+			strconv.Itoa(heapRatioWarning)+" times the estimated dataset size ("+humanBytes(used)+"). "+
+			"Recently deleted data may not have been collected yet, or per-key overhead dominates.")
+	}
+	for _, big := range store.BigKeys(bigKeyThreshold, bigKeyReportLimit) {
+		problems = append(problems, "Big key '"+big.Key+"' ("+big.Type+", db"+strconv.Itoa(big.DB)+") uses about "+humanBytes(big.Size)+
+			". Operations reading the whole value will be slow and block other clients.")
+	}
+
+	if len(problems) == 0 {
+		return "No memory problems detected."
+	}
+	return "Memory problems found:\n\n * " + strings.Join(problems, "\n\n * ") + "\n"
+}
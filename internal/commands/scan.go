@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"litekv/internal/protocol"
+)
+
+// scanOptions are the arguments shared by SCAN, HSCAN, SSCAN and ZSCAN.
+type scanOptions struct {
+	cursor   uint64
+	count    int
+	match    string
+	typ      string
+	novalues bool
+}
+
+// parseScan parses "cursor [MATCH pattern] [COUNT count]" plus the options
+// specific to each command: TYPE for SCAN and NOVALUES for HSCAN.
+func parseScan(name string, args []string) (scanOptions, error) {
+	opts := scanOptions{count: 10}
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return opts, errors.New("invalid cursor")
+	}
+	opts.cursor = cursor
+	for i := 1; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if option == "NOVALUES" && name == "HSCAN" {
+			opts.novalues = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return opts, errors.New("syntax error")
+		}
+		switch {
+		case option == "MATCH":
+			opts.match = args[i+1]
+		case option == "COUNT":
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, errors.New("value is not an integer or out of range")
+			}
+			if count < 1 {
+				return opts, errors.New("syntax error")
+			}
+			opts.count = count
+		case option == "TYPE" && name == "SCAN":
+			opts.typ = strings.ToLower(args[i+1])
+		default:
+			return opts, errors.New("syntax error")
+		}
+		i++
+	}
+	return opts, nil
+}
+
+// serializeScan builds the [cursor, [items...]] reply.
+func serializeScan(cursor uint64, items []string) string {
+	return protocol.SerializeRawArray([]string{
+		protocol.SerializeBulkString(strconv.FormatUint(cursor, 10)),
+		protocol.SerializeArray(items),
+	})
+}
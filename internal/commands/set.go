@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"litekv/internal/protocol"
+	"litekv/internal/store"
+)
+
+// sintercard implements SINTERCARD numkeys key [key ...] [LIMIT limit].
+func sintercard(parsed []string, client *Client) (string, error) {
+	keys, rest, err := parseNumKeys(parsed[1:])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	limit := 0
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(rest[0]) != "LIMIT" {
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+		limit, err = strconv.Atoi(rest[1])
+		if err != nil || limit < 0 {
+			return protocol.SerializeError("LIMIT can't be negative"), errors.New("LIMIT can't be negative")
+		}
+	}
+	return serializeTypedInteger(store.SInterCard(client.DB, keys, limit))
+}
+
+// spop implements SPOP and SRANDMEMBER key [count]. Without a count the reply
+// is a single member, with one it is an array.
+func spop(parsed []string, client *Client) (string, error) {
+	count := 1
+	if len(parsed) == 3 {
+		n, err := strconv.Atoi(parsed[2])
+		if err != nil || (n < 0 && parsed[0] == "SPOP") {
+			return protocol.SerializeError("value is out of range, must be positive"), errors.New("value is out of range, must be positive")
+		}
+		count = n
+	}
+	var members []string
+	var err error
+	if parsed[0] == "SPOP" {
+		members, err = store.SPop(client.DB, parsed[1], count)
+	} else {
+		members, err = store.SRandMember(client.DB, parsed[1], count)
+	}
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if len(parsed) == 3 {
+		return protocol.SerializeArray(members), nil
+	}
+	if len(members) == 0 {
+		return protocol.SerializeNull(), nil
+	}
+	return protocol.SerializeBulkString(members[0]), nil
+}
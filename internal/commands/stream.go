@@ -0,0 +1,724 @@
+package commands
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"litekv/internal/protocol"
+	"litekv/internal/store"
+)
+
+// serializeStreamEntry replies with [id, [field, value, ...]]. Entries
+// deleted while pending in a group have a null field list.
+func serializeStreamEntry(e store.StreamEntry) string {
+	fields := protocol.SerializeNullArray()
+	if e.Fields != nil {
+		fields = protocol.SerializeArray(e.Fields)
+	}
+	return protocol.SerializeRawArray([]string{
+		protocol.SerializeBulkString(e.ID.String()),
+		fields,
+	})
+}
+
+func serializeStreamEntries(entries []store.StreamEntry) string {
+	response := make([]string, 0, len(entries))
+	for _, e := range entries {
+		response = append(response, serializeStreamEntry(e))
+	}
+	return protocol.SerializeRawArray(response)
+}
+
+func serializeStreamIDs(ids []store.StreamID) string {
+	response := make([]string, 0, len(ids))
+	for _, id := range ids {
+		response = append(response, id.String())
+	}
+	return protocol.SerializeArray(response)
+}
+
+// streamError replies with err, keeping the code of store.StreamError
+// errors such as NOGROUP.
+func streamError(err error) (string, error) {
+	var codeErr *store.StreamError
+	if errors.As(err, &codeErr) {
+		return protocol.SerializeErrorCode(codeErr.Code, codeErr.Msg), err
+	}
+	return serializeTypedError(err), err
+}
+
+// parseStreamTrim parses "MAXLEN|MINID [=|~] threshold [LIMIT count]" at
+// the start of args and returns the number of arguments used.
+func parseStreamTrim(args []string) (store.StreamTrim, int, error) {
+	var trim store.StreamTrim
+	trim.MinID = strings.ToUpper(args[0]) == "MINID"
+	i := 1
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		trim.Approx = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return trim, i, errors.New("syntax error")
+	}
+	if trim.MinID {
+		id, err := store.ParseStreamID(args[i], 0)
+		if err != nil {
+			return trim, i, err
+		}
+		trim.Threshold = id
+	} else {
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return trim, i, errors.New("value is not an integer or out of range")
+		}
+		if n < 0 {
+			return trim, i, errors.New("The MAXLEN argument must be >= 0.")
+		}
+		trim.MaxLen = n
+	}
+	i++
+	if i < len(args) && strings.ToUpper(args[i]) == "LIMIT" {
+		if i+1 >= len(args) {
+			return trim, i, errors.New("syntax error")
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil || n < 0 {
+			return trim, i, errors.New("The LIMIT argument must be >= 0.")
+		}
+		if !trim.Approx {
+			return trim, i, errors.New("syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		trim.Limit = n
+		if n == 0 {
+			trim.Limit = -1
+		}
+		i += 2
+	}
+	return trim, i, nil
+}
+
+// xadd implements XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold
+// [LIMIT count]] *|id field value [field value ...].
+func xadd(parsed []string, client *Client) (string, error) {
+	noMkStream := false
+	var trim *store.StreamTrim
+	i := 2
+	for ; i < len(parsed); i++ {
+		option := strings.ToUpper(parsed[i])
+		if option == "NOMKSTREAM" {
+			noMkStream = true
+		} else if option == "MAXLEN" || option == "MINID" {
+			t, n, err := parseStreamTrim(parsed[i:])
+			if err != nil {
+				return protocol.SerializeError(err.Error()), err
+			}
+			trim = &t
+			i += n - 1
+		} else {
+			break
+		}
+	}
+	fields := parsed[min(i+1, len(parsed)):]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return protocol.SerializeError("Wrong number of arguments for 'XADD' command"), errors.New("Wrong number of arguments for 'XADD' command")
+	}
+	id, ok, err := store.XAdd(client.DB, parsed[1], parsed[i], fields, noMkStream, trim)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !ok {
+		return protocol.SerializeNull(), nil
+	}
+	return protocol.SerializeBulkString(id.String()), nil
+}
+
+// xrange implements XRANGE key start end [COUNT count] and XREVRANGE key
+// end start [COUNT count].
+func xrange(parsed []string, client *Client) (string, error) {
+	rev := parsed[0] == "XREVRANGE"
+	start, end := parsed[2], parsed[3]
+	if rev {
+		start, end = end, start
+	}
+	first, last, err := store.ParseStreamRange(start, end)
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	count := -1
+	if len(parsed) > 4 {
+		if len(parsed) != 6 || strings.ToUpper(parsed[4]) != "COUNT" {
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+		count, err = strconv.Atoi(parsed[5])
+		if err != nil {
+			return protocol.SerializeError("value is not an integer or out of range"), errors.New("value is not an integer or out of range")
+		}
+		if count <= 0 {
+			return protocol.SerializeArray([]string{}), nil
+		}
+	}
+	entries, err := store.XRange(client.DB, parsed[1], first, last, count, rev)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return serializeStreamEntries(entries), nil
+}
+
+// xtrim implements XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count].
+func xtrim(parsed []string, client *Client) (string, error) {
+	option := strings.ToUpper(parsed[2])
+	if option != "MAXLEN" && option != "MINID" {
+		return protocol.SerializeError("syntax error"), errors.New("syntax error")
+	}
+	trim, n, err := parseStreamTrim(parsed[2:])
+	if err == nil && n != len(parsed)-2 {
+		err = errors.New("syntax error")
+	}
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	return serializeTypedInteger(store.XTrim(client.DB, parsed[1], trim))
+}
+
+// parseStreamIDs parses a list of entry IDs, as taken by XDEL and XACK.
+func parseStreamIDs(args []string) ([]store.StreamID, error) {
+	ids := make([]store.StreamID, 0, len(args))
+	for _, arg := range args {
+		id, err := store.ParseStreamID(arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// readOptions are the options shared by XREAD and XREADGROUP.
+type readOptions struct {
+	count   int
+	block   bool
+	timeout time.Duration
+	noAck   bool
+	keys    []string
+	ids     []string
+}
+
+// parseRead parses "[COUNT count] [BLOCK ms] [NOACK] STREAMS key ... id
+// ...". NOACK is only accepted for XREADGROUP.
+func parseRead(args []string, group bool) (readOptions, error) {
+	var opts readOptions
+	for i := 0; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "COUNT" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, errors.New("value is not an integer or out of range")
+			}
+			opts.count = max(n, 0)
+			i++
+		case option == "BLOCK" && i+1 < len(args):
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return opts, errors.New("timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return opts, errors.New("timeout is negative")
+			}
+			opts.block = true
+			opts.timeout = time.Duration(ms) * time.Millisecond
+			i++
+		case option == "NOACK" && group:
+			opts.noAck = true
+		case option == "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return opts, errors.New("Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+			}
+			opts.keys, opts.ids = rest[:len(rest)/2], rest[len(rest)/2:]
+			return opts, nil
+		default:
+			return opts, errors.New("syntax error")
+		}
+	}
+	return opts, errors.New("syntax error")
+}
+
+// xread implements XREAD [COUNT count] [BLOCK ms] STREAMS key ... id ...
+// where an ID of "$" waits for entries added after the call and "+"
+// starts from the last entry.
+func xread(parsed []string, client *Client) (string, error) {
+	opts, err := parseRead(parsed[1:], false)
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	after := make([]store.StreamID, len(opts.keys))
+	for i, key := range opts.keys {
+		switch opts.ids[i] {
+		case "$":
+			after[i], _, err = store.XLastID(client.DB, key)
+		case "+":
+			var last store.StreamID
+			var ok bool
+			last, ok, err = store.XLastEntryID(client.DB, key)
+			if err == nil && !ok {
+				after[i], _, err = store.XLastID(client.DB, key)
+			} else if after[i], ok = last.Prev(); !ok {
+				after[i] = store.StreamID{}
+			}
+		default:
+			after[i], err = store.ParseStreamID(opts.ids[i], 0)
+			if err != nil {
+				return protocol.SerializeError(err.Error()), err
+			}
+		}
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+	}
+
+	var readErr error
+	try := func() (string, bool) {
+		response := make([]string, 0)
+		for i, key := range opts.keys {
+			entries, err := store.XRead(client.DB, key, after[i], opts.count)
+			if err != nil {
+				readErr = err
+				return "", true
+			}
+			if len(entries) > 0 {
+				response = append(response, protocol.SerializeRawArray([]string{
+					protocol.SerializeBulkString(key),
+					serializeStreamEntries(entries),
+				}))
+			}
+		}
+		return protocol.SerializeRawArray(response), len(response) > 0
+	}
+	response, ok := try()
+	if !ok && opts.block {
+		response, ok = blockFor(client, opts.keys, opts.timeout, try)
+	}
+	if readErr != nil {
+		return serializeTypedError(readErr), readErr
+	}
+	if !ok {
+		return protocol.SerializeNullArray(), nil
+	}
+	return response, nil
+}
+
+// xreadgroup implements XREADGROUP GROUP group consumer [COUNT count]
+// [BLOCK ms] [NOACK] STREAMS key ... id ... An ID of ">" reads entries
+// never delivered to the group, any other ID the consumer's own pending
+// entries; only the former can block.
+func xreadgroup(parsed []string, client *Client) (string, error) {
+	if strings.ToUpper(parsed[1]) != "GROUP" {
+		return protocol.SerializeError("syntax error"), errors.New("syntax error")
+	}
+	group, consumer := parsed[2], parsed[3]
+	opts, err := parseRead(parsed[4:], true)
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+
+	var readErr error
+	try := func() (string, bool) {
+		response := make([]string, 0)
+		for i, key := range opts.keys {
+			entries, err := store.XReadGroup(client.DB, key, group, consumer, opts.ids[i], opts.count, opts.noAck)
+			if err != nil {
+				readErr = err
+				return "", true
+			}
+			if len(entries) > 0 || opts.ids[i] != ">" {
+				response = append(response, protocol.SerializeRawArray([]string{
+					protocol.SerializeBulkString(key),
+					serializeStreamEntries(entries),
+				}))
+			}
+		}
+		return protocol.SerializeRawArray(response), len(response) > 0
+	}
+	response, ok := try()
+	if !ok && opts.block {
+		response, ok = blockFor(client, opts.keys, opts.timeout, try)
+	}
+	if readErr != nil {
+		return streamError(readErr)
+	}
+	if !ok {
+		return protocol.SerializeNullArray(), nil
+	}
+	return response, nil
+}
+
+// parseEntriesRead parses the optional "ENTRIESREAD n" of XGROUP CREATE
+// and SETID, returning -1 when it is absent.
+func parseEntriesRead(args []string) (int64, error) {
+	if len(args) == 0 {
+		return -1, nil
+	}
+	if len(args) != 2 || strings.ToUpper(args[0]) != "ENTRIESREAD" {
+		return 0, errors.New("syntax error")
+	}
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("value for ENTRIESREAD must be positive or -1")
+	}
+	return n, nil
+}
+
+// xgroup implements the XGROUP subcommands CREATE, SETID, DESTROY,
+// CREATECONSUMER and DELCONSUMER.
+func xgroup(parsed []string, client *Client) (string, error) {
+	sub := strings.ToUpper(parsed[1])
+	wrongArgs := func() (string, error) {
+		msg := "Wrong number of arguments for 'XGROUP " + sub + "' command"
+		return protocol.SerializeError(msg), errors.New(msg)
+	}
+	switch sub {
+	case "CREATE":
+		if len(parsed) < 5 {
+			return wrongArgs()
+		}
+		rest := parsed[5:]
+		mkStream := len(rest) > 0 && strings.ToUpper(rest[0]) == "MKSTREAM"
+		if mkStream {
+			rest = rest[1:]
+		}
+		entriesRead, err := parseEntriesRead(rest)
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		if err := store.XGroupCreate(client.DB, parsed[2], parsed[3], parsed[4], mkStream, entriesRead); err != nil {
+			return streamError(err)
+		}
+		return protocol.SerializeSimpleString("OK"), nil
+	case "SETID":
+		if len(parsed) < 5 {
+			return wrongArgs()
+		}
+		entriesRead, err := parseEntriesRead(parsed[5:])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		if err := store.XGroupSetID(client.DB, parsed[2], parsed[3], parsed[4], entriesRead); err != nil {
+			return streamError(err)
+		}
+		return protocol.SerializeSimpleString("OK"), nil
+	case "DESTROY":
+		if len(parsed) != 4 {
+			return wrongArgs()
+		}
+		destroyed, err := store.XGroupDestroy(client.DB, parsed[2], parsed[3])
+		if err != nil {
+			return streamError(err)
+		}
+		if destroyed {
+			return protocol.SerializeInteger(1), nil
+		}
+		return protocol.SerializeInteger(0), nil
+	case "CREATECONSUMER":
+		if len(parsed) != 5 {
+			return wrongArgs()
+		}
+		created, err := store.XGroupCreateConsumer(client.DB, parsed[2], parsed[3], parsed[4])
+		if err != nil {
+			return streamError(err)
+		}
+		if created {
+			return protocol.SerializeInteger(1), nil
+		}
+		return protocol.SerializeInteger(0), nil
+	case "DELCONSUMER":
+		if len(parsed) != 5 {
+			return wrongArgs()
+		}
+		pending, err := store.XGroupDelConsumer(client.DB, parsed[2], parsed[3], parsed[4])
+		if err != nil {
+			return streamError(err)
+		}
+		return protocol.SerializeInteger(pending), nil
+	}
+	return protocol.SerializeError("unknown subcommand '" + parsed[1] + "'"), errors.New("unknown subcommand '" + parsed[1] + "'")
+}
+
+// xpending implements XPENDING key group [[IDLE min-idle] start end count
+// [consumer]].
+func xpending(parsed []string, client *Client) (string, error) {
+	key, group := parsed[1], parsed[2]
+	if len(parsed) == 3 {
+		summary, err := store.XPendingSummary(client.DB, key, group)
+		if err != nil {
+			return streamError(err)
+		}
+		if summary.Count == 0 {
+			return protocol.SerializeRawArray([]string{
+				protocol.SerializeInteger(0),
+				protocol.SerializeNull(),
+				protocol.SerializeNull(),
+				protocol.SerializeNullArray(),
+			}), nil
+		}
+		consumers := make([]string, 0, len(summary.Consumers))
+		for _, c := range summary.Consumers {
+			consumers = append(consumers, protocol.SerializeArray([]string{c.Name, strconv.Itoa(c.Pending)}))
+		}
+		return protocol.SerializeRawArray([]string{
+			protocol.SerializeInteger(summary.Count),
+			protocol.SerializeBulkString(summary.First.String()),
+			protocol.SerializeBulkString(summary.Last.String()),
+			protocol.SerializeRawArray(consumers),
+		}), nil
+	}
+
+	args := parsed[3:]
+	minIdle := int64(0)
+	if strings.ToUpper(args[0]) == "IDLE" {
+		if len(args) < 2 {
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return protocol.SerializeError("value is not an integer or out of range"), errors.New("value is not an integer or out of range")
+		}
+		minIdle = n
+		args = args[2:]
+	}
+	if len(args) != 3 && len(args) != 4 {
+		return protocol.SerializeError("syntax error"), errors.New("syntax error")
+	}
+	first, last, err := store.ParseStreamRange(args[0], args[1])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		return protocol.SerializeError("value is not an integer or out of range"), errors.New("value is not an integer or out of range")
+	}
+	consumer := ""
+	if len(args) == 4 {
+		consumer = args[3]
+	}
+	entries, err := store.XPendingRange(client.DB, key, group, minIdle, first, last, max(count, 0), consumer)
+	if err != nil {
+		return streamError(err)
+	}
+	response := make([]string, 0, len(entries))
+	for _, p := range entries {
+		response = append(response, protocol.SerializeRawArray([]string{
+			protocol.SerializeBulkString(p.ID.String()),
+			protocol.SerializeBulkString(p.Consumer),
+			protocol.SerializeInteger(int(p.Idle)),
+			protocol.SerializeInteger(int(p.DeliveryCount)),
+		}))
+	}
+	return protocol.SerializeRawArray(response), nil
+}
+
+func parseMinIdle(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errors.New("Invalid min-idle-time argument for XCLAIM")
+	}
+	return max(n, 0), nil
+}
+
+// xclaim implements XCLAIM key group consumer min-idle-time id [id ...]
+// [IDLE ms] [TIME unix-time-ms] [RETRYCOUNT count] [FORCE] [JUSTID]
+// [LASTID id].
+func xclaim(parsed []string, client *Client) (string, error) {
+	minIdle, err := parseMinIdle(parsed[4])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	i := 5
+	ids := make([]store.StreamID, 0)
+	for ; i < len(parsed); i++ {
+		id, err := store.ParseStreamID(parsed[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return protocol.SerializeError("Invalid stream ID specified as stream command argument"), errors.New("Invalid stream ID specified as stream command argument")
+	}
+
+	opts := store.XClaimOptions{Idle: -1, Time: -1, RetryCount: -1}
+	for ; i < len(parsed); i++ {
+		option := strings.ToUpper(parsed[i])
+		switch {
+		case option == "FORCE":
+			opts.Force = true
+		case option == "JUSTID":
+			opts.JustID = true
+		case (option == "IDLE" || option == "TIME" || option == "RETRYCOUNT") && i+1 < len(parsed):
+			n, err := strconv.ParseInt(parsed[i+1], 10, 64)
+			if err != nil || n < 0 {
+				msg := "Invalid " + option + " option argument for XCLAIM"
+				return protocol.SerializeError(msg), errors.New(msg)
+			}
+			switch option {
+			case "IDLE":
+				opts.Idle = n
+			case "TIME":
+				opts.Time = n
+			default:
+				opts.RetryCount = n
+			}
+			i++
+		case option == "LASTID" && i+1 < len(parsed):
+			id, err := store.ParseStreamID(parsed[i+1], 0)
+			if err != nil {
+				return protocol.SerializeError(err.Error()), err
+			}
+			opts.LastID = &id
+			i++
+		default:
+			msg := "Unrecognized XCLAIM option '" + parsed[i] + "'"
+			return protocol.SerializeError(msg), errors.New(msg)
+		}
+	}
+
+	entries, err := store.XClaim(client.DB, parsed[1], parsed[2], parsed[3], minIdle, ids, opts)
+	if err != nil {
+		return streamError(err)
+	}
+	if opts.JustID {
+		claimed := make([]store.StreamID, 0, len(entries))
+		for _, e := range entries {
+			claimed = append(claimed, e.ID)
+		}
+		return serializeStreamIDs(claimed), nil
+	}
+	return serializeStreamEntries(entries), nil
+}
+
+// xautoclaim implements XAUTOCLAIM key group consumer min-idle-time start
+// [COUNT count] [JUSTID].
+func xautoclaim(parsed []string, client *Client) (string, error) {
+	minIdle, err := parseMinIdle(parsed[4])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	start, _, err := store.ParseStreamRange(parsed[5], "+")
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	count, justID := 100, false
+	for i := 6; i < len(parsed); i++ {
+		option := strings.ToUpper(parsed[i])
+		if option == "JUSTID" {
+			justID = true
+		} else if option == "COUNT" && i+1 < len(parsed) {
+			n, err := strconv.Atoi(parsed[i+1])
+			if err != nil || n < 1 || n > math.MaxInt32/10 {
+				return protocol.SerializeError("COUNT must be > 0"), errors.New("COUNT must be > 0")
+			}
+			count = n
+			i++
+		} else {
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+	}
+
+	next, entries, deleted, err := store.XAutoClaim(client.DB, parsed[1], parsed[2], parsed[3], minIdle, start, count, justID)
+	if err != nil {
+		return streamError(err)
+	}
+	claimed := serializeStreamEntries(entries)
+	if justID {
+		ids := make([]store.StreamID, 0, len(entries))
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+		}
+		claimed = serializeStreamIDs(ids)
+	}
+	return protocol.SerializeRawArray([]string{
+		protocol.SerializeBulkString(next.String()),
+		claimed,
+		serializeStreamIDs(deleted),
+	}), nil
+}
+
+// serializeOptionalInt replies with n, or null when it is -1 (unknown).
+func serializeOptionalInt(n int64) string {
+	if n < 0 {
+		return protocol.SerializeNull()
+	}
+	return protocol.SerializeInteger(int(n))
+}
+
+// xinfo implements XINFO STREAM key, XINFO GROUPS key and XINFO CONSUMERS
+// key group.
+func xinfo(parsed []string, client *Client) (string, error) {
+	sub := strings.ToUpper(parsed[1])
+	switch {
+	case sub == "STREAM" && len(parsed) == 3:
+		info, ok, err := store.XInfoStream(client.DB, parsed[2])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		if !ok {
+			return protocol.SerializeError("no such key"), errors.New("no such key")
+		}
+		entry := func(e *store.StreamEntry) string {
+			if e == nil {
+				return protocol.SerializeNull()
+			}
+			return serializeStreamEntry(*e)
+		}
+		return protocol.SerializeRawArray([]string{
+			protocol.SerializeBulkString("length"), protocol.SerializeInteger(info.Length),
+			protocol.SerializeBulkString("radix-tree-keys"), protocol.SerializeInteger(info.Nodes),
+			protocol.SerializeBulkString("radix-tree-nodes"), protocol.SerializeInteger(info.Nodes),
+			protocol.SerializeBulkString("last-generated-id"), protocol.SerializeBulkString(info.LastID.String()),
+			protocol.SerializeBulkString("max-deleted-entry-id"), protocol.SerializeBulkString(info.MaxDeletedID.String()),
+			protocol.SerializeBulkString("entries-added"), protocol.SerializeInteger(int(info.EntriesAdded)),
+			protocol.SerializeBulkString("recorded-first-entry-id"), protocol.SerializeBulkString(info.RecordedFirst.String()),
+			protocol.SerializeBulkString("groups"), protocol.SerializeInteger(info.Groups),
+			protocol.SerializeBulkString("first-entry"), entry(info.FirstEntry),
+			protocol.SerializeBulkString("last-entry"), entry(info.LastEntry),
+		}), nil
+	case sub == "GROUPS" && len(parsed) == 3:
+		groups, ok, err := store.XInfoGroups(client.DB, parsed[2])
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		if !ok {
+			return protocol.SerializeError("no such key"), errors.New("no such key")
+		}
+		response := make([]string, 0, len(groups))
+		for _, g := range groups {
+			response = append(response, protocol.SerializeRawArray([]string{
+				protocol.SerializeBulkString("name"), protocol.SerializeBulkString(g.Name),
+				protocol.SerializeBulkString("consumers"), protocol.SerializeInteger(g.Consumers),
+				protocol.SerializeBulkString("pending"), protocol.SerializeInteger(g.Pending),
+				protocol.SerializeBulkString("last-delivered-id"), protocol.SerializeBulkString(g.LastID.String()),
+				protocol.SerializeBulkString("entries-read"), serializeOptionalInt(g.EntriesRead),
+				protocol.SerializeBulkString("lag"), serializeOptionalInt(g.Lag),
+			}))
+		}
+		return protocol.SerializeRawArray(response), nil
+	case sub == "CONSUMERS" && len(parsed) == 4:
+		consumers, err := store.XInfoConsumers(client.DB, parsed[2], parsed[3])
+		if err != nil {
+			return streamError(err)
+		}
+		response := make([]string, 0, len(consumers))
+		for _, c := range consumers {
+			response = append(response, protocol.SerializeRawArray([]string{
+				protocol.SerializeBulkString("name"), protocol.SerializeBulkString(c.Name),
+				protocol.SerializeBulkString("pending"), protocol.SerializeInteger(c.Pending),
+				protocol.SerializeBulkString("idle"), protocol.SerializeInteger(int(c.Idle)),
+				protocol.SerializeBulkString("inactive"), protocol.SerializeInteger(int(c.Inactive)),
+			}))
+		}
+		return protocol.SerializeRawArray(response), nil
+	}
+	return protocol.SerializeError("unknown subcommand or wrong number of arguments for '" + parsed[1] + "'"), errors.New("unknown subcommand or wrong number of arguments for '" + parsed[1] + "'")
+}
@@ -0,0 +1,283 @@
+package commands
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"litekv/internal/protocol"
+	"litekv/internal/store"
+)
+
+// tsRange holds the arguments of TS.RANGE and TS.MRANGE.
+type tsRange struct {
+	from, to   int64
+	count      int
+	agg        store.TSAggregation
+	withLabels bool
+	filters    []store.TSFilter
+}
+
+func serializeSample(s store.TSSample) string {
+	return protocol.SerializeRawArray([]string{
+		protocol.SerializeInteger(int(s.Timestamp)),
+		protocol.SerializeBulkString(formatScore(s.Value)),
+	})
+}
+
+func serializeSamples(samples []store.TSSample) string {
+	response := make([]string, len(samples))
+	for i, s := range samples {
+		response[i] = serializeSample(s)
+	}
+	return protocol.SerializeRawArray(response)
+}
+
+// parseTimestamp parses the timestamp of a new sample, * being the current
+// time.
+func parseTimestamp(s string) (int64, error) {
+	if s == "*" {
+		return time.Now().UnixMilli(), nil
+	}
+	ts, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ts < 0 {
+		return 0, errors.New("TSDB: invalid timestamp")
+	}
+	return ts, nil
+}
+
+func parseSampleValue(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, errors.New("TSDB: invalid value")
+	}
+	return v, nil
+}
+
+// parseTSOptions parses [RETENTION retentionPeriod] [LABELS label value
+// ...], where LABELS takes the rest of the arguments.
+func parseTSOptions(args []string) (store.TSOptions, error) {
+	var opts store.TSOptions
+	for len(args) > 0 {
+		switch option := strings.ToUpper(args[0]); {
+		case option == "RETENTION" && len(args) >= 2:
+			n, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil || n < 0 {
+				return opts, errors.New("TSDB: Couldn't parse RETENTION")
+			}
+			opts.Retention = n
+			args = args[2:]
+		case option == "LABELS":
+			if len(args)%2 == 0 {
+				return opts, errors.New("TSDB: wrong number of arguments for LABELS")
+			}
+			for i := 1; i < len(args); i += 2 {
+				if args[i] == "" || args[i+1] == "" {
+					return opts, errors.New("TSDB: labels and their values must not be empty")
+				}
+				opts.Labels = append(opts.Labels, store.TSLabel{Name: args[i], Value: args[i+1]})
+			}
+			args = nil
+		default:
+			return opts, errors.New("syntax error")
+		}
+	}
+	return opts, nil
+}
+
+// parseTSFilter parses label=value, label!=value, label=(value,...) and
+// label!=(value,...); an empty value stands for a missing label.
+func parseTSFilter(s string) (store.TSFilter, error) {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return store.TSFilter{}, errors.New("TSDB: failed parsing labels")
+	}
+	f := store.TSFilter{Label: s[:i]}
+	if s[i-1] == '!' {
+		f.Label, f.Negate = s[:i-1], true
+	}
+	if f.Label == "" {
+		return store.TSFilter{}, errors.New("TSDB: failed parsing labels")
+	}
+	value := s[i+1:]
+	if len(value) >= 2 && value[0] == '(' && value[len(value)-1] == ')' {
+		f.Values = strings.Split(value[1:len(value)-1], ",")
+	} else {
+		f.Values = []string{value}
+	}
+	return f, nil
+}
+
+// parseTSRange parses fromTimestamp toTimestamp [COUNT count] [AGGREGATION
+// aggregator bucketDuration], and with multi [WITHLABELS] FILTER filter...,
+// where FILTER takes the rest of the arguments.
+func parseTSRange(args []string, multi bool) (tsRange, error) {
+	r := tsRange{to: math.MaxInt64}
+	var err error
+	if args[0] != "-" {
+		if r.from, err = strconv.ParseInt(args[0], 10, 64); err != nil || r.from < 0 {
+			return r, errors.New("TSDB: wrong fromTimestamp")
+		}
+	}
+	if args[1] != "+" {
+		if r.to, err = strconv.ParseInt(args[1], 10, 64); err != nil || r.to < 0 {
+			return r, errors.New("TSDB: wrong toTimestamp")
+		}
+	}
+	args = args[2:]
+	for len(args) > 0 {
+		switch option := strings.ToUpper(args[0]); {
+		case option == "COUNT" && len(args) >= 2:
+			if r.count, err = strconv.Atoi(args[1]); err != nil || r.count <= 0 {
+				return r, errors.New("TSDB: Couldn't parse COUNT")
+			}
+			args = args[2:]
+		case option == "AGGREGATION" && len(args) >= 3:
+			r.agg.Type = strings.ToLower(args[1])
+			if !store.ValidTSAggregation(r.agg.Type) {
+				return r, errors.New("TSDB: Unknown aggregation type")
+			}
+			if r.agg.Bucket, err = strconv.ParseInt(args[2], 10, 64); err != nil || r.agg.Bucket <= 0 {
+				return r, errors.New("TSDB: bucketDuration must be greater than zero")
+			}
+			args = args[3:]
+		case multi && option == "WITHLABELS":
+			r.withLabels = true
+			args = args[1:]
+		case multi && option == "FILTER":
+			for _, arg := range args[1:] {
+				f, err := parseTSFilter(arg)
+				if err != nil {
+					return r, err
+				}
+				r.filters = append(r.filters, f)
+			}
+			args = nil
+		default:
+			return r, errors.New("syntax error")
+		}
+	}
+	if multi {
+		// a query must select series by a label value, not only by
+		// missing labels or exclusions
+		for _, f := range r.filters {
+			if !f.Negate && !(len(f.Values) == 1 && f.Values[0] == "") {
+				return r, nil
+			}
+		}
+		return r, errors.New("TSDB: please provide at least one matcher")
+	}
+	return r, nil
+}
+
+// tscreate implements TS.CREATE key [RETENTION retentionPeriod] [LABELS
+// label value ...].
+func tscreate(parsed []string, client *Client) (string, error) {
+	opts, err := parseTSOptions(parsed[2:])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	if err := store.TSCreate(client.DB, parsed[1], opts); err != nil {
+		return serializeTypedError(err), err
+	}
+	return protocol.SerializeSimpleString("OK"), nil
+}
+
+// tsadd implements TS.ADD key timestamp value [RETENTION retentionPeriod]
+// [LABELS label value ...]. The options only apply to a new series.
+func tsadd(parsed []string, client *Client) (string, error) {
+	ts, err := parseTimestamp(parsed[2])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	value, err := parseSampleValue(parsed[3])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	opts, err := parseTSOptions(parsed[4:])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	if err := store.TSAdd(client.DB, parsed[1], ts, value, opts); err != nil {
+		return serializeTypedError(err), err
+	}
+	return protocol.SerializeInteger(int(ts)), nil
+}
+
+// tsmadd implements TS.MADD key timestamp value [key timestamp value ...].
+func tsmadd(parsed []string, client *Client) (string, error) {
+	items := make([]store.TSAddItem, 0, (len(parsed)-1)/3)
+	for i := 1; i < len(parsed); i += 3 {
+		ts, err := parseTimestamp(parsed[i+1])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		value, err := parseSampleValue(parsed[i+2])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		items = append(items, store.TSAddItem{Key: parsed[i], Timestamp: ts, Value: value})
+	}
+	response := make([]string, len(items))
+	for i, err := range store.TSMAdd(client.DB, items) {
+		if err != nil {
+			response[i] = serializeTypedError(err)
+		} else {
+			response[i] = protocol.SerializeInteger(int(items[i].Timestamp))
+		}
+	}
+	return protocol.SerializeRawArray(response), nil
+}
+
+// tsget implements TS.GET key.
+func tsget(parsed []string, client *Client) (string, error) {
+	sample, ok, err := store.TSGet(client.DB, parsed[1])
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !ok {
+		return protocol.SerializeRawArray(nil), nil
+	}
+	return serializeSample(sample), nil
+}
+
+// tsrange implements TS.RANGE key fromTimestamp toTimestamp [COUNT count]
+// [AGGREGATION aggregator bucketDuration].
+func tsrange(parsed []string, client *Client) (string, error) {
+	r, err := parseTSRange(parsed[2:], false)
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	samples, err := store.TSRange(client.DB, parsed[1], r.from, r.to, r.count, r.agg)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return serializeSamples(samples), nil
+}
+
+// tsmrange implements TS.MRANGE fromTimestamp toTimestamp [COUNT count]
+// [AGGREGATION aggregator bucketDuration] [WITHLABELS] FILTER filter...
+func tsmrange(parsed []string, client *Client) (string, error) {
+	r, err := parseTSRange(parsed[1:], true)
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	series := store.TSMRange(client.DB, r.from, r.to, r.count, r.agg, r.filters)
+	response := make([]string, len(series))
+	for i, s := range series {
+		labels := []string{}
+		if r.withLabels {
+			for _, l := range s.Labels {
+				labels = append(labels, protocol.SerializeArray([]string{l.Name, l.Value}))
+			}
+		}
+		response[i] = protocol.SerializeRawArray([]string{
+			protocol.SerializeBulkString(s.Key),
+			protocol.SerializeRawArray(labels),
+			serializeSamples(s.Samples),
+		})
+	}
+	return protocol.SerializeRawArray(response), nil
+}
@@ -0,0 +1,375 @@
+package commands
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"litekv/internal/protocol"
+	"litekv/internal/store"
+)
+
+// formatScore formats a score like Redis: plain decimal notation for usual
+// magnitudes, exponent notation for very large or small ones.
+func formatScore(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f == 0 || (math.Abs(f) >= 1e-6 && math.Abs(f) < 1e21):
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// serializeZMembers replies with members, followed by their scores when
+// withScores is set.
+func serializeZMembers(items []store.ZMember, withScores bool) string {
+	response := make([]string, 0, len(items)*2)
+	for _, item := range items {
+		response = append(response, item.Member)
+		if withScores {
+			response = append(response, formatScore(item.Score))
+		}
+	}
+	return protocol.SerializeArray(response)
+}
+
+// zadd implements ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member ...
+func zadd(parsed []string, client *Client) (string, error) {
+	var opts store.ZAddOptions
+	incr := false
+	i := 2
+flags:
+	for ; i < len(parsed); i++ {
+		switch strings.ToUpper(parsed[i]) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "GT":
+			opts.GT = true
+		case "LT":
+			opts.LT = true
+		case "CH":
+			opts.CH = true
+		case "INCR":
+			incr = true
+		default:
+			break flags
+		}
+	}
+	pairs := parsed[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return protocol.SerializeError("syntax error"), errors.New("syntax error")
+	}
+	if opts.NX && opts.XX {
+		return protocol.SerializeError("XX and NX options at the same time are not compatible"), errors.New("XX and NX options at the same time are not compatible")
+	}
+	if (opts.GT && opts.LT) || (opts.NX && (opts.GT || opts.LT)) {
+		return protocol.SerializeError("GT, LT, and/or NX options at the same time are not compatible"), errors.New("GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) != 2 {
+		return protocol.SerializeError("INCR option supports a single increment-element pair"), errors.New("INCR option supports a single increment-element pair")
+	}
+
+	items := make([]store.ZMember, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := store.ParseScore(pairs[j])
+		if err != nil {
+			return protocol.SerializeError(err.Error()), err
+		}
+		items = append(items, store.ZMember{Member: pairs[j+1], Score: score})
+	}
+
+	if incr {
+		score, ok, err := store.ZIncrBy(client.DB, parsed[1], opts, items[0].Score, items[0].Member)
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		if !ok {
+			return protocol.SerializeNull(), nil
+		}
+		return protocol.SerializeBulkString(formatScore(score)), nil
+	}
+	return serializeTypedInteger(store.ZAdd(client.DB, parsed[1], opts, items))
+}
+
+// parseZRange parses "min max [BYSCORE|BYLEX] [REV] [LIMIT offset count]
+// [WITHSCORES]" as accepted by ZRANGE.
+func parseZRange(args []string) (store.ZRangeSpec, bool, error) {
+	spec := store.ZRangeSpec{By: "rank", Count: -1}
+	withScores, limit := false, false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "BYSCORE":
+			spec.By = "score"
+		case "BYLEX":
+			spec.By = "lex"
+		case "REV":
+			spec.Rev = true
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return spec, false, errors.New("syntax error")
+			}
+			offset, err1 := strconv.Atoi(args[i+1])
+			count, err2 := strconv.Atoi(args[i+2])
+			if err1 != nil || err2 != nil {
+				return spec, false, errors.New("value is not an integer or out of range")
+			}
+			spec.Offset, spec.Count = offset, count
+			limit = true
+			i += 2
+		default:
+			return spec, false, errors.New("syntax error")
+		}
+	}
+	if limit && spec.By == "rank" {
+		return spec, false, errors.New("syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if withScores && spec.By == "lex" {
+		return spec, false, errors.New("syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	if spec.Offset < 0 {
+		return spec, withScores, nil
+	}
+
+	// with REV the arguments are given from the highest to the lowest
+	min, max := args[0], args[1]
+	if spec.Rev && spec.By != "rank" {
+		min, max = max, min
+	}
+	var err error
+	switch spec.By {
+	case "score":
+		spec.Score, err = store.ParseScoreRange(min, max)
+	case "lex":
+		spec.Lex, err = store.ParseLexRange(min, max)
+	default:
+		start, err1 := strconv.Atoi(min)
+		stop, err2 := strconv.Atoi(max)
+		if err1 != nil || err2 != nil {
+			err = errors.New("value is not an integer or out of range")
+		}
+		spec.Start, spec.Stop = start, stop
+	}
+	return spec, withScores, err
+}
+
+// zrange implements ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT
+// offset count] [WITHSCORES].
+func zrange(parsed []string, client *Client) (string, error) {
+	spec, withScores, err := parseZRange(parsed[2:])
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	if spec.Offset < 0 {
+		return protocol.SerializeArray([]string{}), nil
+	}
+	items, err := store.ZRange(client.DB, parsed[1], spec)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return serializeZMembers(items, withScores), nil
+}
+
+// zrank implements ZRANK and ZREVRANK key member [WITHSCORE].
+func zrank(parsed []string, client *Client) (string, error) {
+	withScore := false
+	if len(parsed) == 4 {
+		if strings.ToUpper(parsed[3]) != "WITHSCORE" {
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+		withScore = true
+	}
+	rank, score, ok, err := store.ZRank(client.DB, parsed[1], parsed[2], parsed[0] == "ZREVRANK")
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	if !ok {
+		if withScore {
+			return protocol.SerializeNullArray(), nil
+		}
+		return protocol.SerializeNull(), nil
+	}
+	if withScore {
+		return protocol.SerializeRawArray([]string{
+			protocol.SerializeInteger(rank),
+			protocol.SerializeBulkString(formatScore(score)),
+		}), nil
+	}
+	return protocol.SerializeInteger(rank), nil
+}
+
+// parseNumKeys reads "numkeys key ..." at the start of args and returns the
+// keys and the remaining arguments.
+func parseNumKeys(args []string) ([]string, []string, error) {
+	if len(args) == 0 {
+		return nil, nil, errors.New("syntax error")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, errors.New("value is not an integer or out of range")
+	}
+	if n <= 0 {
+		return nil, nil, errors.New("at least 1 input key is needed")
+	}
+	if n > len(args)-1 {
+		return nil, nil, errors.New("syntax error")
+	}
+	return args[1 : n+1], args[n+1:], nil
+}
+
+// zcombine implements ZUNION, ZINTER and ZDIFF and their STORE variants:
+// [dst] numkeys key ... [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+// [WITHSCORES]. ZDIFF accepts neither WEIGHTS nor AGGREGATE.
+func zcombine(parsed []string, client *Client) (string, error) {
+	name := parsed[0]
+	storeResult := strings.HasSuffix(name, "STORE")
+	op := strings.ToLower(strings.TrimSuffix(name[1:], "STORE"))
+	args := parsed[1:]
+	if storeResult {
+		args = parsed[2:]
+	}
+	keys, rest, err := parseNumKeys(args)
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+
+	weights := make([]float64, len(keys))
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := "SUM"
+	withScores := false
+	for i := 0; i < len(rest); i++ {
+		switch option := strings.ToUpper(rest[i]); {
+		case option == "WEIGHTS" && op != "diff" && i+len(keys) < len(rest):
+			for j := range keys {
+				weight, err := store.ParseScore(rest[i+1+j])
+				if err != nil {
+					return protocol.SerializeError("weight value is not a float"), errors.New("weight value is not a float")
+				}
+				weights[j] = weight
+			}
+			i += len(keys)
+		case option == "AGGREGATE" && op != "diff" && i+1 < len(rest):
+			aggregate = strings.ToUpper(rest[i+1])
+			if aggregate != "SUM" && aggregate != "MIN" && aggregate != "MAX" {
+				return protocol.SerializeError("syntax error"), errors.New("syntax error")
+			}
+			i++
+		case option == "WITHSCORES" && !storeResult:
+			withScores = true
+		default:
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+	}
+
+	if storeResult {
+		return serializeTypedInteger(store.ZCombineStore(client.DB, parsed[1], op, keys, weights, aggregate))
+	}
+	items, err := store.ZCombine(client.DB, op, keys, weights, aggregate)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return serializeZMembers(items, withScores), nil
+}
+
+// zrangestore implements ZRANGESTORE dst src min max [BYSCORE|BYLEX] [REV]
+// [LIMIT offset count].
+func zrangestore(parsed []string, client *Client) (string, error) {
+	spec, withScores, err := parseZRange(parsed[3:])
+	if err == nil && withScores {
+		err = errors.New("syntax error")
+	}
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	if spec.Offset < 0 {
+		spec.Count = 0
+	}
+	return serializeTypedInteger(store.ZRangeStore(client.DB, parsed[1], parsed[2], spec))
+}
+
+// zpop implements ZPOPMIN and ZPOPMAX key [count]. Without count it replies
+// with a single member and score.
+func zpop(parsed []string, client *Client) (string, error) {
+	count := 1
+	if len(parsed) == 3 {
+		n, err := strconv.Atoi(parsed[2])
+		if err != nil || n < 0 {
+			return protocol.SerializeError("value is out of range, must be positive"), errors.New("value is out of range, must be positive")
+		}
+		count = n
+	}
+	if count == 0 {
+		return protocol.SerializeArray([]string{}), nil
+	}
+	items, err := store.ZPop(client.DB, parsed[1], count, parsed[0] == "ZPOPMAX")
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return serializeZMembers(items, true), nil
+}
+
+// zrandmember implements ZRANDMEMBER key [count [WITHSCORES]].
+func zrandmember(parsed []string, client *Client) (string, error) {
+	if len(parsed) == 2 {
+		items, err := store.ZRandMember(client.DB, parsed[1], 1)
+		if err != nil {
+			return serializeTypedError(err), err
+		}
+		if len(items) == 0 {
+			return protocol.SerializeNull(), nil
+		}
+		return protocol.SerializeBulkString(items[0].Member), nil
+	}
+	count, err := strconv.Atoi(parsed[2])
+	if err != nil {
+		return protocol.SerializeError("value is not an integer or out of range"), errors.New("value is not an integer or out of range")
+	}
+	withScores := false
+	if len(parsed) == 4 {
+		if strings.ToUpper(parsed[3]) != "WITHSCORES" {
+			return protocol.SerializeError("syntax error"), errors.New("syntax error")
+		}
+		withScores = true
+	}
+	items, err := store.ZRandMember(client.DB, parsed[1], count)
+	if err != nil {
+		return serializeTypedError(err), err
+	}
+	return serializeZMembers(items, withScores), nil
+}
+
+// zremrange implements ZREMRANGEBYRANK, ZREMRANGEBYSCORE and ZREMRANGEBYLEX
+// key min max.
+func zremrange(parsed []string, client *Client) (string, error) {
+	spec := store.ZRangeSpec{Count: -1}
+	var err error
+	switch parsed[0] {
+	case "ZREMRANGEBYRANK":
+		spec.By = "rank"
+		start, err1 := strconv.Atoi(parsed[2])
+		stop, err2 := strconv.Atoi(parsed[3])
+		if err1 != nil || err2 != nil {
+			err = errors.New("value is not an integer or out of range")
+		}
+		spec.Start, spec.Stop = start, stop
+	case "ZREMRANGEBYSCORE":
+		spec.By = "score"
+		spec.Score, err = store.ParseScoreRange(parsed[2], parsed[3])
+	case "ZREMRANGEBYLEX":
+		spec.By = "lex"
+		spec.Lex, err = store.ParseLexRange(parsed[2], parsed[3])
+	}
+	if err != nil {
+		return protocol.SerializeError(err.Error()), err
+	}
+	return serializeTypedInteger(store.ZRemRange(client.DB, parsed[1], spec))
+}
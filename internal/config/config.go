@@ -0,0 +1,265 @@
+package config
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Runtime settings. They can be given on the command line as
+// "--name value" pairs and read or changed with CONFIG GET / CONFIG SET.
+
+var mu sync.RWMutex
+
+var (
+	maxMemory        int64
+	maxMemoryPolicy  = "noeviction"
+	maxMemorySamples = 5
+	databases        = 16
+
+	// encoding thresholds of small collections, same defaults as redis.conf
+	hashMaxListpackEntries = 128
+	hashMaxListpackValue   = 64
+	setMaxIntsetEntries    = 512
+	setMaxListpackEntries  = 128
+	setMaxListpackValue    = 64
+	listMaxListpackSize    = 128
+	hllSparseMaxBytes      = 3000
+)
+
+var policies = []string{
+	"noeviction",
+	"allkeys-lru",
+	"volatile-lru",
+	"allkeys-lfu",
+	"volatile-lfu",
+	"allkeys-random",
+	"volatile-random",
+	"volatile-ttl",
+}
+
+type param struct {
+	get func() string
+	set func(string) error
+	// startup settings can only be given on the command line
+	startup bool
+}
+
+var params = map[string]param{
+	"maxmemory": {
+		get: func() string { return strconv.FormatInt(maxMemory, 10) },
+		set: func(v string) error {
+			n, err := ParseMemory(v)
+			if err != nil {
+				return err
+			}
+			maxMemory = n
+			return nil
+		},
+	},
+	"maxmemory-policy": {
+		get: func() string { return maxMemoryPolicy },
+		set: func(v string) error {
+			v = strings.ToLower(v)
+			for _, p := range policies {
+				if p == v {
+					maxMemoryPolicy = v
+					return nil
+				}
+			}
+			return errors.New("invalid maxmemory-policy")
+		},
+	},
+	"maxmemory-samples": {
+		get: func() string { return strconv.Itoa(maxMemorySamples) },
+		set: func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return errors.New("maxmemory-samples must be a positive integer")
+			}
+			maxMemorySamples = n
+			return nil
+		},
+	},
+	"databases": {
+		get: func() string { return strconv.Itoa(databases) },
+		set: func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return errors.New("databases must be a positive integer")
+			}
+			databases = n
+			return nil
+		},
+		startup: true,
+	},
+	"hash-max-listpack-entries": sizeParam(&hashMaxListpackEntries, "hash-max-listpack-entries", 0),
+	"hash-max-listpack-value":   sizeParam(&hashMaxListpackValue, "hash-max-listpack-value", 0),
+	"set-max-intset-entries":    sizeParam(&setMaxIntsetEntries, "set-max-intset-entries", 0),
+	"set-max-listpack-entries":  sizeParam(&setMaxListpackEntries, "set-max-listpack-entries", 0),
+	"set-max-listpack-value":    sizeParam(&setMaxListpackValue, "set-max-listpack-value", 0),
+	"list-max-listpack-size":    sizeParam(&listMaxListpackSize, "list-max-listpack-size", 1),
+	"hll-sparse-max-bytes":      sizeParam(&hllSparseMaxBytes, "hll-sparse-max-bytes", 0),
+}
+
+// sizeParam is an integer setting that cannot go below minimum.
+func sizeParam(v *int, name string, minimum int) param {
+	return param{
+		get: func() string { return strconv.Itoa(*v) },
+		set: func(value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < minimum {
+				return errors.New(name + " must be an integer >= " + strconv.Itoa(minimum))
+			}
+			*v = n
+			return nil
+		},
+	}
+}
+
+// Get returns name/value pairs for every setting matching the glob pattern.
+func Get(pattern string) []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0)
+	for name := range params {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	response := make([]string, 0, len(names)*2)
+	for _, name := range names {
+		response = append(response, name, params[name].get())
+	}
+	return response
+}
+
+// Set changes a setting at runtime.
+func Set(name string, value string) error {
+	return set(name, value, false)
+}
+
+func set(name string, value string, startup bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+	p, ok := params[strings.ToLower(name)]
+	if !ok {
+		return errors.New("Unknown option '" + name + "'")
+	}
+	if p.startup && !startup {
+		return errors.New("can't set immutable config '" + name + "'")
+	}
+	return p.set(value)
+}
+
+// ParseArgs applies "--name value" pairs from the command line.
+func ParseArgs(args []string) error {
+	for i := 0; i < len(args); i++ {
+		name, ok := strings.CutPrefix(args[i], "--")
+		if !ok || i+1 >= len(args) {
+			return errors.New("expected --name value, got '" + args[i] + "'")
+		}
+		if err := set(name, args[i+1], true); err != nil {
+			return err
+		}
+		i++
+	}
+	return nil
+}
+
+// ParseMemory parses sizes such as "100", "64kb", "1gb" the way redis.conf
+// does: k/m/g are powers of 1000, kb/mb/gb are powers of 1024.
+func ParseMemory(value string) (int64, error) {
+	v := strings.ToLower(value)
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(v, u.suffix) {
+			v = strings.TrimSuffix(v, u.suffix)
+			mul = u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid memory value '" + value + "'")
+	}
+	return n * mul, nil
+}
+
+func MaxMemory() int64 {
+	mu.RLock()
+	defer mu.RUnlock()
+	return maxMemory
+}
+
+func MaxMemoryPolicy() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return maxMemoryPolicy
+}
+
+func Databases() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return databases
+}
+
+func MaxMemorySamples() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return maxMemorySamples
+}
+
+func HashMaxListpackEntries() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return hashMaxListpackEntries
+}
+
+func HashMaxListpackValue() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return hashMaxListpackValue
+}
+
+func SetMaxIntsetEntries() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return setMaxIntsetEntries
+}
+
+func SetMaxListpackEntries() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return setMaxListpackEntries
+}
+
+func SetMaxListpackValue() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return setMaxListpackValue
+}
+
+func ListMaxListpackSize() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return listMaxListpackSize
+}
+
+func HLLSparseMaxBytes() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return hllSparseMaxBytes
+}
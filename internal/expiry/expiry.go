@@ -1 +1,104 @@
 package expiry
+
+import (
+	"sync/atomic"
+	"time"
+
+	"litekv/internal/store"
+)
+
+// Active expiry works like Redis' activeExpireCycle: instead of walking every
+// key with a TTL, each tick samples a few of them and only keeps going while
+// a large share of the sample turns out to be expired. Every sample takes the
+// store lock on its own, so a cycle never holds it for long. Hash fields with
+// their own TTL are sampled the same way once the keys of a database are
+// done.
+const (
+	hz              = 10                   // cycles per second
+	keysPerLoop     = 20                   // keys sampled per lock acquisition
+	acceptableStale = 25                   // percent of expired keys that stops the cycle
+	cycleBudget     = time.Second / hz / 4 // max time spent in one cycle
+)
+
+var (
+	expiredKeys    atomic.Int64
+	expiredFields  atomic.Int64
+	cycles         atomic.Int64
+	timedOutCycles atomic.Int64
+	lastCycle      atomic.Int64
+	totalCycle     atomic.Int64
+)
+
+// Stats is a snapshot of the active expiry metrics.
+type Stats struct {
+	ExpiredKeys    int64
+	ExpiredFields  int64
+	Cycles         int64
+	TimedOutCycles int64
+	LastCycle      time.Duration
+	TotalCycleTime time.Duration
+}
+
+// Run starts the active expiry loop. It never returns.
+func Run() {
+	ticker := time.NewTicker(time.Second / hz)
+	defer ticker.Stop()
+	for range ticker.C {
+		cycle()
+	}
+}
+
+// nextDB is the database the next cycle starts from, so that a cycle running
+// out of time does not starve the databases after it.
+var nextDB int
+
+func cycle() {
+	start := time.Now()
+	databases := store.Databases()
+	timedOut := false
+	for i := 0; i < databases && !timedOut; i++ {
+		db := nextDB % databases
+		nextDB++
+		timedOut = sampleLoop(start, &expiredKeys, func() (int, int) {
+			return store.ExpireSample(db, keysPerLoop)
+		})
+		if !timedOut {
+			timedOut = sampleLoop(start, &expiredFields, func() (int, int) {
+				return store.ExpireFieldsSample(db, keysPerLoop)
+			})
+		}
+	}
+	elapsed := time.Since(start)
+	cycles.Add(1)
+	lastCycle.Store(int64(elapsed))
+	totalCycle.Add(int64(elapsed))
+}
+
+// sampleLoop calls sample until few of the sampled entries were expired,
+// adding the expired ones to counter. It reports whether the cycle ran out of
+// time.
+func sampleLoop(start time.Time, counter *atomic.Int64, sample func() (int, int)) bool {
+	for {
+		sampled, expired := sample()
+		counter.Add(int64(expired))
+		if sampled == 0 || expired*100 <= sampled*acceptableStale {
+			return false
+		}
+		if time.Since(start) > cycleBudget {
+			timedOutCycles.Add(1)
+			return true
+		}
+	}
+}
+
+// GetStats returns the metrics collected since the server started.
+func GetStats() Stats {
+	return Stats{
+		ExpiredKeys:    expiredKeys.Load(),
+		ExpiredFields:  expiredFields.Load(),
+		Cycles:         cycles.Load(),
+		TimedOutCycles: timedOutCycles.Load(),
+		LastCycle:      time.Duration(lastCycle.Load()),
+		TotalCycleTime: time.Duration(totalCycle.Load()),
+	}
+}
@@ -5,35 +5,56 @@ import (
 	"litekv/internal/store"
 	"log"
 	"os"
+	"strconv"
 	"time"
+	"unicode/utf8"
 )
 
 type Database struct {
-	Strings map[string]string            `json:"strings"`
-	Lists   map[string][]string          `json:"lists"`
-	Hashes  map[string]map[string]string `json:"hashes"`
-	Sets    map[string][]string          `json:"sets"`
-	Expiry  map[string]time.Time         `json:"expiry"`
+	Strings map[string]string            `json:"strings,omitempty"`
+	Lists   map[string][]string          `json:"lists,omitempty"`
+	Hashes  map[string]map[string]string `json:"hashes,omitempty"`
+	Sets    map[string][]string          `json:"sets,omitempty"`
+	Expiry  map[string]time.Time         `json:"expiry,omitempty"`
+	// sorted set scores are kept as strings because JSON has no infinity
+	ZSets   map[string]map[string]string `json:"zsets,omitempty"`
+	Streams map[string]store.StreamDump  `json:"streams,omitempty"`
+	// per-field TTLs of hashes, by key then field
+	HashExpiry map[string]map[string]time.Time `json:"hash_expiry,omitempty"`
+	// strings that are not valid UTF-8, such as bitmaps, which JSON strings
+	// cannot hold; []byte is encoded as base64
+	BinaryStrings map[string][]byte `json:"binary_strings,omitempty"`
+	// lists, hashes, sets and sorted sets holding such strings; hashes are
+	// field, value pairs and sorted sets member, score pairs, as JSON object
+	// keys cannot hold them either
+	BinaryLists  map[string][][]byte `json:"binary_lists,omitempty"`
+	BinaryHashes map[string][][]byte `json:"binary_hashes,omitempty"`
+	BinarySets   map[string][][]byte `json:"binary_sets,omitempty"`
+	BinaryZSets  map[string][][]byte `json:"binary_zsets,omitempty"`
+	// JSON documents, embedded as they are
+	JSON map[string]json.RawMessage `json:"json,omitempty"`
+	// probabilistic structures
+	Blooms   map[string]store.BloomDump `json:"blooms,omitempty"`
+	Sketches map[string]store.CMSDump   `json:"sketches,omitempty"`
+	// time series keep their compressed chunks, encoded as base64
+	TimeSeries map[string]store.TSDump `json:"timeseries,omitempty"`
 }
 
-func Save() bool {
-	var config Database
-	config.Sets = make(map[string][]string)
-	config.Hashes = map[string]map[string]string{}
-	config.Expiry = map[string]time.Time{}
-
-	strings, expiry, lists, hashes, sets := store.GetSnapshot()
-	config.Strings = strings
-	config.Expiry = expiry
-	config.Lists = lists
-	config.Hashes = hashes
+// Snapshot is the layout of data.json. Files written before multiple
+// databases existed hold a single Database at the top level; it is loaded
+// into database 0.
+type Snapshot struct {
+	Database
+	Databases map[int]Database `json:"databases,omitempty"`
+}
 
-	// Convert sets from map[string]bool to []string
-	for k, v := range sets {
-		for item, exists := range v {
-			if exists {
-				config.Sets[k] = append(config.Sets[k], item)
-			}
+func Save() bool {
+	var snapshot Snapshot
+	snapshot.Databases = make(map[int]Database)
+	for db, data := range store.GetSnapshot() {
+		config := snapshotDB(data)
+		if len(config.Strings)+len(config.BinaryStrings)+len(config.Lists)+len(config.BinaryLists)+len(config.Hashes)+len(config.BinaryHashes)+len(config.Sets)+len(config.BinarySets)+len(config.ZSets)+len(config.BinaryZSets)+len(config.Streams)+len(config.JSON)+len(config.Blooms)+len(config.Sketches)+len(config.TimeSeries) > 0 {
+			snapshot.Databases[db] = config
 		}
 	}
 
@@ -49,7 +70,7 @@ func Save() bool {
 	defer file.Close()
 
 	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(&config); err != nil {
+	if err := encoder.Encode(&snapshot); err != nil {
 		log.Print("Error saving data in file: ", err)
 		return false
 	}
@@ -57,6 +78,55 @@ func Save() bool {
 
 }
 
+func snapshotDB(data store.DBSnapshot) Database {
+	var config Database
+	config.Sets = make(map[string][]string)
+
+	config.Strings = data.Strings
+	config.BinaryStrings = make(map[string][]byte)
+	for k, v := range data.Strings {
+		if !utf8.ValidString(v) {
+			config.BinaryStrings[k] = []byte(v)
+			delete(data.Strings, k)
+		}
+	}
+	config.Expiry = data.Expiry
+	config.Lists = data.Lists
+	config.Hashes = data.Hashes
+	config.HashExpiry = data.HashExpiry
+
+	// Convert sets from map[string]bool to []string
+	for k, v := range data.Sets {
+		for item, exists := range v {
+			if exists {
+				config.Sets[k] = append(config.Sets[k], item)
+			}
+		}
+	}
+	// Sorted sets
+	config.ZSets = make(map[string]map[string]string)
+	for k, v := range data.ZSets {
+		config.ZSets[k] = make(map[string]string)
+		for _, item := range v {
+			config.ZSets[k][item.Member] = strconv.FormatFloat(item.Score, 'g', -1, 64)
+		}
+	}
+	// Collections holding binary strings
+	splitBinary(&config)
+	// Streams
+	config.Streams = data.Streams
+	// JSON documents
+	config.JSON = make(map[string]json.RawMessage)
+	for k, v := range data.JSON {
+		config.JSON[k] = json.RawMessage(v)
+	}
+	// Bloom filters and Count-Min Sketches
+	config.Blooms = data.Blooms
+	config.Sketches = data.Sketches
+	config.TimeSeries = data.TimeSeries
+	return config
+}
+
 func Load() {
 	file, err := os.OpenFile(
 		"data.json",
@@ -76,42 +146,232 @@ func Load() {
 	}
 
 	decoder := json.NewDecoder(file)
-	var data Database
+	var data Snapshot
 	if err := decoder.Decode(&data); err != nil {
 		log.Printf("Error decoding json file, %v", err)
 		return
 	}
+
+	loadDB(0, data.Database)
+	for db, config := range data.Databases {
+		if db < 0 || db >= store.Databases() {
+			log.Printf("Skipping database %d, only %d databases configured", db, store.Databases())
+			continue
+		}
+		loadDB(db, config)
+	}
+
+	store.RebuildMeta()
+}
+
+// binary returns items as byte slices if any of them is not valid UTF-8.
+func binary(items []string) ([][]byte, bool) {
+	for _, item := range items {
+		if !utf8.ValidString(item) {
+			response := make([][]byte, len(items))
+			for i, item := range items {
+				response[i] = []byte(item)
+			}
+			return response, true
+		}
+	}
+	return nil, false
+}
+
+// splitBinary moves the lists, hashes, sets and sorted sets holding
+// strings that are not valid UTF-8 to their binary counterparts.
+func splitBinary(config *Database) {
+	config.BinaryLists = make(map[string][][]byte)
+	for k, v := range config.Lists {
+		if items, ok := binary(v); ok {
+			config.BinaryLists[k] = items
+			delete(config.Lists, k)
+		}
+	}
+	config.BinaryHashes = make(map[string][][]byte)
+	for k, v := range config.Hashes {
+		pairs := make([]string, 0, 2*len(v))
+		for field, value := range v {
+			pairs = append(pairs, field, value)
+		}
+		if items, ok := binary(pairs); ok {
+			config.BinaryHashes[k] = items
+			delete(config.Hashes, k)
+		}
+	}
+	config.BinarySets = make(map[string][][]byte)
+	for k, v := range config.Sets {
+		if items, ok := binary(v); ok {
+			config.BinarySets[k] = items
+			delete(config.Sets, k)
+		}
+	}
+	config.BinaryZSets = make(map[string][][]byte)
+	for k, v := range config.ZSets {
+		pairs := make([]string, 0, 2*len(v))
+		for member, score := range v {
+			pairs = append(pairs, member, score)
+		}
+		if items, ok := binary(pairs); ok {
+			config.BinaryZSets[k] = items
+			delete(config.ZSets, k)
+		}
+	}
+}
+
+// joinBinary is the reverse of splitBinary.
+func joinBinary(data *Database) {
+	if data.Strings == nil {
+		data.Strings = make(map[string]string)
+	}
+	for k, v := range data.BinaryStrings {
+		data.Strings[k] = string(v)
+	}
+	if data.Lists == nil {
+		data.Lists = make(map[string][]string)
+	}
+	for k, v := range data.BinaryLists {
+		for _, item := range v {
+			data.Lists[k] = append(data.Lists[k], string(item))
+		}
+	}
+	if data.Hashes == nil {
+		data.Hashes = make(map[string]map[string]string)
+	}
+	for k, v := range data.BinaryHashes {
+		data.Hashes[k] = make(map[string]string)
+		for i := 0; i+1 < len(v); i += 2 {
+			data.Hashes[k][string(v[i])] = string(v[i+1])
+		}
+	}
+	if data.Sets == nil {
+		data.Sets = make(map[string][]string)
+	}
+	for k, v := range data.BinarySets {
+		for _, item := range v {
+			data.Sets[k] = append(data.Sets[k], string(item))
+		}
+	}
+	if data.ZSets == nil {
+		data.ZSets = make(map[string]map[string]string)
+	}
+	for k, v := range data.BinaryZSets {
+		data.ZSets[k] = make(map[string]string)
+		for i := 0; i+1 < len(v); i += 2 {
+			data.ZSets[k][string(v[i])] = string(v[i+1])
+		}
+	}
+}
+
+// dropExpired removes the keys whose TTL has passed from every type of
+// data, so that they are not loaded at all.
+func dropExpired(data *Database) {
+	for k, at := range data.Expiry {
+		if time.Until(at) > 0 {
+			continue
+		}
+		delete(data.Expiry, k)
+		delete(data.Strings, k)
+		delete(data.Lists, k)
+		delete(data.Hashes, k)
+		delete(data.HashExpiry, k)
+		delete(data.Sets, k)
+		delete(data.ZSets, k)
+		delete(data.Streams, k)
+		delete(data.JSON, k)
+		delete(data.Blooms, k)
+		delete(data.Sketches, k)
+		delete(data.TimeSeries, k)
+	}
+}
+
+func loadDB(db int, data Database) {
+	target := store.GetDB(db)
+	joinBinary(&data)
+	dropExpired(&data)
+
 	// TTL
 	for k, v := range data.Expiry {
-		store.Expiry[k] = v
+		target.Expiry[k] = v
 	}
 
 	// Strings( Redis_data )
 	for k, v := range data.Strings {
-		if duration, ok := store.Expiry[k]; ok {
-			if int(time.Until(duration).Truncate(time.Second).Seconds()) < 0 {
-				delete(store.Expiry, k)
-				continue
-			}
-		}
-		store.Redis_data[k] = v
+		target.Redis_data[k] = v
 	}
 
 	// Lists
-	store.List_data = data.Lists
+	for k, v := range data.Lists {
+		store.RPush(db, k, v...)
+	}
 
 	// SETS
 	for k, v := range data.Sets {
-		setMap := make(map[string]bool)
-		for _, item := range v {
-			setMap[item] = true
-		}
-		store.Set_data[k] = setMap
+		store.SAdd(db, k, v...)
 	}
 
 	// HASHES
 	for k, v := range data.Hashes {
-		store.Hash_data[k] = v
+		fieldValues := make([]string, 0, 2*len(v))
+		for field, value := range v {
+			fieldValues = append(fieldValues, field, value)
+		}
+		store.HSet(db, k, fieldValues...)
+	}
+
+	// HASH FIELD TTLs, fields already past their TTL are dropped
+	for k, fields := range data.HashExpiry {
+		for field, at := range fields {
+			store.HExpire(db, k, at, "", []string{field})
+		}
+	}
+
+	// SORTED SETS
+	for k, v := range data.ZSets {
+		items := make([]store.ZMember, 0, len(v))
+		for member, score := range v {
+			f, err := strconv.ParseFloat(score, 64)
+			if err != nil {
+				log.Printf("Skipping invalid score %q of %s in sorted set %s", score, member, k)
+				continue
+			}
+			items = append(items, store.ZMember{Member: member, Score: f})
+		}
+		store.ZAdd(db, k, store.ZAddOptions{}, items)
+	}
+
+	// STREAMS
+	for k, v := range data.Streams {
+		if err := store.LoadStream(db, k, v); err != nil {
+			log.Printf("Skipping stream %s: %v", k, err)
+		}
 	}
 
+	// JSON
+	for k, v := range data.JSON {
+		if err := store.LoadJSON(db, k, string(v)); err != nil {
+			log.Printf("Skipping JSON document %s: %v", k, err)
+		}
+	}
+
+	// BLOOM FILTERS
+	for k, v := range data.Blooms {
+		if err := store.LoadBloom(db, k, v); err != nil {
+			log.Printf("Skipping Bloom filter %s: %v", k, err)
+		}
+	}
+
+	// COUNT-MIN SKETCHES
+	for k, v := range data.Sketches {
+		if err := store.LoadCMS(db, k, v); err != nil {
+			log.Printf("Skipping Count-Min Sketch %s: %v", k, err)
+		}
+	}
+
+	// TIME SERIES
+	for k, v := range data.TimeSeries {
+		if err := store.LoadTS(db, k, v); err != nil {
+			log.Printf("Skipping time series %s: %v", k, err)
+		}
+	}
 }
@@ -0,0 +1,78 @@
+package persistence
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"litekv/internal/store"
+)
+
+func TestLoadSkipsExpiredKeys(t *testing.T) {
+	store.Init(1)
+	past := time.Now().Add(-time.Second)
+	future := time.Now().Add(time.Hour)
+	loadDB(0, Database{
+		Strings: map[string]string{"str": "v"},
+		Lists:   map[string][]string{"l": {"a", "b"}},
+		Hashes: map[string]map[string]string{
+			"h":    {"a": "1", "b": "2", "c": "3"},
+			"live": {"a": "1", "b": "2"},
+		},
+		Sets:   map[string][]string{"s": {"x", "y", "z"}},
+		Expiry: map[string]time.Time{"str": past, "l": past, "h": past, "s": past, "live": future},
+	})
+	store.RebuildMeta()
+
+	for _, key := range []string{"str", "l", "h", "s"} {
+		if store.Exists(0, key) {
+			t.Errorf("expired key %s was loaded", key)
+		}
+	}
+	when, hasTTL, exists := store.GetTTL(0, "live")
+	if !exists || !hasTTL || !when.Equal(future) {
+		t.Fatalf("live hash: exists %v, TTL %v at %v, want it with its TTL", exists, hasTTL, when)
+	}
+	if fields, _ := store.HGetAll(0, "live"); len(fields) != 4 {
+		t.Fatalf("live hash has %v, want both fields", fields)
+	}
+}
+
+func TestSaveLoadBinary(t *testing.T) {
+	t.Chdir(t.TempDir())
+	const bin = "\xff\x00\xfe"
+	store.Init(1)
+	store.Set(0, "str", bin)
+	store.RPush(0, "l", "a", bin)
+	store.HSet(0, "h", "f", bin, bin, "v")
+	store.SAdd(0, "s", bin)
+	store.ZAdd(0, "z", store.ZAddOptions{}, []store.ZMember{{Member: bin, Score: 1.5}})
+	store.HSet(0, "text", "f", "v")
+	if !Save() {
+		t.Fatal("SAVE failed")
+	}
+
+	store.Init(1)
+	Load()
+	if v, _, _ := store.Get(0, "str"); v != bin {
+		t.Errorf("string = %q, want %q", v, bin)
+	}
+	if v, _ := store.LRange(0, "l", 0, -1); !slices.Equal(v, []string{"a", bin}) {
+		t.Errorf("list = %q, want [a %q]", v, bin)
+	}
+	if v, _, _ := store.HGet(0, "h", "f"); v != bin {
+		t.Errorf("hash value = %q, want %q", v, bin)
+	}
+	if v, _, _ := store.HGet(0, "h", bin); v != "v" {
+		t.Errorf("value of the binary hash field = %q, want v", v)
+	}
+	if v, _ := store.SMembers(0, "s"); !slices.Equal(v, []string{bin}) {
+		t.Errorf("set = %q, want [%q]", v, bin)
+	}
+	if score, ok, _ := store.ZScore(0, "z", bin); !ok || score != 1.5 {
+		t.Errorf("score of the binary member = %v, %v, want 1.5", score, ok)
+	}
+	if v, _, _ := store.HGet(0, "text", "f"); v != "v" {
+		t.Errorf("text hash value = %q, want v", v)
+	}
+}
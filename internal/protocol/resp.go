@@ -3,39 +3,97 @@ package protocol
 import (
 	"bufio"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"strconv"
+	"strings"
 )
 
+// Limits on what a client may announce, as Redis' default
+// proto-max-bulk-len and its multibulk limit, so a header cannot make the
+// server allocate without bound. MaxLineLength caps the header lines
+// themselves, as Redis' PROTO_INLINE_MAX_SIZE.
+const (
+	MaxMultibulkLength = 1024 * 1024
+	MaxBulkLength      = 512 * 1024 * 1024
+	MaxLineLength      = 64 * 1024
+)
+
+// ErrProtocol wraps the errors of malformed requests, which are replied to
+// before the connection is closed.
+var ErrProtocol = errors.New("Protocol error")
+
+// Parse reads one command sent as a RESP array of bulk strings. Bulk
+// strings are read by their announced length, so values may hold any byte,
+// CR and LF included.
 func Parse(reader *bufio.Reader) ([]string, error) {
-	result := make([]string, 0)
-	prefix, _, _ := reader.ReadLine()
+	prefix, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
 	if len(prefix) == 0 {
 		return nil, errors.New("empty input")
 	}
-	totalElements, err := strconv.Atoi(string(prefix[1:]))
-	if err != nil {
-		log.Print(err)
-		return result, err
+	if prefix[0] != '*' {
+		return nil, fmt.Errorf("%w: expected '*', got '%s'", ErrProtocol, prefix[:1])
+	}
+	totalElements, err := strconv.Atoi(prefix[1:])
+	if err != nil || totalElements > MaxMultibulkLength {
+		return nil, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
 	}
+	result := make([]string, 0, max(totalElements, 0))
 	for i := 0; i < totalElements; i++ {
-		data, _, err := reader.ReadLine()
-		if prefix[0] == '*' && data[0] == '$' {
-			if data == nil {
-				return nil, errors.New("nil data")
-			}
-			if err != nil {
-				log.Print(err)
-				return nil, err
-			}
-			length, _ := strconv.Atoi(string(data[1:]))
-			text, _, _ := reader.ReadLine()
-			result = append(result, string(text[:length]))
+		data, err := readLine(reader)
+		if err != nil {
+			log.Print(err)
+			return nil, err
 		}
+		if len(data) == 0 || data[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$'", ErrProtocol)
+		}
+		length, err := strconv.Atoi(data[1:])
+		if err != nil || length < 0 || length > MaxBulkLength {
+			return nil, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+		}
+		// the body grows as it arrives rather than by the announced length
+		var text strings.Builder
+		if _, err := io.CopyN(&text, reader, int64(length)); err != nil {
+			return nil, err
+		}
+		crlf := make([]byte, 2)
+		if _, err := io.ReadFull(reader, crlf); err != nil {
+			return nil, err
+		}
+		if crlf[0] != '\r' || crlf[1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+		}
+		result = append(result, text.String())
 	}
 	return result, nil
 }
 
+// readLine reads a CRLF terminated header line and returns it without the
+// line ending. Lines longer than MaxLineLength are a protocol error.
+func readLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > MaxLineLength {
+			return "", fmt.Errorf("%w: too big header line", ErrProtocol)
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
 func SerializeSimpleString(s string) string {
 	// var result []byte
 	return "+" + s + "\r\n"
@@ -55,6 +113,16 @@ func SerializeArray(items []string) string {
 	return response
 }
 
+// SerializeRawArray wraps items that are already serialized, so arrays can
+// mix integers, nulls and nested arrays.
+func SerializeRawArray(items []string) string {
+	response := "*" + strconv.Itoa(len(items)) + "\r\n"
+	for _, item := range items {
+		response += item
+	}
+	return response
+}
+
 func SerializeInteger(n int) string {
 	return ":" + strconv.Itoa(n) + "\r\n"
 }
@@ -63,6 +131,16 @@ func SerializeError(msg string) string {
 	return "-ERR " + msg + "\r\n"
 }
 
+// SerializeErrorCode builds an error whose first word is a code other than
+// ERR, e.g. OOM or WRONGTYPE.
+func SerializeErrorCode(code string, msg string) string {
+	return "-" + code + " " + msg + "\r\n"
+}
+
 func SerializeNull() string {
 	return "$-1\r\n"
 }
+
+func SerializeNullArray() string {
+	return "*-1\r\n"
+}
@@ -0,0 +1,33 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseBinaryBulk(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("*2\r\n$3\r\nSET\r\n$4\r\na\r\nb\r\n"))
+	args, err := Parse(reader)
+	if err != nil || !slices.Equal(args, []string{"SET", "a\r\nb"}) {
+		t.Fatalf("Parse = %q, %v, want [SET a\\r\\nb]", args, err)
+	}
+}
+
+func TestParseLongHeaderLine(t *testing.T) {
+	for _, header := range []string{"*", "*1\r\n$"} {
+		// a header that never ends, which must not be buffered whole
+		input := header + strings.Repeat("1", 10*MaxLineLength)
+		_, err := Parse(bufio.NewReader(strings.NewReader(input)))
+		if !errors.Is(err, ErrProtocol) {
+			t.Fatalf("Parse of a %q header line longer than %d bytes = %v, want ErrProtocol", header, MaxLineLength, err)
+		}
+	}
+	// leading zeros keep a line just within the limit a valid count
+	input := "*" + strings.Repeat("0", MaxLineLength-10) + "1\r\n$3\r\nGET\r\n"
+	if args, err := Parse(bufio.NewReader(strings.NewReader(input))); err != nil || !slices.Equal(args, []string{"GET"}) {
+		t.Fatalf("Parse of a header line within the limit = %q, %v, want [GET]", args, err)
+	}
+}
@@ -2,7 +2,10 @@ package server
 
 import (
 	"bufio"
+	"errors"
 	"litekv/internal/commands"
+	"litekv/internal/config"
+	"litekv/internal/expiry"
 	"litekv/internal/persistence"
 	"litekv/internal/protocol"
 	"litekv/internal/pubsub"
@@ -16,13 +19,21 @@ func handleConnection(conn net.Conn) {
 	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
 	subscribed := false
+	client := &commands.Client{Conn: conn, Reader: reader}
 	for {
 		args, err := protocol.Parse(reader)
 
 		if err != nil {
 			log.Print(err)
+			if errors.Is(err, protocol.ErrProtocol) {
+				writer.Write([]byte(protocol.SerializeError(err.Error())))
+				writer.Flush()
+			}
 			return
 		}
+		if len(args) == 0 {
+			continue
+		}
 
 		if subscribed {
 			if args[0] != "SUBSCRIBE" && args[0] != "UNSUBSCRIBE" && args[0] != "PING" {
@@ -37,7 +48,7 @@ func handleConnection(conn net.Conn) {
 			subscribed = true
 		}
 
-		response, err := commands.Route(args, conn)
+		response, err := commands.Route(args, client)
 		if err != nil || response == "" {
 			log.Print(err)
 			log.Print(response)
@@ -62,7 +73,8 @@ func StartServer() {
 		return
 	}
 	log.Print("Listening to port 6379")
-	go store.CleanUp()
+	store.Init(config.Databases())
+	go expiry.Run()
 	persistence.Load()
 	for {
 		conn, err := connection.Accept()
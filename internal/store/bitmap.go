@@ -0,0 +1,381 @@
+package store
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Bitmaps are plain string values addressed bit by bit, bit 0 being the most
+// significant bit of the first byte, as in Redis. Writes past the end grow
+// the string with zero bytes.
+
+// MaxBitOffset is the largest bit offset a bitmap can be addressed at, the
+// last bit of a 512MB string.
+const MaxBitOffset = 512*1024*1024*8 - 1
+
+// stringForRead returns the string at key if it exists and is live.
+func (d *DB) stringForRead(key string) (string, bool, error) {
+	if ok, err := d.lookupKey(key, "string"); !ok {
+		return "", false, err
+	}
+	return d.Redis_data[key], true, nil
+}
+
+// stringForWrite is stringForRead for commands that rewrite the string: an
+// expired string is deleted first so its TTL does not carry over.
+func (d *DB) stringForWrite(key string) (string, bool, error) {
+	if ok, err := d.lookupKeyWrite(key, "string"); !ok {
+		return "", false, err
+	}
+	return d.Redis_data[key], true, nil
+}
+
+// bitmapForWrite returns a copy of the string at key grown to hold at least
+// size bytes, to be stored back with setString.
+func (d *DB) bitmapForWrite(key string, size int) ([]byte, error) {
+	v, _, err := d.stringForWrite(key)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, max(len(v), size))
+	copy(b, v)
+	return b, nil
+}
+
+func getBit(b []byte, offset uint64) int {
+	i := offset >> 3
+	if i >= uint64(len(b)) {
+		return 0
+	}
+	return int(b[i]>>(7-offset&7)) & 1
+}
+
+func setBit(b []byte, offset uint64, bit int) {
+	mask := byte(1) << (7 - offset&7)
+	if bit == 1 {
+		b[offset>>3] |= mask
+	} else {
+		b[offset>>3] &^= mask
+	}
+}
+
+// SetBit sets the bit at offset and returns its previous value.
+func SetBit(db int, key string, offset uint64, bit int) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	b, err := d.bitmapForWrite(key, int(offset>>3)+1)
+	if err != nil {
+		return 0, err
+	}
+	old := getBit(b, offset)
+	setBit(b, offset, bit)
+	d.setString(key, string(b))
+	return old, nil
+}
+
+// GetBit returns the bit at offset, 0 past the end of the string.
+func GetBit(db int, key string, offset uint64) (int, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	v, _, err := d.stringForRead(key)
+	return getBit([]byte(v), offset), err
+}
+
+// BitRange is the optional start/end range of BITCOUNT and BITPOS, in bytes
+// or, with Bit set, in bits. Negative indexes count from the end.
+type BitRange struct {
+	Start, End int
+	HasStart   bool
+	HasEnd     bool
+	Bit        bool
+}
+
+// bounds resolves r against a string of length bytes into a range of bit
+// offsets, reporting false if it is empty.
+func (r BitRange) bounds(length int) (uint64, uint64, bool) {
+	total := length
+	if r.Bit {
+		total = length * 8
+	}
+	start, end := 0, total-1
+	if r.HasStart {
+		start = r.Start
+	}
+	if r.HasEnd {
+		end = r.End
+	}
+	if start < 0 {
+		start = max(total+start, 0)
+	}
+	if end < 0 {
+		end = max(total+end, 0)
+	}
+	end = min(end, total-1)
+	if total == 0 || start > end {
+		return 0, 0, false
+	}
+	if r.Bit {
+		return uint64(start), uint64(end), true
+	}
+	return uint64(start) * 8, uint64(end)*8 + 7, true
+}
+
+// countBits counts the set bits of b from bit offset start to end included.
+func countBits(b []byte, start uint64, end uint64) int {
+	count := 0
+	for offset := start; offset <= end; {
+		if offset&7 == 0 && offset+7 <= end {
+			count += bits.OnesCount8(b[offset>>3])
+			offset += 8
+			continue
+		}
+		count += getBit(b, offset)
+		offset++
+	}
+	return count
+}
+
+// BitCount returns the number of set bits in range r.
+func BitCount(db int, key string, r BitRange) (int, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	v, _, err := d.stringForRead(key)
+	start, end, ok := r.bounds(len(v))
+	if !ok {
+		return 0, err
+	}
+	return countBits([]byte(v), start, end), nil
+}
+
+// BitPos returns the offset of the first bit set to bit in range r, -1 if
+// there is none. Looking for a 0 without an explicit end finds the first bit
+// past the string when the range is all ones, since the string behaves as
+// if padded with zeros.
+func BitPos(db int, key string, bit int, r BitRange) (int, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	v, ok, err := d.stringForRead(key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		if bit == 0 {
+			return 0, nil
+		}
+		return -1, nil
+	}
+	start, end, ok := r.bounds(len(v))
+	if !ok {
+		return -1, nil
+	}
+	b := []byte(v)
+	// whole bytes of all zeros or all ones can be skipped
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for offset := start; offset <= end; {
+		if offset&7 == 0 && offset+7 <= end && b[offset>>3] == skip {
+			offset += 8
+			continue
+		}
+		if getBit(b, offset) == bit {
+			return int(offset), nil
+		}
+		offset++
+	}
+	if bit == 0 && !r.HasEnd {
+		return int(end) + 1, nil
+	}
+	return -1, nil
+}
+
+// BitOp stores at dst the bitwise AND, OR or XOR of the strings at keys, or
+// the NOT of the one at keys[0]. Shorter strings are padded with zeros. It
+// returns the length of the result; an empty result deletes dst.
+func BitOp(db int, op string, dst string, keys []string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	values := make([]string, len(keys))
+	length := 0
+	for i, key := range keys {
+		v, _, err := d.stringForRead(key)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = v
+		length = max(length, len(v))
+	}
+	result := make([]byte, length)
+	copy(result, values[0])
+	for i := range result {
+		switch op {
+		case "NOT":
+			result[i] = ^result[i]
+		case "AND":
+			for _, v := range values[1:] {
+				if i < len(v) {
+					result[i] &= v[i]
+				} else {
+					result[i] = 0
+				}
+			}
+		case "OR", "XOR":
+			for _, v := range values[1:] {
+				if i >= len(v) {
+					continue
+				}
+				if op == "OR" {
+					result[i] |= v[i]
+				} else {
+					result[i] ^= v[i]
+				}
+			}
+		}
+	}
+	d.removeKey(dst)
+	if length > 0 {
+		d.setString(dst, string(result))
+	}
+	return length, nil
+}
+
+// BitFieldOp is one BITFIELD subcommand: "GET", "SET" or "INCRBY" on the
+// integer of Bits bits at bit Offset, signed or not. Overflow is how SET and
+// INCRBY handle values out of range: "WRAP", "SAT" or "FAIL".
+type BitFieldOp struct {
+	Op       string
+	Signed   bool
+	Bits     int
+	Offset   uint64
+	Value    int64
+	Overflow string
+}
+
+func getField(b []byte, op BitFieldOp) int64 {
+	var v uint64
+	for i := 0; i < op.Bits; i++ {
+		v = v<<1 | uint64(getBit(b, op.Offset+uint64(i)))
+	}
+	if op.Signed && op.Bits < 64 && v>>(op.Bits-1) == 1 {
+		v |= math.MaxUint64 << op.Bits
+	}
+	return int64(v)
+}
+
+func setField(b []byte, op BitFieldOp, value int64) {
+	for i := 0; i < op.Bits; i++ {
+		setBit(b, op.Offset+uint64(i), int(uint64(value)>>(op.Bits-1-i))&1)
+	}
+}
+
+// fieldOverflow computes value + incr for a field of op's type, applying
+// op.Overflow. It reports false if the result overflows with FAIL.
+func fieldOverflow(op BitFieldOp, value int64, incr int64) (int64, bool) {
+	overflows := false
+	var limit int64
+	if op.Signed {
+		maxValue := int64(math.MaxInt64)
+		if op.Bits < 64 {
+			maxValue = 1<<(op.Bits-1) - 1
+		}
+		minValue := -maxValue - 1
+		if value > maxValue || (incr > 0 && value > maxValue-incr) {
+			overflows, limit = true, maxValue
+		} else if value < minValue || (incr < 0 && value < minValue-incr) {
+			overflows, limit = true, minValue
+		}
+	} else {
+		maxValue := uint64(1)<<op.Bits - 1
+		u := uint64(value)
+		if u > maxValue || (incr > 0 && uint64(incr) > maxValue-u) {
+			overflows, limit = true, int64(maxValue)
+		} else if incr < 0 && uint64(-incr) > u {
+			overflows, limit = true, 0
+		}
+	}
+	if !overflows {
+		return value + incr, true
+	}
+	switch op.Overflow {
+	case "SAT":
+		return limit, true
+	case "FAIL":
+		return 0, false
+	}
+	// WRAP keeps the low bits, sign extended for signed types
+	wrapped := uint64(value) + uint64(incr)
+	if op.Bits < 64 {
+		wrapped &= 1<<op.Bits - 1
+		if op.Signed && wrapped>>(op.Bits-1) == 1 {
+			wrapped |= math.MaxUint64 << op.Bits
+		}
+	}
+	return int64(wrapped), true
+}
+
+// BitField runs ops in order and returns one result per op: the value read
+// by GET, the previous value for SET, the new value for INCRBY. ok[i] is
+// false when the op failed with OVERFLOW FAIL.
+func BitField(db int, key string, ops []BitFieldOp) ([]int64, []bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	results := make([]int64, len(ops))
+	ok := make([]bool, len(ops))
+	b, err := d.bitmapForWrite(key, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	written := false
+	for i, op := range ops {
+		if end := int((op.Offset+uint64(op.Bits)-1)>>3) + 1; op.Op != "GET" && end > len(b) {
+			b = append(b, make([]byte, end-len(b))...)
+		}
+		old := getField(b, op)
+		switch op.Op {
+		case "GET":
+			results[i], ok[i] = old, true
+		case "SET":
+			value, fits := fieldOverflow(op, op.Value, 0)
+			if fits {
+				setField(b, op, value)
+				results[i], ok[i] = old, true
+				written = true
+			}
+		case "INCRBY":
+			value, fits := fieldOverflow(op, old, op.Value)
+			if fits {
+				setField(b, op, value)
+				results[i], ok[i] = value, true
+				written = true
+			}
+		}
+	}
+	if written {
+		d.setString(key, string(b))
+	}
+	return results, ok, nil
+}
+
+// BitFieldRO is BitField for GET ops only, under the read lock.
+func BitFieldRO(db int, key string, ops []BitFieldOp) ([]int64, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	v, _, err := d.stringForRead(key)
+	if err != nil {
+		return nil, err
+	}
+	b := []byte(v)
+	results := make([]int64, len(ops))
+	for i, op := range ops {
+		results[i] = getField(b, op)
+	}
+	return results, nil
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"slices"
+	"sync"
+)
+
+// Blocking commands (XREAD BLOCK, BLPOP...) register a Waiter on the keys
+// they wait for. Waiters are kept in arrival order. A write to one of the
+// keys either wakes them so they retry (XREAD), or, for waiters with a
+// serve function (BLPOP), serves them right away under the store lock, the
+// oldest first, so a client that blocked earlier is never overtaken by a
+// later one. Waiters live behind their own mutex because signals are sent
+// while holding the store lock.
+
+// Waiter is a client blocked on a set of keys.
+type Waiter struct {
+	// C receives a value when one of the keys was written or the waiter was
+	// served. It is buffered so a signal sent before the client started
+	// waiting is not lost.
+	C    chan struct{}
+	keys []blockKey
+
+	// serve takes what the client waits for from key, reporting whether
+	// there was anything. It runs with the store write lock held.
+	serve  func(d *DB, key string) bool
+	served bool
+	popped PopResult
+}
+
+type blockKey struct {
+	d   *DB
+	key string
+}
+
+var (
+	blockMu sync.Mutex
+	waiting = make(map[blockKey][]*Waiter)
+)
+
+// Keys signalled while waiters are being served, e.g. the destination of a
+// BLMOVE. They are handled once the current key is done instead of
+// recursively. Both are only used with the store write lock held.
+var (
+	readyKeys  []blockKey
+	signalling bool
+)
+
+// Block registers a waiter on keys of database db. Callers must check for
+// data after calling Block, not before, and call Unblock when done.
+func Block(db int, keys []string) *Waiter {
+	mu.RLock()
+	d := dbs[db]
+	mu.RUnlock()
+	w := &Waiter{C: make(chan struct{}, 1)}
+	w.register(d, keys)
+	return w
+}
+
+func (w *Waiter) register(d *DB, keys []string) {
+	blockMu.Lock()
+	defer blockMu.Unlock()
+	for _, key := range keys {
+		k := blockKey{d, key}
+		w.keys = append(w.keys, k)
+		waiting[k] = append(waiting[k], w)
+	}
+}
+
+// Unblock removes a waiter registered with Block or BPop. It reports
+// whether the waiter was served meanwhile, in which case the result it was
+// served must be used: it has already been taken from the store.
+func Unblock(w *Waiter) bool {
+	blockMu.Lock()
+	defer blockMu.Unlock()
+	w.removeLocked()
+	return w.served
+}
+
+// Popped returns what a BPop waiter was served.
+func (w *Waiter) Popped() PopResult {
+	blockMu.Lock()
+	defer blockMu.Unlock()
+	return w.popped
+}
+
+func (w *Waiter) removeLocked() {
+	for _, k := range w.keys {
+		list := slices.DeleteFunc(waiting[k], func(other *Waiter) bool { return other == w })
+		if len(list) == 0 {
+			delete(waiting, k)
+		} else {
+			waiting[k] = list
+		}
+	}
+	w.keys = nil
+}
+
+func (w *Waiter) wake() {
+	select {
+	case w.C <- struct{}{}:
+	default:
+	}
+}
+
+// signalKey serves or wakes the clients blocked on key, oldest first.
+// Callers must hold the write lock.
+func (d *DB) signalKey(key string) {
+	readyKeys = append(readyKeys, blockKey{d, key})
+	if signalling {
+		return
+	}
+	signalling = true
+	for len(readyKeys) > 0 {
+		k := readyKeys[0]
+		readyKeys = readyKeys[1:]
+		serveKey(k)
+	}
+	readyKeys = nil
+	signalling = false
+}
+
+func serveKey(k blockKey) {
+	blockMu.Lock()
+	defer blockMu.Unlock()
+	for _, w := range slices.Clone(waiting[k]) {
+		if w.serve == nil {
+			w.wake()
+			continue
+		}
+		if w.serve(k.d, k.key) {
+			w.served = true
+			w.removeLocked()
+			w.wake()
+		}
+	}
+}
+
+// signalDB wakes every client blocked on a key of d, used when the whole
+// database is swapped or flushed. Nothing is served: the clients retry on
+// whatever database their index now refers to.
+func signalDB(d *DB) {
+	blockMu.Lock()
+	defer blockMu.Unlock()
+	for k, list := range waiting {
+		if k.d != d {
+			continue
+		}
+		for _, w := range list {
+			w.wake()
+		}
+	}
+}
+
+// BlockedPop describes what a client blocked on lists waits for: up to
+// Count elements from the left or right end of the first non-empty list of
+// Keys. With Move set, the single popped element is pushed to Dst (BLMOVE).
+type BlockedPop struct {
+	Keys    []string
+	Left    bool
+	Count   int
+	Move    bool
+	Dst     string
+	DstLeft bool
+}
+
+// PopResult is what a BlockedPop got: the key popped from and the values.
+type PopResult struct {
+	Key    string
+	Values []string
+}
+
+// BPop pops for p right away if one of its keys holds a non-empty list.
+// Otherwise it returns a waiter registered on the keys, served in FIFO
+// order by the pushes that follow. The check and the registration are
+// atomic, so no push can be missed in between.
+func BPop(db int, p BlockedPop) (PopResult, bool, *Waiter, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	for _, key := range p.Keys {
+		result, ok, err := d.popFor(&p, key)
+		if err != nil || ok {
+			return result, ok, nil, err
+		}
+	}
+	w := &Waiter{C: make(chan struct{}, 1)}
+	w.serve = func(d *DB, key string) bool {
+		// a key that now holds another type leaves the client blocked
+		result, ok, _ := d.popFor(&p, key)
+		w.popped = result
+		return ok
+	}
+	w.register(d, p.Keys)
+	return PopResult{}, false, w, nil
+}
+
+// popFor performs p on key if it holds a non-empty list. It returns
+// ErrWrongType, without popping, if key or the destination of a move holds
+// another type. Callers must hold the write lock.
+func (d *DB) popFor(p *BlockedPop, key string) (PopResult, bool, error) {
+	if data, err := d.listForWrite(key); data == nil {
+		return PopResult{}, false, err
+	}
+	if p.Move {
+		if _, err := d.listForWrite(p.Dst); err != nil {
+			return PopResult{}, false, err
+		}
+	}
+	values, _ := d.listPop(key, p.Left, p.Count)
+	if len(values) == 0 {
+		return PopResult{}, false, nil
+	}
+	if p.Move {
+		d.listPush(p.Dst, p.DstLeft, values)
+	}
+	return PopResult{Key: key, Values: values}, true, nil
+}
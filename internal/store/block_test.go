@@ -0,0 +1,68 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+var popLeft = BlockedPop{Keys: []string{"l"}, Left: true, Count: 1}
+
+func TestBPopAfterListDeleted(t *testing.T) {
+	Init(1)
+	RPush(0, "l", "old")
+	if !Delete(0, "l") {
+		t.Fatal("DEL did not remove the list")
+	}
+	_, ok, w, _ := BPop(0, popLeft)
+	if ok {
+		t.Fatal("popped from a deleted list")
+	}
+	RPush(0, "l", "new")
+	if !Unblock(w) {
+		t.Fatal("waiter not served by the push")
+	}
+	if got := w.Popped(); got.Key != "l" || !slices.Equal(got.Values, []string{"new"}) {
+		t.Fatalf("served %v, want [new] from l", got)
+	}
+}
+
+func TestBPopAfterListExpired(t *testing.T) {
+	Init(1)
+	RPush(0, "l", "old")
+	SetExpire(0, "l", time.Now().Add(-time.Second))
+	_, ok, w, _ := BPop(0, popLeft)
+	if ok {
+		t.Fatal("popped from an expired list")
+	}
+	RPush(0, "l", "new")
+	if !Unblock(w) {
+		t.Fatal("waiter not served by the push")
+	}
+	if got := w.Popped(); !slices.Equal(got.Values, []string{"new"}) {
+		t.Fatalf("served %v, want [new]", got.Values)
+	}
+	if n, _ := LLen(0, "l"); n != 0 {
+		t.Fatalf("LLEN = %d after the pop, want 0", n)
+	}
+}
+
+func TestBPopWrongType(t *testing.T) {
+	Init(1)
+	Set(0, "l", "v")
+	if _, _, w, err := BPop(0, popLeft); !errors.Is(err, ErrWrongType) || w != nil {
+		t.Fatalf("BPop on a string = %v, want ErrWrongType without waiting", err)
+	}
+	Delete(0, "l")
+	_, _, w, _ := BPop(0, popLeft)
+	Set(0, "l", "v")
+	Delete(0, "l")
+	RPush(0, "l", "new")
+	if !Unblock(w) {
+		t.Fatal("waiter not served once l holds a list again")
+	}
+	if got := w.Popped(); !slices.Equal(got.Values, []string{"new"}) {
+		t.Fatalf("served %v, want [new]", got.Values)
+	}
+}
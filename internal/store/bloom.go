@@ -0,0 +1,283 @@
+package store
+
+import (
+	"errors"
+	"math"
+)
+
+// Bloom filters are scalable, as in RedisBloom: a stack of fixed size
+// filters, each one created when the previous one holds as many items as it
+// was sized for, with Expansion times its capacity and half its error rate,
+// so the overall error rate stays below twice the requested one. Positions
+// come from double hashing two MurmurHash64A values of the item.
+const (
+	bloomDefaultError     = 0.01
+	bloomDefaultCapacity  = 100
+	bloomDefaultExpansion = 2
+	bloomTightening       = 0.5
+	bloomSeed             = 0xc6a4a7935bd1e995
+	bloomOverhead         = 64 // per layer
+	bloomMaxBits          = MaxBitOffset + 1
+)
+
+var (
+	ErrBloomExists   = errors.New("item exists")
+	ErrBloomFull     = errors.New("non scaling filter is full")
+	ErrBloomTooLarge = errors.New("filter would exceed the maximum size")
+)
+
+type bloomLayer struct {
+	bits     []byte
+	nbits    uint64
+	hashes   int
+	capacity int
+	count    int
+	errRate  float64
+}
+
+type bloomFilter struct {
+	layers     []*bloomLayer
+	expansion  int
+	nonScaling bool
+}
+
+// BloomOptions are the parameters of BF.RESERVE.
+type BloomOptions struct {
+	ErrorRate  float64
+	Capacity   int
+	Expansion  int
+	NonScaling bool
+}
+
+func newBloomLayer(capacity int, errRate float64) (*bloomLayer, error) {
+	bitsPerItem := -math.Log(errRate) / (math.Ln2 * math.Ln2)
+	nbits := math.Ceil(float64(capacity) * bitsPerItem)
+	if nbits > bloomMaxBits {
+		return nil, ErrBloomTooLarge
+	}
+	n := max(uint64(nbits), 1)
+	return &bloomLayer{
+		bits:     make([]byte, (n+7)/8),
+		nbits:    n,
+		hashes:   max(int(math.Ceil(math.Ln2*bitsPerItem)), 1),
+		capacity: capacity,
+		errRate:  errRate,
+	}, nil
+}
+
+func newBloomFilter(opts BloomOptions) (*bloomFilter, error) {
+	layer, err := newBloomLayer(opts.Capacity, opts.ErrorRate)
+	if err != nil {
+		return nil, err
+	}
+	return &bloomFilter{layers: []*bloomLayer{layer}, expansion: opts.Expansion, nonScaling: opts.NonScaling}, nil
+}
+
+func bloomHashes(item string) (uint64, uint64) {
+	a := murmurHash64A(item, bloomSeed)
+	return a, murmurHash64A(item, a)
+}
+
+func (l *bloomLayer) has(a uint64, b uint64) bool {
+	for i := 0; i < l.hashes; i++ {
+		pos := (a + uint64(i)*b) % l.nbits
+		if l.bits[pos>>3]&(1<<(pos&7)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *bloomLayer) add(a uint64, b uint64) {
+	for i := 0; i < l.hashes; i++ {
+		pos := (a + uint64(i)*b) % l.nbits
+		l.bits[pos>>3] |= 1 << (pos & 7)
+	}
+	l.count++
+}
+
+func (f *bloomFilter) has(item string) bool {
+	a, b := bloomHashes(item)
+	for _, l := range f.layers {
+		if l.has(a, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds item unless it may already be present, growing the filter if
+// the last layer is full. It reports whether item was added.
+func (f *bloomFilter) add(item string) (bool, error) {
+	a, b := bloomHashes(item)
+	for _, l := range f.layers {
+		if l.has(a, b) {
+			return false, nil
+		}
+	}
+	last := f.layers[len(f.layers)-1]
+	if last.count >= last.capacity {
+		if f.nonScaling {
+			return false, ErrBloomFull
+		}
+		layer, err := newBloomLayer(last.capacity*f.expansion, last.errRate*bloomTightening)
+		if err != nil {
+			return false, err
+		}
+		f.layers = append(f.layers, layer)
+		last = layer
+	}
+	last.add(a, b)
+	return true, nil
+}
+
+func (f *bloomFilter) clone() *bloomFilter {
+	c := &bloomFilter{expansion: f.expansion, nonScaling: f.nonScaling}
+	for _, l := range f.layers {
+		layer := *l
+		layer.bits = append([]byte(nil), l.bits...)
+		c.layers = append(c.layers, &layer)
+	}
+	return c
+}
+
+func bloomSize(f *bloomFilter) int {
+	size := 0
+	for _, l := range f.layers {
+		size += bloomOverhead + len(l.bits)
+	}
+	return size
+}
+
+// bloomForRead returns the filter at key if it exists and is live, or
+// ErrWrongType if the key holds another type.
+func (d *DB) bloomForRead(key string) (*bloomFilter, bool, error) {
+	if ok, err := d.lookupKey(key, "MBbloom--"); !ok {
+		return nil, false, err
+	}
+	return d.Bloom_data[key], true, nil
+}
+
+// bloomForWrite is bloomForRead for commands that modify the filter: an
+// expired key is deleted first so its TTL does not carry over.
+func (d *DB) bloomForWrite(key string) (*bloomFilter, bool, error) {
+	if ok, err := d.lookupKeyWrite(key, "MBbloom--"); !ok {
+		return nil, false, err
+	}
+	return d.Bloom_data[key], true, nil
+}
+
+// BFReserve creates an empty filter at key, which must not exist.
+func BFReserve(db int, key string, opts BloomOptions) error {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	if _, ok, err := d.bloomForWrite(key); ok || err != nil {
+		if err == nil || errors.Is(err, ErrWrongType) {
+			err = ErrBloomExists
+		}
+		return err
+	}
+	f, err := newBloomFilter(opts)
+	if err != nil {
+		return err
+	}
+	d.Bloom_data[key] = f
+	grow(d.writeKey(key), bloomSize(f))
+	return nil
+}
+
+// BFAdd adds items to the filter at key, creating it with the default
+// parameters if needed, and reports for each whether it was added, i.e.
+// was certainly not present. If a non scaling filter fills up, the items
+// processed so far are returned with the error.
+func BFAdd(db int, key string, items []string) ([]bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	f, ok, err := d.bloomForWrite(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		f, _ = newBloomFilter(BloomOptions{ErrorRate: bloomDefaultError, Capacity: bloomDefaultCapacity, Expansion: bloomDefaultExpansion})
+		d.Bloom_data[key] = f
+		grow(d.writeKey(key), bloomSize(f))
+	}
+	before := bloomSize(f)
+	added := make([]bool, 0, len(items))
+	for _, item := range items {
+		var ok bool
+		if ok, err = f.add(item); err != nil {
+			break
+		}
+		added = append(added, ok)
+	}
+	grow(d.writeKey(key), bloomSize(f)-before)
+	return added, err
+}
+
+// BFExists reports for each item whether it may have been added to the
+// filter at key.
+func BFExists(db int, key string, items []string) ([]bool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	f, ok, err := d.bloomForRead(key)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]bool, len(items))
+	for i, item := range items {
+		found[i] = ok && f.has(item)
+	}
+	return found, nil
+}
+
+// BloomDump is the snapshot of a filter; bits are encoded as base64.
+type BloomDump struct {
+	Expansion  int              `json:"expansion"`
+	NonScaling bool             `json:"non_scaling,omitempty"`
+	Layers     []BloomLayerDump `json:"layers"`
+}
+
+type BloomLayerDump struct {
+	Bits      []byte  `json:"bits"`
+	NBits     uint64  `json:"nbits"`
+	Hashes    int     `json:"hashes"`
+	Capacity  int     `json:"capacity"`
+	Count     int     `json:"count"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+// bloomSnapshot returns a dump of every filter in d.
+func (d *DB) bloomSnapshot() map[string]BloomDump {
+	response := make(map[string]BloomDump, len(d.Bloom_data))
+	for key, f := range d.Bloom_data {
+		dump := BloomDump{Expansion: f.expansion, NonScaling: f.nonScaling}
+		for _, l := range f.layers {
+			dump.Layers = append(dump.Layers, BloomLayerDump{append([]byte(nil), l.bits...), l.nbits, l.hashes, l.capacity, l.count, l.errRate})
+		}
+		response[key] = dump
+	}
+	return response
+}
+
+// LoadBloom restores a filter from its dump. Memory accounting is left to
+// RebuildMeta.
+func LoadBloom(db int, key string, dump BloomDump) error {
+	if len(dump.Layers) == 0 {
+		return errors.New("filter has no layers")
+	}
+	f := &bloomFilter{expansion: dump.Expansion, nonScaling: dump.NonScaling}
+	for _, l := range dump.Layers {
+		if l.NBits == 0 || uint64(len(l.Bits)) != (l.NBits+7)/8 {
+			return errors.New("filter bits do not match their size")
+		}
+		f.layers = append(f.layers, &bloomLayer{l.Bits, l.NBits, l.Hashes, l.Capacity, l.Count, l.ErrorRate})
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	dbs[db].Bloom_data[key] = f
+	return nil
+}
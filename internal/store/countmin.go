@@ -0,0 +1,226 @@
+package store
+
+import (
+	"errors"
+	"math"
+)
+
+// A Count-Min Sketch is a Depth x Width matrix of counters. Incrementing an
+// item adds to one counter per row, chosen by a hash of the item seeded
+// with the row number; its count is the smallest of those counters, which
+// can only overestimate it.
+const cmsOverhead = 64
+
+var (
+	ErrCMSExists     = errors.New("CMS: key already exists")
+	ErrCMSNoKey      = errors.New("CMS: key does not exist")
+	ErrCMSDimensions = errors.New("CMS: width/depth is not equal")
+	ErrCMSOverflow   = errors.New("CMS: INCRBY overflow")
+)
+
+type countMinSketch struct {
+	width, depth int
+	count        uint64
+	counters     []uint32 // row major
+}
+
+func (s *countMinSketch) positions(item string) []int {
+	pos := make([]int, s.depth)
+	for i := range pos {
+		pos[i] = i*s.width + int(murmurHash64A(item, uint64(i))%uint64(s.width))
+	}
+	return pos
+}
+
+func (s *countMinSketch) query(item string) uint32 {
+	response := uint32(math.MaxUint32)
+	for _, p := range s.positions(item) {
+		response = min(response, s.counters[p])
+	}
+	return response
+}
+
+func (s *countMinSketch) clone() *countMinSketch {
+	c := *s
+	c.counters = append([]uint32(nil), s.counters...)
+	return &c
+}
+
+func cmsSize(s *countMinSketch) int {
+	return cmsOverhead + 4*len(s.counters)
+}
+
+// cmsForRead returns the sketch at key if it exists and is live, or
+// ErrWrongType if the key holds another type.
+func (d *DB) cmsForRead(key string) (*countMinSketch, bool, error) {
+	if ok, err := d.lookupKey(key, "CMSk-TYPE"); !ok {
+		return nil, false, err
+	}
+	return d.CMS_data[key], true, nil
+}
+
+// cmsForWrite is cmsForRead for commands that modify the sketch: an expired
+// key is deleted first so its TTL does not carry over.
+func (d *DB) cmsForWrite(key string) (*countMinSketch, bool, error) {
+	if ok, err := d.lookupKeyWrite(key, "CMSk-TYPE"); !ok {
+		return nil, false, err
+	}
+	return d.CMS_data[key], true, nil
+}
+
+// CMSInitByDim creates a sketch of the given dimensions at key, which must
+// not exist.
+func CMSInitByDim(db int, key string, width int, depth int) error {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	if _, ok, err := d.cmsForWrite(key); ok || err != nil {
+		return ErrCMSExists
+	}
+	s := &countMinSketch{width: width, depth: depth, counters: make([]uint32, width*depth)}
+	d.CMS_data[key] = s
+	grow(d.writeKey(key), cmsSize(s))
+	return nil
+}
+
+// CMSItem is an item and an increment for CMSIncrBy.
+type CMSItem struct {
+	Item string
+	Incr uint32
+}
+
+// CMSIncrBy increments items in the sketch at key and returns their new
+// counts. Nothing is changed if a counter would overflow.
+func CMSIncrBy(db int, key string, items []CMSItem) ([]uint32, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	s, ok, err := d.cmsForWrite(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrCMSNoKey
+	}
+	// check every counter first so a failed INCRBY leaves the sketch alone
+	incrs := make(map[int]uint64)
+	for _, item := range items {
+		for _, p := range s.positions(item.Item) {
+			incrs[p] += uint64(item.Incr)
+			if uint64(s.counters[p])+incrs[p] > math.MaxUint32 {
+				return nil, ErrCMSOverflow
+			}
+		}
+	}
+	counts := make([]uint32, len(items))
+	for i, item := range items {
+		for _, p := range s.positions(item.Item) {
+			s.counters[p] += item.Incr
+		}
+		s.count += uint64(item.Incr)
+		counts[i] = s.query(item.Item)
+	}
+	d.writeKey(key)
+	return counts, nil
+}
+
+// CMSQuery returns the estimated counts of items in the sketch at key.
+func CMSQuery(db int, key string, items []string) ([]uint32, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	s, ok, err := d.cmsForRead(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrCMSNoKey
+	}
+	counts := make([]uint32, len(items))
+	for i, item := range items {
+		counts[i] = s.query(item)
+	}
+	return counts, nil
+}
+
+// CMSMerge replaces the counters of the sketch at dst with the weighted sum
+// of those at keys, which must all exist and have the same dimensions.
+// Weights must be within the int32 range so each term fits in an int64.
+func CMSMerge(db int, dst string, keys []string, weights []int64) error {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	target, ok, err := d.cmsForWrite(dst)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCMSNoKey
+	}
+	sources := make([]*countMinSketch, len(keys))
+	for i, key := range keys {
+		s, ok, err := d.cmsForRead(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrCMSNoKey
+		}
+		if s.width != target.width || s.depth != target.depth {
+			return ErrCMSDimensions
+		}
+		sources[i] = s
+	}
+	counters := make([]uint32, len(target.counters))
+	count := int64(0)
+	for p := range counters {
+		sum := int64(0)
+		for i, s := range sources {
+			term := int64(s.counters[p]) * weights[i]
+			if (term > 0 && sum > math.MaxInt64-term) || (term < 0 && sum < math.MinInt64-term) {
+				return ErrCMSOverflow
+			}
+			sum += term
+		}
+		if sum < 0 || sum > math.MaxUint32 {
+			return ErrCMSOverflow
+		}
+		counters[p] = uint32(sum)
+	}
+	for i, s := range sources {
+		count += int64(s.count) * weights[i]
+	}
+	target.counters = counters
+	target.count = uint64(max(count, 0))
+	d.writeKey(dst)
+	return nil
+}
+
+// CMSDump is the snapshot of a sketch.
+type CMSDump struct {
+	Width    int      `json:"width"`
+	Depth    int      `json:"depth"`
+	Count    uint64   `json:"count"`
+	Counters []uint32 `json:"counters"`
+}
+
+// cmsSnapshot returns a dump of every sketch in d.
+func (d *DB) cmsSnapshot() map[string]CMSDump {
+	response := make(map[string]CMSDump, len(d.CMS_data))
+	for key, s := range d.CMS_data {
+		response[key] = CMSDump{s.width, s.depth, s.count, append([]uint32(nil), s.counters...)}
+	}
+	return response
+}
+
+// LoadCMS restores a sketch from its dump. Memory accounting is left to
+// RebuildMeta.
+func LoadCMS(db int, key string, dump CMSDump) error {
+	if dump.Width <= 0 || dump.Depth <= 0 || len(dump.Counters) != dump.Width*dump.Depth {
+		return errors.New("sketch counters do not match its dimensions")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	dbs[db].CMS_data[key] = &countMinSketch{dump.Width, dump.Depth, dump.Count, dump.Counters}
+	return nil
+}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"hash/maphash"
+	"iter"
+	"math/bits"
+	"math/rand/v2"
+)
+
+// dict is a chained hash table with a power of two number of buckets. Unlike
+// a Go map it can be walked with a cursor (see scan) that stays valid while
+// the table grows or shrinks between calls, which is what SCAN needs.
+type dict[V any] struct {
+	buckets [][]dictEntry[V]
+	count   int
+	seed    maphash.Seed
+}
+
+type dictEntry[V any] struct {
+	key   string
+	value V
+}
+
+const dictMinSize = 4
+
+func newDict[V any]() *dict[V] {
+	return &dict[V]{
+		buckets: make([][]dictEntry[V], dictMinSize),
+		seed:    maphash.MakeSeed(),
+	}
+}
+
+func (d *dict[V]) index(key string) int {
+	return int(maphash.String(d.seed, key) & uint64(len(d.buckets)-1))
+}
+
+func (d *dict[V]) len() int {
+	return d.count
+}
+
+func (d *dict[V]) get(key string) (V, bool) {
+	for _, e := range d.buckets[d.index(key)] {
+		if e.key == key {
+			return e.value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// set stores value under key and reports whether the key is new.
+func (d *dict[V]) set(key string, value V) bool {
+	i := d.index(key)
+	for j := range d.buckets[i] {
+		if d.buckets[i][j].key == key {
+			d.buckets[i][j].value = value
+			return false
+		}
+	}
+	d.buckets[i] = append(d.buckets[i], dictEntry[V]{key: key, value: value})
+	d.count++
+	if d.count > len(d.buckets) {
+		d.resize(len(d.buckets) * 2)
+	}
+	return true
+}
+
+// delete removes key and reports whether it was present.
+func (d *dict[V]) delete(key string) bool {
+	i := d.index(key)
+	bucket := d.buckets[i]
+	for j := range bucket {
+		if bucket[j].key == key {
+			last := len(bucket) - 1
+			bucket[j] = bucket[last]
+			bucket[last] = dictEntry[V]{}
+			d.buckets[i] = bucket[:last]
+			d.count--
+			if len(d.buckets) > dictMinSize && d.count*8 < len(d.buckets) {
+				d.resize(len(d.buckets) / 2)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dict[V]) resize(size int) {
+	old := d.buckets
+	d.buckets = make([][]dictEntry[V], size)
+	for _, bucket := range old {
+		for _, e := range bucket {
+			i := d.index(e.key)
+			d.buckets[i] = append(d.buckets[i], e)
+		}
+	}
+}
+
+// clone returns a copy of d with the same layout.
+func (d *dict[V]) clone() *dict[V] {
+	c := &dict[V]{
+		buckets: make([][]dictEntry[V], len(d.buckets)),
+		count:   d.count,
+		seed:    d.seed,
+	}
+	for i, bucket := range d.buckets {
+		c.buckets[i] = append([]dictEntry[V](nil), bucket...)
+	}
+	return c
+}
+
+// all iterates over every entry in bucket order.
+func (d *dict[V]) all() iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		for _, bucket := range d.buckets {
+			for _, e := range bucket {
+				if !yield(e.key, e.value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// scan visits one bucket and returns the cursor of the next one, 0 when the
+// walk is over. Cursors are incremented from their most significant bit
+// (reverse binary order, as in Redis' dictScan), so every entry present for
+// the whole walk is visited at least once even if the table is resized
+// between calls. Entries may be visited more than once.
+func (d *dict[V]) scan(cursor uint64, fn func(key string, value V)) uint64 {
+	mask := uint64(len(d.buckets) - 1)
+	for _, e := range d.buckets[cursor&mask] {
+		fn(e.key, e.value)
+	}
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// sample returns up to n entries read from consecutive buckets starting at a
+// random one. It is cheap but not uniform, which is fine for eviction and
+// expiry sampling.
+func (d *dict[V]) sample(n int) []dictEntry[V] {
+	response := make([]dictEntry[V], 0, n)
+	if d.count == 0 {
+		return response
+	}
+	start := rand.IntN(len(d.buckets))
+	for i := 0; i < len(d.buckets) && len(response) < n; i++ {
+		for _, e := range d.buckets[(start+i)&(len(d.buckets)-1)] {
+			if len(response) >= n {
+				break
+			}
+			response = append(response, e)
+		}
+	}
+	return response
+}
+
+// random returns a random entry: a random non-empty bucket, then a random
+// entry inside it.
+func (d *dict[V]) random() (dictEntry[V], bool) {
+	if d.count == 0 {
+		return dictEntry[V]{}, false
+	}
+	for {
+		bucket := d.buckets[rand.IntN(len(d.buckets))]
+		if len(bucket) > 0 {
+			return bucket[rand.IntN(len(bucket))], true
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"math"
+	"strings"
+	"sync/atomic"
+
+	"litekv/internal/config"
+)
+
+var evictedKeys atomic.Int64
+
+// EvictedKeys returns how many keys were removed to honour maxmemory.
+func EvictedKeys() int64 {
+	return evictedKeys.Load()
+}
+
+// FreeMemoryIfNeeded evicts keys according to maxmemory-policy until the used
+// memory is back under maxmemory. It returns false when the limit is still
+// exceeded, either because the policy is noeviction or because no key
+// qualifies for eviction.
+func FreeMemoryIfNeeded() bool {
+	limit := config.MaxMemory()
+	if limit <= 0 || usedMemory.Load() <= limit {
+		return true
+	}
+	policy := config.MaxMemoryPolicy()
+	if policy == "noeviction" {
+		return false
+	}
+	samples := config.MaxMemorySamples()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for usedMemory.Load() > limit {
+		d, key, ok := evictionCandidate(policy, samples)
+		if !ok {
+			return false
+		}
+		d.removeKey(key)
+		evictedKeys.Add(1)
+	}
+	return true
+}
+
+// evictionCandidate samples a few keys of every database and returns the
+// best one to evict for the given policy, like Redis' approximated LRU/LFU.
+// volatile-* policies only consider keys with a TTL. Callers must hold the
+// write lock.
+func evictionCandidate(policy string, samples int) (*DB, string, bool) {
+	volatile := strings.HasPrefix(policy, "volatile-")
+	var bestDB *DB
+	best := ""
+	bestScore := int64(-1)
+	for _, d := range dbs {
+		var keys []string
+		if volatile {
+			keys = sampleKeys(d.Expiry, samples)
+		} else {
+			for _, e := range d.meta.sample(samples) {
+				keys = append(keys, e.key)
+			}
+		}
+
+		for _, key := range keys {
+			m, ok := d.meta.get(key)
+			if !ok {
+				continue
+			}
+			var score int64
+			switch {
+			case strings.HasSuffix(policy, "-lru"):
+				score = int64(m.idle())
+			case strings.HasSuffix(policy, "-lfu"):
+				score = 255 - int64(m.frequency())
+			case policy == "volatile-ttl":
+				// the sooner a key expires the better it is to evict
+				score = math.MaxInt64 - d.Expiry[key].UnixNano()
+			default:
+				score = 0
+			}
+			if score > bestScore {
+				bestDB, best, bestScore = d, key, score
+			}
+		}
+	}
+	return bestDB, best, bestScore >= 0
+}
+
+// sampleKeys returns up to n keys of m starting at a random position.
+func sampleKeys[V any](m map[string]V, n int) []string {
+	keys := make([]string, 0, n)
+	for key := range m {
+		if len(keys) >= n {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
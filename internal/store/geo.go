@@ -0,0 +1,331 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Geo indexes are sorted sets whose scores are 52-bit geohashes, as in
+// Redis: 26 bits of longitude interleaved with 26 bits of latitude, the
+// latitude being limited to the range Web Mercator can represent. Members
+// close to each other have close scores, so a search only scans the score
+// ranges of the geohash cell holding the center and of its eight neighbours,
+// at a precision where those nine cells cover the whole search area, and
+// then filters the candidates by their exact distance.
+const (
+	geoStepMax    = 26 // bits per coordinate
+	geoLatMin     = -85.05112878
+	geoLatMax     = 85.05112878
+	geoLonMin     = -180.0
+	geoLonMax     = 180.0
+	earthRadius   = 6372797.560856 // meters, the value Redis uses
+	geoHashLength = 11
+	geoAlphabet   = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+var ErrGeoMember = errors.New("could not decode requested zset member")
+
+// GeoPoint is a longitude/latitude pair in degrees.
+type GeoPoint struct {
+	Lon, Lat float64
+}
+
+// ValidGeoPoint reports whether p can be indexed.
+func ValidGeoPoint(p GeoPoint) bool {
+	return p.Lon >= geoLonMin && p.Lon <= geoLonMax && p.Lat >= geoLatMin && p.Lat <= geoLatMax
+}
+
+// interleave spreads the bits of lat over the even bits of the result and
+// those of lon over the odd ones.
+func interleave(lat uint32, lon uint32) uint64 {
+	var bits uint64
+	for i := 0; i < 32; i++ {
+		bits |= uint64(lat>>i&1)<<(2*i) | uint64(lon>>i&1)<<(2*i+1)
+	}
+	return bits
+}
+
+func deinterleave(bits uint64) (uint32, uint32) {
+	var lat, lon uint32
+	for i := 0; i < 32; i++ {
+		lat |= uint32(bits>>(2*i)&1) << i
+		lon |= uint32(bits>>(2*i+1)&1) << i
+	}
+	return lat, lon
+}
+
+// geohashEncode returns the geohash of p with step bits per coordinate,
+// within the given latitude range.
+func geohashEncode(p GeoPoint, step int, latMin float64, latMax float64) uint64 {
+	latOffset := (p.Lat - latMin) / (latMax - latMin) * float64(uint64(1)<<step)
+	lonOffset := (p.Lon - geoLonMin) / (geoLonMax - geoLonMin) * float64(uint64(1)<<step)
+	return interleave(uint32(latOffset), uint32(lonOffset))
+}
+
+// geohashCell returns the area covered by a geohash as its minimum and
+// maximum corners.
+func geohashCell(hash uint64, step int) (GeoPoint, GeoPoint) {
+	lat, lon := deinterleave(hash)
+	cells := float64(uint64(1) << step)
+	latScale := geoLatMax - geoLatMin
+	lonScale := geoLonMax - geoLonMin
+	lo := GeoPoint{
+		Lon: geoLonMin + float64(lon)/cells*lonScale,
+		Lat: geoLatMin + float64(lat)/cells*latScale,
+	}
+	hi := GeoPoint{
+		Lon: geoLonMin + (float64(lon)+1)/cells*lonScale,
+		Lat: geoLatMin + (float64(lat)+1)/cells*latScale,
+	}
+	return lo, hi
+}
+
+// GeoScore returns the sorted set score of p.
+func GeoScore(p GeoPoint) float64 {
+	return float64(geohashEncode(p, geoStepMax, geoLatMin, geoLatMax))
+}
+
+// geoDecode returns the point a score stands for, the center of its cell.
+func geoDecode(score float64) GeoPoint {
+	lo, hi := geohashCell(uint64(score), geoStepMax)
+	return GeoPoint{
+		Lon: min(max((lo.Lon+hi.Lon)/2, geoLonMin), geoLonMax),
+		Lat: min(max((lo.Lat+hi.Lat)/2, geoLatMin), geoLatMax),
+	}
+}
+
+func degRad(deg float64) float64 { return deg * math.Pi / 180 }
+func radDeg(rad float64) float64 { return rad / (math.Pi / 180) }
+
+// geoDistance is the haversine distance between a and b in meters.
+func geoDistance(a GeoPoint, b GeoPoint) float64 {
+	lat1, lat2 := degRad(a.Lat), degRad(b.Lat)
+	u := math.Sin((lat2 - lat1) / 2)
+	v := math.Sin((degRad(b.Lon) - degRad(a.Lon)) / 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1)*math.Cos(lat2)*v*v))
+}
+
+// GeoAdd adds members at the given points, see ZAdd. Points must be valid.
+func GeoAdd(db int, key string, opts ZAddOptions, points []GeoPoint, members []string) (int, error) {
+	items := make([]ZMember, len(points))
+	for i, p := range points {
+		items[i] = ZMember{Member: members[i], Score: GeoScore(p)}
+	}
+	return ZAdd(db, key, opts, items)
+}
+
+// GeoPos returns the position of members, with found[i] false for missing
+// ones.
+func GeoPos(db int, key string, members []string) ([]GeoPoint, []bool, error) {
+	scores, found, err := ZMScore(db, key, members)
+	if err != nil {
+		return nil, nil, err
+	}
+	points := make([]GeoPoint, len(members))
+	for i := range members {
+		if found[i] {
+			points[i] = geoDecode(scores[i])
+		}
+	}
+	return points, found, nil
+}
+
+// GeoDist returns the distance in meters between two members.
+func GeoDist(db int, key string, a string, b string) (float64, bool, error) {
+	points, found, err := GeoPos(db, key, []string{a, b})
+	if err != nil || !found[0] || !found[1] {
+		return 0, false, err
+	}
+	return geoDistance(points[0], points[1]), true, nil
+}
+
+// GeoHash returns the standard base32 geohash of members. The index uses a
+// narrower latitude range, so positions are decoded and encoded again with
+// the standard one; the 52 bits give ten characters and the eleventh is
+// always '0', as in Redis.
+func GeoHash(db int, key string, members []string) ([]string, []bool, error) {
+	points, found, err := GeoPos(db, key, members)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(members))
+	for i, p := range points {
+		if !found[i] {
+			continue
+		}
+		bits := geohashEncode(p, geoStepMax, -90, 90)
+		hash := make([]byte, geoHashLength)
+		for j := range hash {
+			index := 0
+			if j < geoHashLength-1 {
+				index = int(bits>>(geoStepMax*2-(j+1)*5)) & 0x1f
+			}
+			hash[j] = geoAlphabet[index]
+		}
+		hashes[i] = string(hash)
+	}
+	return hashes, found, nil
+}
+
+// GeoQuery is a GEOSEARCH: members within Radius meters of the center, or
+// within a Width x Height meters box centered on it when Box is set. With
+// FromMember the center is the position of Member. Sort is "ASC",
+// "DESC" or "" for no order; Count limits the results (0 for no limit) and
+// Any stops at the first Count matches instead of returning the nearest.
+type GeoQuery struct {
+	FromMember    bool
+	Member        string
+	Center        GeoPoint
+	Radius        float64
+	Box           bool
+	Width, Height float64
+	Sort          string
+	Count         int
+	Any           bool
+}
+
+// GeoResult is a member found by GeoSearch with its distance to the center
+// in meters.
+type GeoResult struct {
+	Member string
+	Dist   float64
+	Score  float64
+	Point  GeoPoint
+}
+
+// contains reports whether p is inside the search area and its distance to
+// the center, checking a box the way Redis does: latitude distance first,
+// then longitude distance along p's parallel.
+func (q GeoQuery) contains(p GeoPoint) (float64, bool) {
+	if !q.Box {
+		dist := geoDistance(q.Center, p)
+		return dist, dist <= q.Radius
+	}
+	if earthRadius*math.Abs(degRad(p.Lat)-degRad(q.Center.Lat)) > q.Height/2 {
+		return 0, false
+	}
+	if geoDistance(GeoPoint{Lon: q.Center.Lon, Lat: p.Lat}, p) > q.Width/2 {
+		return 0, false
+	}
+	return geoDistance(q.Center, p), true
+}
+
+// cells returns the geohashes, at the returned step, of the cell holding
+// the center and its neighbours. The step is the finest one whose cells are
+// at least as large as the half extent of the search area, so the nine
+// cells cover all of it.
+func (q GeoQuery) cells() ([]uint64, int) {
+	halfWidth, halfHeight := q.Radius, q.Radius
+	if q.Box {
+		halfWidth, halfHeight = q.Width/2, q.Height/2
+	}
+	latDelta := radDeg(halfHeight / earthRadius)
+	lonDelta := geoLonMax - geoLonMin
+	if farthest := math.Abs(q.Center.Lat) + latDelta; farthest < 90 {
+		lonDelta = radDeg(halfWidth / earthRadius / math.Cos(degRad(farthest)))
+	}
+	step := geoStepMax
+	for step > 1 {
+		cells := float64(uint64(1) << step)
+		if latDelta <= (geoLatMax-geoLatMin)/cells && lonDelta <= (geoLonMax-geoLonMin)/cells {
+			break
+		}
+		step--
+	}
+	center := geohashEncode(q.Center, step, geoLatMin, geoLatMax)
+	lat, lon := deinterleave(center)
+	mask := uint32(1)<<step - 1
+	lat = min(lat, mask) // the northern limit itself falls one cell past the last
+	seen := make(map[uint64]bool, 9)
+	response := make([]uint64, 0, 9)
+	for _, dy := range []uint32{0, 1, mask} {
+		for _, dx := range []uint32{0, 1, mask} {
+			hash := interleave((lat+dy)&mask, (lon+dx)&mask)
+			if !seen[hash] {
+				seen[hash] = true
+				response = append(response, hash)
+			}
+		}
+	}
+	return response, step
+}
+
+// search runs q on z.
+func (q GeoQuery) search(z *zset) []GeoResult {
+	response := make([]GeoResult, 0)
+	cells, step := q.cells()
+	shift := uint(2 * (geoStepMax - step))
+	for _, cell := range cells {
+		r := ScoreRange{Min: float64(cell << shift), Max: float64((cell + 1) << shift), MaxEx: true}
+		for _, item := range z.byRange(r.aboveMin, r.belowMax, false, 0, -1) {
+			p := geoDecode(item.Score)
+			if dist, ok := q.contains(p); ok {
+				response = append(response, GeoResult{Member: item.Member, Dist: dist, Score: item.Score, Point: p})
+				if q.Any && len(response) == q.Count {
+					break
+				}
+			}
+		}
+		if q.Any && len(response) == q.Count {
+			break
+		}
+	}
+	switch q.Sort {
+	case "ASC":
+		sort.SliceStable(response, func(i, j int) bool { return response[i].Dist < response[j].Dist })
+	case "DESC":
+		sort.SliceStable(response, func(i, j int) bool { return response[i].Dist > response[j].Dist })
+	}
+	if q.Count > 0 && len(response) > q.Count {
+		response = response[:q.Count]
+	}
+	return response
+}
+
+// geoSearch resolves the center of q and runs it on the sorted set at key.
+// Callers must hold the lock.
+func (d *DB) geoSearch(key string, q GeoQuery) ([]GeoResult, error) {
+	z, ok, err := d.zsetForRead(key)
+	if !ok {
+		return make([]GeoResult, 0), err
+	}
+	if q.FromMember {
+		score, ok := z.dict.get(q.Member)
+		if !ok {
+			return nil, ErrGeoMember
+		}
+		q.Center = geoDecode(score)
+	}
+	return q.search(z), nil
+}
+
+// GeoSearch returns the members of the geo index at key matching q.
+func GeoSearch(db int, key string, q GeoQuery) ([]GeoResult, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	return d.geoSearch(key, q)
+}
+
+// GeoSearchStore stores the result of GeoSearch at dst, replacing it, and
+// returns its size. With storeDist the scores are the distances divided by
+// unit instead of the geohashes.
+func GeoSearchStore(db int, dst string, src string, q GeoQuery, storeDist bool, unit float64) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	results, err := d.geoSearch(src, q)
+	if err != nil {
+		return 0, err
+	}
+	z := newZSet()
+	for _, r := range results {
+		if storeDist {
+			z.set(r.Member, r.Dist/unit)
+		} else {
+			z.set(r.Member, r.Score)
+		}
+	}
+	return d.storeZSet(dst, z), nil
+}
@@ -0,0 +1,695 @@
+package store
+
+import (
+	"errors"
+	"iter"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+// Fields may carry their own TTL, kept in DB.Hash_expiry. Like keys they
+// expire lazily and actively: reads skip expired fields, writes delete the
+// expired fields of the hash they touch first, and the expiry cycle samples
+// hashes with field TTLs through ExpireFieldsSample.
+
+// hashForRead returns the hash at key if it exists, is live and has at
+// least one field that is not expired.
+func (d *DB) hashForRead(key string) (*inner_Hash_data, bool, error) {
+	if ok, err := d.lookupKey(key, "hash"); !ok {
+		return nil, false, err
+	}
+	h := d.Hash_data[key]
+	if d.hashLen(key, h) == 0 {
+		return nil, false, nil
+	}
+	return h, true, nil
+}
+
+// hashForWrite is hashForRead for commands that modify the hash: an expired
+// key and expired fields are deleted first.
+func (d *DB) hashForWrite(key string) (*inner_Hash_data, bool, error) {
+	if _, err := d.lookupKeyWrite(key, "hash"); err != nil {
+		return nil, false, err
+	}
+	d.expireFields(key, time.Now())
+	return d.hashForRead(key)
+}
+
+// fieldExpired reports whether field of the hash at key has a TTL that is
+// not after now.
+func (d *DB) fieldExpired(key string, field string, now time.Time) bool {
+	at, ok := d.Hash_expiry[key][field]
+	return ok && !at.After(now)
+}
+
+// hashLen returns the number of fields of h that are not expired.
+func (d *DB) hashLen(key string, h *inner_Hash_data) int {
+	length := h.len()
+	now := time.Now()
+	for _, at := range d.Hash_expiry[key] {
+		if !at.After(now) {
+			length--
+		}
+	}
+	return length
+}
+
+// hashFields yields the fields of h that are not expired.
+func (d *DB) hashFields(key string, h *inner_Hash_data) iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		now := time.Now()
+		for f, v := range h.all() {
+			if d.fieldExpired(key, f, now) {
+				continue
+			}
+			if !yield(f, v) {
+				return
+			}
+		}
+	}
+}
+
+// hashGet returns the value of field unless it is missing or expired.
+func (d *DB) hashGet(key string, h *inner_Hash_data, field string) (string, bool) {
+	value, ok := h.get(field)
+	if !ok || d.fieldExpired(key, field, time.Now()) {
+		return "", false
+	}
+	return value, true
+}
+
+// expireFields deletes the fields of the hash at key whose TTL is not after
+// now, and the hash once it is empty. It returns how many fields were
+// deleted. Callers must hold the write lock.
+func (d *DB) expireFields(key string, now time.Time) int {
+	expired := 0
+	for field, at := range d.Hash_expiry[key] {
+		if !at.After(now) {
+			d.hashDelete(key, field)
+			expired++
+		}
+	}
+	if h, ok := d.Hash_data[key]; ok && expired > 0 && h.len() == 0 {
+		d.removeKey(key)
+	}
+	return expired
+}
+
+// hashDelete removes field and its TTL from the hash at key, reporting
+// whether it existed. It does not delete an emptied hash.
+func (d *DB) hashDelete(key string, field string) bool {
+	h := d.Hash_data[key]
+	old, ok := h.get(field)
+	if !ok {
+		return false
+	}
+	d.growKey(key, -h.entrySize(field, old))
+	h.delete(field)
+	d.persistField(key, field)
+	return true
+}
+
+// setFieldExpiry sets the TTL of field, accounting for the new entry.
+func (d *DB) setFieldExpiry(key string, field string, at time.Time) {
+	fields, ok := d.Hash_expiry[key]
+	if !ok {
+		fields = make(map[string]time.Time)
+		d.Hash_expiry[key] = fields
+	}
+	if _, ok := fields[field]; !ok {
+		d.growKey(key, expiryOverhead)
+	}
+	fields[field] = at
+}
+
+// persistField removes the TTL of field, reporting whether it had one.
+func (d *DB) persistField(key string, field string) bool {
+	fields := d.Hash_expiry[key]
+	if _, ok := fields[field]; !ok {
+		return false
+	}
+	delete(fields, field)
+	if len(fields) == 0 {
+		delete(d.Hash_expiry, key)
+	}
+	d.growKey(key, -expiryOverhead)
+	return true
+}
+
+// deleteIfEmptyHash removes a hash that lost its last field.
+func (d *DB) deleteIfEmptyHash(key string) {
+	if h, ok := d.Hash_data[key]; ok && h.len() == 0 {
+		d.removeKey(key)
+	}
+}
+
+// hashSet stores one field, creating the hash if needed, and reports
+// whether the field is new. The field keeps its TTL if it has one. Callers
+// must hold the write lock.
+func (d *DB) hashSet(key string, field string, value string) (bool, error) {
+	ok, err := d.lookupKeyWrite(key, "hash")
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		d.Hash_data[key] = newHashObject()
+	}
+	h := d.Hash_data[key]
+	m := d.writeKey(key)
+	if h.compact() && !h.fits(field, value) {
+		before := hashSize(h)
+		h.convert()
+		grow(m, hashSize(h)-before)
+	}
+	if old, existed := h.get(field); existed {
+		grow(m, len(value)-len(old))
+		h.set(field, value)
+		return false, nil
+	}
+	grow(m, h.entrySize(field, value))
+	h.set(field, value)
+	return true, nil
+}
+
+// HSet sets each field/value pair and returns how many fields are new. The
+// TTL of the fields, if any, is removed.
+func HSet(db int, key string, fieldValues ...string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	if _, _, err := d.hashForWrite(key); err != nil {
+		return 0, err
+	}
+	added := 0
+	for i := 0; i+1 < len(fieldValues); i += 2 {
+		if isNew, _ := d.hashSet(key, fieldValues[i], fieldValues[i+1]); isNew {
+			added++
+		}
+		d.persistField(key, fieldValues[i])
+	}
+	return added, nil
+}
+
+// HSetNX sets field only if it does not exist yet.
+func HSetNX(db int, key string, field string, value string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	h, ok, err := d.hashForWrite(key)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		if _, exists := h.get(field); exists {
+			return 0, nil
+		}
+	}
+	d.hashSet(key, field, value)
+	return 1, nil
+}
+
+func HGet(db int, key string, field string) (string, bool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	m, ok, err := d.hashForRead(key)
+	if !ok {
+		return "", false, err
+	}
+	value, found := d.hashGet(key, m, field)
+	return value, found, nil
+}
+
+// HMGet returns the values of fields, with found[i] false for missing ones.
+func HMGet(db int, key string, fields []string) ([]string, []bool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	values := make([]string, len(fields))
+	found := make([]bool, len(fields))
+	h, ok, err := d.hashForRead(key)
+	if !ok {
+		return values, found, err
+	}
+	for i, field := range fields {
+		values[i], found[i] = d.hashGet(key, h, field)
+	}
+	return values, found, nil
+}
+
+// HDel removes fields and returns how many existed. The hash is deleted
+// once empty.
+func HDel(db int, key string, fields ...string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	if _, ok, err := d.hashForWrite(key); !ok {
+		return 0, err
+	}
+	removed := 0
+	for _, field := range fields {
+		if d.hashDelete(key, field) {
+			removed++
+		}
+	}
+	d.deleteIfEmptyHash(key)
+	return removed, nil
+}
+
+func HExists(db int, key string, field string) (bool, error) {
+	_, ok, err := HGet(db, key, field)
+	return ok, err
+}
+
+// HStrLen returns the length of the value of field, 0 if it is missing.
+func HStrLen(db int, key string, field string) (int, error) {
+	value, _, err := HGet(db, key, field)
+	return len(value), err
+}
+
+// HIncrBy adds delta to the integer stored in field, a missing field
+// counting as 0, and returns the new value.
+func HIncrBy(db int, key string, field string, delta int64) (int64, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	var current int64
+	h, ok, err := d.hashForWrite(key)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		if old, exists := h.get(field); exists {
+			n, err := strconv.ParseInt(old, 10, 64)
+			if err != nil {
+				return 0, errors.New("hash value is not an integer")
+			}
+			current = n
+		}
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, errors.New("increment or decrement would overflow")
+	}
+	current += delta
+	d.hashSet(key, field, strconv.FormatInt(current, 10))
+	return current, nil
+}
+
+// HIncrByFloat is HIncrBy for floats. The new value is stored and returned
+// in plain decimal notation, as Redis does.
+func HIncrByFloat(db int, key string, field string, delta float64) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	var current float64
+	h, ok, err := d.hashForWrite(key)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		if old, exists := h.get(field); exists {
+			f, err := strconv.ParseFloat(old, 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return "", errors.New("hash value is not a float")
+			}
+			current = f
+		}
+	}
+	current += delta
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return "", errors.New("increment would produce NaN or Infinity")
+	}
+	value := strconv.FormatFloat(current, 'f', -1, 64)
+	d.hashSet(key, field, value)
+	return value, nil
+}
+
+func HGetAll(db int, key string) ([]string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	response := make([]string, 0)
+	m, ok, err := d.hashForRead(key)
+	if ok {
+		for k, v := range d.hashFields(key, m) {
+			response = append(response, k)
+			response = append(response, v)
+		}
+	}
+	return response, err
+}
+
+func HKeys(db int, key string) ([]string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	response := make([]string, 0)
+	m, ok, err := d.hashForRead(key)
+	if ok {
+		for field := range d.hashFields(key, m) {
+			response = append(response, field)
+		}
+	}
+	return response, err
+}
+
+func HVals(db int, key string) ([]string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	response := make([]string, 0)
+	m, ok, err := d.hashForRead(key)
+	if ok {
+		for _, value := range d.hashFields(key, m) {
+			response = append(response, value)
+		}
+	}
+	return response, err
+}
+
+func HLen(db int, key string) (int, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	m, ok, err := d.hashForRead(key)
+	if !ok {
+		return 0, err
+	}
+	return d.hashLen(key, m), nil
+}
+
+// HRandField returns random fields and their values. A positive count
+// returns up to count distinct fields, a negative one exactly -count fields
+// that may repeat.
+func HRandField(db int, key string, count int) ([]string, []string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	fields, values := make([]string, 0), make([]string, 0)
+	h, ok, err := d.hashForRead(key)
+	if !ok || count == 0 {
+		return fields, values, err
+	}
+	// with field TTLs, pick among the live fields collected up front
+	expiring := len(d.Hash_expiry[key]) > 0
+	if count < 0 {
+		if expiring {
+			var live []dictEntry[string]
+			for f, v := range d.hashFields(key, h) {
+				live = append(live, dictEntry[string]{key: f, value: v})
+			}
+			for i := 0; i < -count; i++ {
+				e := live[rand.IntN(len(live))]
+				fields, values = append(fields, e.key), append(values, e.value)
+			}
+			return fields, values, nil
+		}
+		for i := 0; i < -count; i++ {
+			e, _ := h.random()
+			fields, values = append(fields, e.key), append(values, e.value)
+		}
+		return fields, values, nil
+	}
+	if expiring || count*3 > h.len() {
+		// close to the whole hash: shuffle all fields and keep count
+		for f, v := range d.hashFields(key, h) {
+			fields, values = append(fields, f), append(values, v)
+		}
+		rand.Shuffle(len(fields), func(i, j int) {
+			fields[i], fields[j] = fields[j], fields[i]
+			values[i], values[j] = values[j], values[i]
+		})
+		count = min(count, len(fields))
+		return fields[:count], values[:count], nil
+	}
+	seen := make(map[string]bool, count)
+	for len(fields) < count {
+		e, _ := h.random()
+		if seen[e.key] {
+			continue
+		}
+		seen[e.key] = true
+		fields, values = append(fields, e.key), append(values, e.value)
+	}
+	return fields, values, nil
+}
+
+// HScan returns the next cursor and the field/value pairs (only fields when
+// novalues is set) found from cursor on, see Scan.
+func HScan(db int, key string, cursor uint64, count int, pattern string, novalues bool) (uint64, []string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	response := make([]string, 0)
+	h, ok, err := d.hashForRead(key)
+	if !ok {
+		return 0, response, err
+	}
+	now := time.Now()
+	cursor = h.scan(cursor, count, func(field string, value string) {
+		if d.fieldExpired(key, field, now) {
+			return
+		}
+		if pattern != "" && !MatchPattern(pattern, field) {
+			return
+		}
+		response = append(response, field)
+		if !novalues {
+			response = append(response, value)
+		}
+	})
+	return cursor, response, nil
+}
+
+// FieldTTL says what HGETEX and HSETEX do with the TTL of the fields they
+// touch: set it to At, remove it (Persist) or keep it (Keep). The zero value
+// leaves HGETEX fields alone and makes HSETEX remove their TTL, as HSET does.
+type FieldTTL struct {
+	Set     bool
+	At      time.Time
+	Persist bool
+	Keep    bool
+}
+
+// apply updates the TTL of an existing field, deleting it if At is already
+// past. Callers must hold the write lock.
+func (t FieldTTL) apply(d *DB, key string, field string, now time.Time) {
+	switch {
+	case t.Set && !t.At.After(now):
+		d.hashDelete(key, field)
+	case t.Set:
+		d.setFieldExpiry(key, field, t.At)
+	case t.Persist:
+		d.persistField(key, field)
+	}
+}
+
+// HExpire sets the TTL of fields to at if cond allows it: "NX" only for
+// fields without TTL, "XX" only for fields with one, "GT" and "LT" only if
+// at is later or earlier than the current TTL (none counting as infinite).
+// For each field it returns -2 if the field does not exist, 0 if cond was
+// not met, 1 if the TTL was set and 2 if the field was deleted because at
+// is already past.
+func HExpire(db int, key string, at time.Time, cond string, fields []string) ([]int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	response := make([]int, len(fields))
+	h, ok, err := d.hashForWrite(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		for i := range response {
+			response[i] = -2
+		}
+		return response, nil
+	}
+	now := time.Now()
+	for i, field := range fields {
+		if _, exists := h.get(field); !exists {
+			response[i] = -2
+			continue
+		}
+		current, hasTTL := d.Hash_expiry[key][field]
+		if (cond == "NX" && hasTTL) || (cond == "XX" && !hasTTL) ||
+			(cond == "GT" && (!hasTTL || !at.After(current))) ||
+			(cond == "LT" && hasTTL && !at.Before(current)) {
+			continue
+		}
+		if !at.After(now) {
+			d.hashDelete(key, field)
+			response[i] = 2
+			continue
+		}
+		d.setFieldExpiry(key, field, at)
+		response[i] = 1
+	}
+	d.deleteIfEmptyHash(key)
+	return response, nil
+}
+
+// HExpireTime returns, for each field, its expiry as a Unix time in
+// milliseconds, -1 if it has no TTL or -2 if it does not exist.
+func HExpireTime(db int, key string, fields []string) ([]int64, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	response := make([]int64, len(fields))
+	h, ok, err := d.hashForRead(key)
+	if err != nil {
+		return nil, err
+	}
+	for i, field := range fields {
+		if !ok {
+			response[i] = -2
+			continue
+		}
+		if _, exists := d.hashGet(key, h, field); !exists {
+			response[i] = -2
+			continue
+		}
+		at, hasTTL := d.Hash_expiry[key][field]
+		if !hasTTL {
+			response[i] = -1
+			continue
+		}
+		response[i] = at.UnixMilli()
+	}
+	return response, nil
+}
+
+// HPersist removes the TTL of fields. For each field it returns 1 if the TTL
+// was removed, -1 if it had none and -2 if the field does not exist.
+func HPersist(db int, key string, fields []string) ([]int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	response := make([]int, len(fields))
+	h, ok, err := d.hashForWrite(key)
+	if err != nil {
+		return nil, err
+	}
+	for i, field := range fields {
+		if !ok {
+			response[i] = -2
+			continue
+		}
+		if _, exists := h.get(field); !exists {
+			response[i] = -2
+			continue
+		}
+		if d.persistField(key, field) {
+			response[i] = 1
+		} else {
+			response[i] = -1
+		}
+	}
+	return response, nil
+}
+
+// HGetEx returns the values of fields like HMGet and updates the TTL of the
+// existing ones according to ttl.
+func HGetEx(db int, key string, fields []string, ttl FieldTTL) ([]string, []bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	values := make([]string, len(fields))
+	found := make([]bool, len(fields))
+	h, ok, err := d.hashForWrite(key)
+	if !ok {
+		return values, found, err
+	}
+	now := time.Now()
+	for i, field := range fields {
+		values[i], found[i] = h.get(field)
+		if found[i] {
+			ttl.apply(d, key, field, now)
+		}
+	}
+	d.deleteIfEmptyHash(key)
+	return values, found, nil
+}
+
+// HSetEx sets the field/value pairs and their TTL. With cond "FNX" nothing
+// is set if any field exists, with "FXX" nothing is set unless all of them
+// exist. It reports whether the fields were set.
+func HSetEx(db int, key string, cond string, fieldValues []string, ttl FieldTTL) (bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	h, _, err := d.hashForWrite(key)
+	if err != nil {
+		return false, err
+	}
+	if cond != "" {
+		for i := 0; i+1 < len(fieldValues); i += 2 {
+			exists := false
+			if h != nil {
+				_, exists = h.get(fieldValues[i])
+			}
+			if (cond == "FNX" && exists) || (cond == "FXX" && !exists) {
+				return false, nil
+			}
+		}
+	}
+	if !ttl.Keep && !ttl.Set {
+		ttl.Persist = true
+	}
+	now := time.Now()
+	for i := 0; i+1 < len(fieldValues); i += 2 {
+		d.hashSet(key, fieldValues[i], fieldValues[i+1])
+		ttl.apply(d, key, fieldValues[i], now)
+	}
+	d.deleteIfEmptyHash(key)
+	return true, nil
+}
+
+// fieldsPerHash caps the field TTLs ExpireFieldsSample checks in one hash,
+// so that a hash with many of them does not hold the lock for long.
+const fieldsPerHash = 20
+
+// ExpireFieldsSample is ExpireSample for hash fields: it checks up to count
+// field TTLs, at most fieldsPerHash of them in each hash, and deletes the
+// expired fields. Go randomises map iteration order, so both the hashes and
+// their fields are a random sample. It returns how many fields were sampled
+// and how many of them were expired.
+func ExpireFieldsSample(db int, count int) (int, int) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	now := time.Now()
+	sampled, expired := 0, 0
+	for key, fields := range d.Hash_expiry {
+		if sampled >= count {
+			break
+		}
+		checked := 0
+		for field, at := range fields {
+			if checked >= fieldsPerHash || sampled >= count {
+				break
+			}
+			checked++
+			sampled++
+			if !at.After(now) {
+				d.hashDelete(key, field)
+				expired++
+			}
+		}
+		d.deleteIfEmptyHash(key)
+	}
+	return sampled, expired
+}
+
+// hashExpirySnapshot returns a copy of the field TTLs of d for
+// persistence.
+func (d *DB) hashExpirySnapshot() map[string]map[string]time.Time {
+	response := make(map[string]map[string]time.Time, len(d.Hash_expiry))
+	for key, fields := range d.Hash_expiry {
+		response[key] = maps.Clone(fields)
+	}
+	return response
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExpireFieldsSampleBounded(t *testing.T) {
+	Init(1)
+	fields := make([]string, 0, 1000)
+	for i := range 1000 {
+		fields = append(fields, "f"+strconv.Itoa(i))
+		HSet(0, "h", fields[i], "v")
+	}
+	HExpire(0, "h", time.Now().Add(time.Hour), "", fields)
+	past := time.Now().Add(-time.Second)
+	for field := range dbs[0].Hash_expiry["h"] {
+		dbs[0].Hash_expiry["h"][field] = past
+	}
+
+	sampled, expired := ExpireFieldsSample(0, 100)
+	if sampled != fieldsPerHash || expired != fieldsPerHash {
+		t.Fatalf("sampled %d, expired %d fields of one hash, want %d of each", sampled, expired, fieldsPerHash)
+	}
+	if n := len(dbs[0].Hash_expiry["h"]); n != 1000-fieldsPerHash {
+		t.Fatalf("%d field TTLs left, want %d", n, 1000-fieldsPerHash)
+	}
+	for range 1000 / fieldsPerHash {
+		ExpireFieldsSample(0, 100)
+	}
+	if Exists(0, "h") {
+		t.Fatal("hash with every field expired still exists")
+	}
+}
@@ -0,0 +1,551 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"litekv/internal/config"
+)
+
+// HyperLogLogs are string values laid out exactly as in Redis, so they can
+// be exchanged with it: a 16 byte header ("HYLL", the encoding, three unused
+// bytes and the cached cardinality as a little endian uint64 whose top bit
+// marks it stale) followed by 16384 6-bit registers. The dense encoding
+// packs the registers least significant bit first; the sparse one
+// run-length encodes them with three opcodes:
+//
+//	ZERO   00xxxxxx          xxxxxx+1 registers set to 0 (1-64)
+//	XZERO  01xxxxxx yyyyyyyy xxxxxxyyyyyyyy+1 registers set to 0 (1-16384)
+//	VAL    1vvvvvxx          xx+1 registers set to vvvvv+1 (1-4 times 1-32)
+//
+// PFADD updates registers where they are: it rewrites the bytes of a dense
+// register, or splits the sparse opcode covering it as Redis does. A sparse
+// HyperLogLog becomes dense once a register exceeds 32 or the string grows
+// past hll-sparse-max-bytes, and stays dense; only then are all registers
+// decoded and encoded again.
+const (
+	hllP           = 14
+	hllQ           = 64 - hllP
+	hllRegisters   = 1 << hllP
+	hllBits        = 6
+	hllHeaderSize  = 16
+	hllDenseSize   = hllHeaderSize + (hllRegisters*hllBits+7)/8
+	hllDense       = 0
+	hllSparse      = 1
+	hllStale       = 0x80 // top bit of the last cardinality byte
+	hllSeed        = 0xadc83b19
+	hllAlphaInf    = 0.721347520444481703680 // 1 / (2 ln 2)
+	hllSparseMaxV  = 32
+	hllSparseMaxL  = 4
+	hllZeroMaxL    = 64
+	hllXZeroMaxL   = 16384
+	hllMagicHeader = "HYLL"
+)
+
+var (
+	ErrNotHLL     = errors.New("Key is not a valid HyperLogLog string value.")
+	ErrCorruptHLL = errors.New("Corrupted HLL object detected")
+)
+
+type hllRegs [hllRegisters]uint8
+
+// murmurHash64A is the hash Redis uses for HyperLogLog elements.
+func murmurHash64A(data string, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ (uint64(len(data)) * m)
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64([]byte(data[:8]))
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register element maps to and the length of the run
+// of zeros after it, plus one, which is what the register keeps the maximum
+// of.
+func hllPatLen(element string) (int, uint8) {
+	hash := murmurHash64A(element, hllSeed)
+	index := int(hash & (hllRegisters - 1))
+	hash >>= hllP
+	hash |= 1 << hllQ
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// validHLL reports whether v has a HyperLogLog header, the cheap check
+// Redis makes before trusting a string.
+func validHLL(v string) bool {
+	if len(v) < hllHeaderSize || v[:4] != hllMagicHeader || v[4] > hllSparse {
+		return false
+	}
+	return v[4] == hllSparse || len(v) == hllDenseSize
+}
+
+// decodeHLL validates a HyperLogLog string and returns its registers and
+// whether it is dense.
+func decodeHLL(v string) (*hllRegs, bool, error) {
+	if !validHLL(v) {
+		return nil, false, ErrNotHLL
+	}
+	regs := new(hllRegs)
+	body := v[hllHeaderSize:]
+	if v[4] == hllDense {
+		for i := range regs {
+			regs[i] = denseRegister(body, i)
+		}
+		return regs, true, nil
+	}
+	i := 0
+	for p := 0; p < len(body); p++ {
+		op := body[p]
+		run := 0
+		switch {
+		case op&0xc0 == 0x00: // ZERO
+			run = int(op&0x3f) + 1
+		case op&0xc0 == 0x40: // XZERO
+			if p+1 >= len(body) {
+				return nil, false, ErrCorruptHLL
+			}
+			run = (int(op&0x3f)<<8 | int(body[p+1])) + 1
+			p++
+		default: // VAL
+			run = int(op&0x3) + 1
+			if i+run > hllRegisters {
+				return nil, false, ErrCorruptHLL
+			}
+			for j := 0; j < run; j++ {
+				regs[i+j] = (op>>2)&0x1f + 1
+			}
+		}
+		i += run
+		if i > hllRegisters {
+			return nil, false, ErrCorruptHLL
+		}
+	}
+	if i != hllRegisters {
+		return nil, false, ErrCorruptHLL
+	}
+	return regs, false, nil
+}
+
+func denseRegister(body string, i int) uint8 {
+	pos := i * hllBits / 8
+	shift := uint(i * hllBits % 8)
+	v := uint16(body[pos])
+	if pos+1 < len(body) {
+		v |= uint16(body[pos+1]) << 8
+	}
+	return uint8(v>>shift) & 0x3f
+}
+
+// encodeHLL builds a HyperLogLog string from regs with a stale cardinality
+// cache. It is sparse unless dense is set or the registers do not fit.
+func encodeHLL(regs *hllRegs, dense bool) string {
+	if !dense {
+		if v, ok := encodeSparse(regs); ok {
+			return v
+		}
+	}
+	b := make([]byte, hllDenseSize)
+	copy(b, hllMagicHeader)
+	b[4] = hllDense
+	b[15] = hllStale
+	body := b[hllHeaderSize:]
+	for i, r := range regs {
+		pos := i * hllBits / 8
+		shift := uint(i * hllBits % 8)
+		v := uint16(r) << shift
+		body[pos] |= byte(v)
+		if pos+1 < len(body) {
+			body[pos+1] |= byte(v >> 8)
+		}
+	}
+	return string(b)
+}
+
+// encodeSparse run-length encodes regs, reporting false if a register is
+// too large for VAL or the result exceeds hll-sparse-max-bytes.
+func encodeSparse(regs *hllRegs) (string, bool) {
+	limit := config.HLLSparseMaxBytes()
+	b := make([]byte, hllHeaderSize, 64)
+	copy(b, hllMagicHeader)
+	b[4] = hllSparse
+	b[15] = hllStale
+	for i := 0; i < hllRegisters; {
+		v := regs[i]
+		run := 1
+		for i+run < hllRegisters && regs[i+run] == v {
+			run++
+		}
+		i += run
+		if v > hllSparseMaxV {
+			return "", false
+		}
+		b = appendRun(b, v, run)
+		if len(b) > limit {
+			return "", false
+		}
+	}
+	return string(b), true
+}
+
+// appendRun appends the opcodes for run registers set to v, which must fit
+// VAL.
+func appendRun(b []byte, v uint8, run int) []byte {
+	for run > 0 {
+		switch {
+		case v != 0:
+			l := min(run, hllSparseMaxL)
+			b = append(b, 0x80|(v-1)<<2|byte(l-1))
+			run -= l
+		case run > hllZeroMaxL:
+			l := min(run, hllXZeroMaxL) - 1
+			b = append(b, 0x40|byte(l>>8), byte(l))
+			run -= l + 1
+		default:
+			b = append(b, byte(run-1))
+			run = 0
+		}
+	}
+	return b
+}
+
+// errSparseFull reports that a register cannot be set in the sparse
+// encoding, which has to become dense.
+var errSparseFull = errors.New("sparse HyperLogLog full")
+
+// sparseSet raises register index to count in the sparse HyperLogLog b by
+// splitting the opcode covering it, as Redis does, and reports whether it
+// changed.
+func sparseSet(b []byte, index int, count uint8) ([]byte, bool, error) {
+	i := 0
+	for p := hllHeaderSize; p < len(b); {
+		op := b[p]
+		size, run, value := 1, 0, uint8(0)
+		switch {
+		case op&0xc0 == 0x00: // ZERO
+			run = int(op&0x3f) + 1
+		case op&0xc0 == 0x40: // XZERO
+			if p+1 >= len(b) {
+				return nil, false, ErrCorruptHLL
+			}
+			size = 2
+			run = (int(op&0x3f)<<8 | int(b[p+1])) + 1
+		default: // VAL
+			run = int(op&0x3) + 1
+			value = (op>>2)&0x1f + 1
+		}
+		if index >= i+run {
+			i += run
+			p += size
+			continue
+		}
+		if count <= value {
+			return b, false, nil
+		}
+		if count > hllSparseMaxV {
+			return b, false, errSparseFull
+		}
+		ops := appendRun(nil, value, index-i)
+		ops = appendRun(ops, count, 1)
+		ops = appendRun(ops, value, i+run-index-1)
+		if len(b)-size+len(ops) > config.HLLSparseMaxBytes() {
+			return b, false, errSparseFull
+		}
+		return slices.Replace(b, p, p+size, ops...), true, nil
+	}
+	return nil, false, ErrCorruptHLL
+}
+
+// raiseDense returns the dense HyperLogLog v with the registers in changes
+// set and its cardinality cache marked stale. Only the bytes holding them
+// are rewritten.
+func raiseDense(v string, changes map[int]uint8) string {
+	patch := make(map[int]byte, 2*len(changes)+1)
+	at := func(p int) byte {
+		if b, ok := patch[p]; ok {
+			return b
+		}
+		return v[p]
+	}
+	for i, r := range changes {
+		pos := hllHeaderSize + i*hllBits/8
+		shift := uint(i * hllBits % 8)
+		word := uint16(at(pos))
+		if pos+1 < len(v) {
+			word |= uint16(at(pos+1)) << 8
+		}
+		word = word&^(0x3f<<shift) | uint16(r)<<shift
+		patch[pos] = byte(word)
+		if pos+1 < len(v) {
+			patch[pos+1] = byte(word >> 8)
+		}
+	}
+	patch[15] = at(15) | hllStale
+	return patchString(v, patch)
+}
+
+// patchString returns a copy of v with the bytes at the offsets of patch
+// replaced, allocating once.
+func patchString(v string, patch map[int]byte) string {
+	var sb strings.Builder
+	sb.Grow(len(v))
+	last := 0
+	for _, p := range slices.Sorted(maps.Keys(patch)) {
+		sb.WriteString(v[last:p])
+		sb.WriteByte(patch[p])
+		last = p + 1
+	}
+	sb.WriteString(v[last:])
+	return sb.String()
+}
+
+// hllAdd adds elements to the HyperLogLog v and reports whether a register
+// changed. The registers are only decoded when a sparse HyperLogLog has to
+// become dense.
+func hllAdd(v string, elements []string) (string, bool, error) {
+	if !validHLL(v) {
+		return "", false, ErrNotHLL
+	}
+	if v[4] == hllDense {
+		body := v[hllHeaderSize:]
+		changes := make(map[int]uint8)
+		for _, element := range elements {
+			index, count := hllPatLen(element)
+			current, ok := changes[index]
+			if !ok {
+				current = denseRegister(body, index)
+			}
+			if count > current {
+				changes[index] = count
+			}
+		}
+		if len(changes) == 0 {
+			return v, false, nil
+		}
+		return raiseDense(v, changes), true, nil
+	}
+	b := []byte(v)
+	changed := false
+	for n, element := range elements {
+		index, count := hllPatLen(element)
+		next, set, err := sparseSet(b, index, count)
+		if err == errSparseFull {
+			regs, _, err := decodeHLL(string(b))
+			if err != nil {
+				return "", false, err
+			}
+			v, _, err := hllAdd(encodeHLL(regs, true), elements[n:])
+			return v, true, err
+		}
+		if err != nil {
+			return "", false, err
+		}
+		b, changed = next, changed || set
+	}
+	if !changed {
+		return v, false, nil
+	}
+	b[15] |= hllStale
+	return string(b), true, nil
+}
+
+// hllTau and hllSigma are the correction functions of Ertl's improved
+// estimator, which Redis uses.
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+// hllCount estimates the cardinality from the register histogram.
+func hllCount(regs *hllRegs) uint64 {
+	var histogram [64]int
+	for _, r := range regs {
+		histogram[r]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+// PFAdd adds elements to the HyperLogLog at key, creating it if needed. It
+// returns 1 if the key was created or a register changed, 0 otherwise.
+func PFAdd(db int, key string, elements []string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	v, exists, err := d.stringForWrite(key)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		v = encodeHLL(new(hllRegs), false)
+	}
+	v, changed, err := hllAdd(v, elements)
+	if err != nil {
+		return 0, err
+	}
+	if exists && !changed {
+		return 0, nil
+	}
+	d.setString(key, v)
+	return 1, nil
+}
+
+// PFCount estimates the number of distinct elements added to the
+// HyperLogLogs at keys, counting their union when there are several. For a
+// single key the estimate is cached in the header until the next change.
+func PFCount(db int, keys []string) (uint64, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	if len(keys) == 1 {
+		v, ok, err := d.stringForRead(keys[0])
+		if !ok {
+			return 0, err
+		}
+		if validHLL(v) && v[15]&hllStale == 0 {
+			return binary.LittleEndian.Uint64([]byte(v[8:16])), nil
+		}
+		regs, _, err := decodeHLL(v)
+		if err != nil {
+			return 0, err
+		}
+		count := hllCount(regs)
+		patch := make(map[int]byte, 8)
+		for i, c := range binary.LittleEndian.AppendUint64(nil, count) {
+			patch[8+i] = c
+		}
+		d.setString(keys[0], patchString(v, patch))
+		return count, nil
+	}
+	union := new(hllRegs)
+	for _, key := range keys {
+		v, ok, err := d.stringForRead(key)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		regs, _, err := decodeHLL(v)
+		if err != nil {
+			return 0, err
+		}
+		for i, r := range regs {
+			union[i] = max(union[i], r)
+		}
+	}
+	return hllCount(union), nil
+}
+
+// PFMerge stores at dst the union of the HyperLogLogs at dst and keys. The
+// result is dense if any of them is.
+func PFMerge(db int, dst string, keys []string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	union := new(hllRegs)
+	dense := false
+	for _, key := range keys {
+		v, ok, err := d.stringForRead(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		regs, isDense, err := decodeHLL(v)
+		if err != nil {
+			return err
+		}
+		dense = dense || isDense
+		for i, r := range regs {
+			union[i] = max(union[i], r)
+		}
+	}
+	v, exists, err := d.stringForWrite(dst)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		d.setString(dst, encodeHLL(union, dense))
+		return nil
+	}
+	if validHLL(v) && v[4] == hllDense {
+		// raise the registers of dst where they are
+		changes := make(map[int]uint8)
+		for i, r := range union {
+			if r > denseRegister(v[hllHeaderSize:], i) {
+				changes[i] = r
+			}
+		}
+		d.setString(dst, raiseDense(v, changes))
+		return nil
+	}
+	regs, _, err := decodeHLL(v)
+	if err != nil {
+		return err
+	}
+	for i, r := range regs {
+		union[i] = max(union[i], r)
+	}
+	d.setString(dst, encodeHLL(union, dense))
+	return nil
+}
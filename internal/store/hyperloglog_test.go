@@ -0,0 +1,170 @@
+package store
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// hllHeader returns a header for the given encoding with a stale
+// cardinality cache.
+func hllHeader(encoding byte) string {
+	return hllMagicHeader + string([]byte{encoding, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, hllStale})
+}
+
+func TestHLLErrorBound(t *testing.T) {
+	const runs = 10
+	const n = 100000
+	Init(1)
+	sum := 0.0
+	for run := range runs {
+		key := "hll" + strconv.Itoa(run)
+		batch := make([]string, 0, 1000)
+		for i := range n {
+			batch = append(batch, strconv.Itoa(run)+":"+strconv.Itoa(i))
+			if len(batch) == cap(batch) {
+				PFAdd(0, key, batch)
+				batch = batch[:0]
+			}
+		}
+		count, err := PFCount(0, []string{key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := (float64(count) - n) / n
+		sum += e * e
+	}
+	// the standard error of 16384 registers is 1.04/sqrt(16384), 0.81%
+	rms := math.Sqrt(sum / runs)
+	if rms > 0.0081*1.5 {
+		t.Fatalf("RMS error %.2f%% at n=%d, want about 0.81%%", rms*100, n)
+	}
+	t.Logf("RMS error %.2f%% at n=%d over %d runs", rms*100, n, runs)
+}
+
+func TestHLLSparseToDense(t *testing.T) {
+	Init(1)
+	elements := make([]string, 0, 20000)
+	for i := range 20000 {
+		elements = append(elements, "e"+strconv.Itoa(i))
+	}
+	PFAdd(0, "hll", elements[:100])
+	v, _, _ := Get(0, "hll")
+	if v[4] != hllSparse {
+		t.Fatalf("encoding %d after 100 elements, want sparse", v[4])
+	}
+	sparse, _, err := decodeHLL(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := PFCount(0, []string{"hll"})
+
+	// the dense encoding of the same registers counts the same
+	dense, isDense, err := decodeHLL(encodeHLL(sparse, true))
+	if err != nil || !isDense || *dense != *sparse {
+		t.Fatalf("dense copy of the sparse registers differs (err %v)", err)
+	}
+	if got := hllCount(dense); got != before {
+		t.Fatalf("dense count %d, sparse count %d", got, before)
+	}
+
+	PFAdd(0, "hll", elements)
+	v, _, _ = Get(0, "hll")
+	if v[4] != hllDense || len(v) != hllDenseSize {
+		t.Fatalf("encoding %d, length %d after 20000 elements, want dense", v[4], len(v))
+	}
+	regs, _, _ := decodeHLL(v)
+	for i := range regs {
+		if regs[i] < sparse[i] {
+			t.Fatalf("register %d dropped from %d to %d in the conversion", i, sparse[i], regs[i])
+		}
+	}
+
+	// a register above 32 does not fit the VAL opcode
+	regs = new(hllRegs)
+	regs[0] = hllSparseMaxV + 1
+	if v := encodeHLL(regs, false); v[4] != hllDense {
+		t.Fatal("register above 32 kept the sparse encoding")
+	}
+}
+
+func TestHLLRedisEncoding(t *testing.T) {
+	Init(1)
+	// the value Redis stores for PFADD on a missing key without elements:
+	// sparse, a valid cached cardinality of 0 and one XZERO for every
+	// register
+	empty := "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff"
+	Set(0, "empty", empty)
+	if n, err := PFCount(0, []string{"empty"}); err != nil || n != 0 {
+		t.Fatalf("PFCOUNT of the empty Redis HyperLogLog = %d, %v, want 0", n, err)
+	}
+	if v := encodeHLL(new(hllRegs), false); v[hllHeaderSize:] != empty[hllHeaderSize:] {
+		t.Fatalf("empty registers encode to %q, want %q", v[hllHeaderSize:], empty[hllHeaderSize:])
+	}
+
+	// XZERO 1000, VAL 2 three times, XZERO 15381: three registers set, which
+	// linear counting estimates as 3
+	sparse := hllHeader(hllSparse) + "\x43\xe7\x86\x7c\x14"
+	Set(0, "sparse", sparse)
+	if n, err := PFCount(0, []string{"sparse"}); err != nil || n != 3 {
+		t.Fatalf("PFCOUNT of three set registers = %d, %v, want 3", n, err)
+	}
+	regs, _, _ := decodeHLL(sparse)
+	if regs[999] != 0 || regs[1000] != 2 || regs[1002] != 2 || regs[1003] != 0 {
+		t.Fatalf("registers 999-1003 = %v, want [0 2 2 2 0]", regs[999:1004])
+	}
+	if v := encodeHLL(regs, false); v != sparse {
+		t.Fatalf("registers encode to %q, want %q", v, sparse)
+	}
+	Set(0, "dense", encodeHLL(regs, true))
+	if n, _ := PFCount(0, []string{"dense"}); n != 3 {
+		t.Fatalf("PFCOUNT of the dense copy = %d, want 3", n)
+	}
+}
+
+func TestPFAddInPlace(t *testing.T) {
+	Init(1)
+	want := new(hllRegs)
+	check := func(key string) {
+		t.Helper()
+		v, _, _ := Get(0, key)
+		regs, _, err := decodeHLL(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *regs != *want {
+			t.Fatalf("registers of %s differ from the ones added", key)
+		}
+	}
+	batch := make([]string, 0, 50)
+	for i := range 20000 {
+		element := "e" + strconv.Itoa(i)
+		index, count := hllPatLen(element)
+		want[index] = max(want[index], count)
+		batch = append(batch, element)
+		if len(batch) == cap(batch) {
+			PFAdd(0, "hll", batch)
+			batch = batch[:0]
+			if i < 2000 || i%1000 == 999 {
+				check("hll")
+			}
+		}
+	}
+	if v, _, _ := Get(0, "hll"); v[4] != hllDense {
+		t.Fatal("HyperLogLog still sparse after 20000 elements")
+	}
+	if n, _ := PFAdd(0, "hll", []string{"e1"}); n != 0 {
+		t.Fatal("PFADD of a known element changed a register")
+	}
+
+	// merging into a dense key raises its registers where they are
+	PFAdd(0, "other", []string{"x", "y", "z"})
+	for _, element := range []string{"x", "y", "z"} {
+		index, count := hllPatLen(element)
+		want[index] = max(want[index], count)
+	}
+	if err := PFMerge(0, "hll", []string{"other"}); err != nil {
+		t.Fatal(err)
+	}
+	check("hll")
+}
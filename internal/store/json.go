@@ -0,0 +1,750 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSON documents are kept parsed so nested values can be updated in place.
+// A value is nil, bool, int64, float64, string, *jsonArray or *jsonObject;
+// objects remember the order their members were added in, which is the
+// order they are printed in. Integers stay integers until an increment
+// makes them fractional or overflows them.
+const jsonMaxDepth = 128
+
+var (
+	ErrJSONNoKey      = errors.New("could not perform this operation on a key that doesn't exist")
+	ErrJSONNewAtRoot  = errors.New("new objects must be created at the root")
+	ErrJSONNotNumber  = errors.New("expected a number")
+	ErrJSONNumberSize = errors.New("result is not a finite number")
+)
+
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+type jsonArray struct {
+	items []any
+}
+
+// jsonDoc is the document stored at a key and the memory attributed to it.
+type jsonDoc struct {
+	root any
+	size int
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]any)}
+}
+
+func (o *jsonObject) set(key string, v any) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+func (o *jsonObject) delete(key string) bool {
+	if _, ok := o.values[key]; !ok {
+		return false
+	}
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// parseJSON parses a single JSON value.
+func parseJSON(s string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	v, err := decodeJSON(dec, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid JSON: trailing characters after the value")
+	}
+	return v, nil
+}
+
+func decodeJSON(dec *json.Decoder, depth int) (any, error) {
+	if depth > jsonMaxDepth {
+		return nil, fmt.Errorf("invalid JSON: nesting deeper than %d levels", jsonMaxDepth)
+	}
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil, errors.New("invalid JSON: unexpected end of input")
+	}
+	if err != nil {
+		return nil, errors.New("invalid JSON: " + err.Error())
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '{' {
+			o := newJSONObject()
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, errors.New("invalid JSON: " + err.Error())
+				}
+				v, err := decodeJSON(dec, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				o.set(key.(string), v)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, errors.New("invalid JSON: " + err.Error())
+			}
+			return o, nil
+		}
+		if t == '[' {
+			a := &jsonArray{items: make([]any, 0)}
+			for dec.More() {
+				v, err := decodeJSON(dec, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				a.items = append(a.items, v)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, errors.New("invalid JSON: " + err.Error())
+			}
+			return a, nil
+		}
+		return nil, errors.New("invalid JSON: unexpected " + t.String())
+	case json.Number:
+		if !strings.ContainsAny(string(t), ".eE") {
+			if n, err := t.Int64(); err == nil {
+				return n, nil
+			}
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, errors.New("invalid JSON: number out of range")
+		}
+		return f, nil
+	}
+	return tok, nil
+}
+
+// JSONFormat is how JSON.GET lays out values: Indent is repeated once per
+// nesting level, Newline ends every line and Space follows each colon. The
+// zero value gives compact JSON.
+type JSONFormat struct {
+	Indent, Newline, Space string
+}
+
+func (f JSONFormat) format(v any) string {
+	var b strings.Builder
+	f.write(&b, v, 0)
+	return b.String()
+}
+
+func (f JSONFormat) write(b *strings.Builder, v any, depth int) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		b.WriteString(formatJSONFloat(v))
+	case string:
+		writeJSONString(b, v)
+	case *jsonArray:
+		b.WriteByte('[')
+		for i, item := range v.items {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			f.newline(b, depth+1)
+			f.write(b, item, depth+1)
+		}
+		if len(v.items) > 0 {
+			f.newline(b, depth)
+		}
+		b.WriteByte(']')
+	case *jsonObject:
+		b.WriteByte('{')
+		for i, k := range v.keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			f.newline(b, depth+1)
+			writeJSONString(b, k)
+			b.WriteByte(':')
+			b.WriteString(f.Space)
+			f.write(b, v.values[k], depth+1)
+		}
+		if len(v.keys) > 0 {
+			f.newline(b, depth)
+		}
+		b.WriteByte('}')
+	}
+}
+
+func (f JSONFormat) newline(b *strings.Builder, depth int) {
+	b.WriteString(f.Newline)
+	for i := 0; i < depth; i++ {
+		b.WriteString(f.Indent)
+	}
+}
+
+// formatJSONFloat prints the shortest representation of f that parses
+// back to it, keeping a fraction or an exponent so it reads as a float.
+func formatJSONFloat(f float64) string {
+	if abs := math.Abs(f); abs == 0 || (abs >= 1e-5 && abs < 1e16) {
+		s := strconv.FormatFloat(f, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	}
+	s := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, exp, _ := strings.Cut(s, "e")
+	sign := ""
+	if exp[0] == '-' {
+		sign = "-"
+	}
+	return mantissa + "e" + sign + strings.TrimLeft(exp[1:], "0")
+}
+
+func writeJSONString(b *strings.Builder, s string) {
+	const hex = "0123456789abcdef"
+	b.WriteByte('"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '\b':
+			b.WriteString(`\b`)
+		case c == '\f':
+			b.WriteString(`\f`)
+		case c < 0x20:
+			b.WriteString(`\u00`)
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		case c < utf8.RuneSelf:
+			b.WriteByte(c)
+		default:
+			r, size := utf8.DecodeRuneInString(s[i:])
+			b.WriteRune(r)
+			i += size
+			continue
+		}
+		i++
+	}
+	b.WriteByte('"')
+}
+
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *jsonArray:
+		return "array"
+	}
+	return "object"
+}
+
+func jsonFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func cloneJSON(v any) any {
+	switch v := v.(type) {
+	case *jsonArray:
+		c := &jsonArray{items: make([]any, len(v.items))}
+		for i, item := range v.items {
+			c.items[i] = cloneJSON(item)
+		}
+		return c
+	case *jsonObject:
+		c := &jsonObject{keys: append([]string(nil), v.keys...), values: make(map[string]any, len(v.values))}
+		for k, item := range v.values {
+			c.values[k] = cloneJSON(item)
+		}
+		return c
+	}
+	return v
+}
+
+func (doc *jsonDoc) clone() *jsonDoc {
+	return &jsonDoc{root: cloneJSON(doc.root), size: doc.size}
+}
+
+// jsonSize estimates the memory held by a JSON value.
+func jsonSize(v any) int {
+	switch v := v.(type) {
+	case string:
+		return elemOverhead + len(v)
+	case *jsonArray:
+		size := elemOverhead
+		for _, item := range v.items {
+			size += jsonSize(item)
+		}
+		return size
+	case *jsonObject:
+		size := elemOverhead
+		for k, item := range v.values {
+			size += fieldOverhead + len(k) + jsonSize(item)
+		}
+		return size
+	}
+	return elemOverhead
+}
+
+// replace stores v where r points in doc.
+func (doc *jsonDoc) replace(r jsonRef, v any) {
+	switch parent := r.parent.(type) {
+	case nil:
+		doc.root = v
+	case *jsonObject:
+		parent.set(r.key, v)
+	case *jsonArray:
+		parent.items[r.index] = v
+	}
+}
+
+// jsonForRead returns the document at key if it exists and is live, or
+// ErrWrongType if the key holds another type.
+func (d *DB) jsonForRead(key string) (*jsonDoc, bool, error) {
+	if ok, err := d.lookupKey(key, "ReJSON-RL"); !ok {
+		return nil, false, err
+	}
+	return d.JSON_data[key], true, nil
+}
+
+// jsonForWrite is jsonForRead for commands that modify the document: an
+// expired key is deleted first so its TTL does not carry over.
+func (d *DB) jsonForWrite(key string) (*jsonDoc, bool, error) {
+	if ok, err := d.lookupKeyWrite(key, "ReJSON-RL"); !ok {
+		return nil, false, err
+	}
+	return d.JSON_data[key], true, nil
+}
+
+// resizeJSON accounts for a change to the document at key.
+func (d *DB) resizeJSON(key string, doc *jsonDoc) {
+	size := jsonSize(doc.root)
+	grow(d.writeKey(key), size-doc.size)
+	doc.size = size
+}
+
+// JSONSet stores value at path in the document at key, which must exist
+// unless path is the root. Existing matches are replaced; if there are none
+// and the last segment of path is a member name, the member is added to the
+// objects its parent path matches. NX only adds, XX only replaces. It
+// returns false if nothing was written.
+func JSONSet(db int, key string, path string, value string, nx bool, xx bool) (bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return false, err
+	}
+	v, err := parseJSON(value)
+	if err != nil {
+		return false, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	doc, ok, err := d.jsonForWrite(key)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		if len(p.segments) > 0 {
+			return false, ErrJSONNewAtRoot
+		}
+		if xx {
+			return false, nil
+		}
+		doc = &jsonDoc{root: v}
+		d.JSON_data[key] = doc
+		d.resizeJSON(key, doc)
+		return true, nil
+	}
+	refs := p.eval(doc.root)
+	if len(refs) > 0 {
+		if nx {
+			return false, nil
+		}
+		for i, r := range refs {
+			if i > 0 {
+				v = cloneJSON(v)
+			}
+			doc.replace(r, v)
+		}
+		d.resizeJSON(key, doc)
+		return true, nil
+	}
+	last := len(p.segments) - 1
+	if xx || last < 0 {
+		return false, nil
+	}
+	seg := p.segments[last]
+	if seg.recursive || len(seg.selectors) != 1 || seg.selectors[0].kind != selectName {
+		return false, nil
+	}
+	parents := (&jsonPath{segments: p.segments[:last]}).eval(doc.root)
+	written := false
+	for _, r := range parents {
+		if o, ok := r.value.(*jsonObject); ok {
+			if written {
+				v = cloneJSON(v)
+			}
+			o.set(seg.selectors[0].name, v)
+			written = true
+		}
+	}
+	if written {
+		d.resizeJSON(key, doc)
+	}
+	return written, nil
+}
+
+// jsonPathResult is what one path of JSON.GET contributes to its reply:
+// the first match for a legacy path, an array of all matches otherwise.
+func jsonPathResult(p *jsonPath, root any) (any, error) {
+	refs := p.eval(root)
+	if p.legacy {
+		if len(refs) == 0 {
+			return nil, fmt.Errorf("Path '%s' does not exist", p.text)
+		}
+		return refs[0].value, nil
+	}
+	a := &jsonArray{items: make([]any, len(refs))}
+	for i, r := range refs {
+		a.items[i] = r.value
+	}
+	return a, nil
+}
+
+// JSONGet returns the values at paths in the document at key, formatted
+// with f, and whether the key exists. Without paths the whole document is
+// returned. With several paths the reply is an object keyed by path, and
+// legacy paths reply with arrays too unless all of them are legacy.
+func JSONGet(db int, key string, paths []string, f JSONFormat) (string, bool, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	parsed := make([]*jsonPath, len(paths))
+	allLegacy := true
+	for i, path := range paths {
+		p, err := parseJSONPath(path)
+		if err != nil {
+			return "", false, err
+		}
+		parsed[i] = p
+		allLegacy = allLegacy && p.legacy
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	doc, ok, err := d.jsonForRead(key)
+	if !ok || err != nil {
+		return "", false, err
+	}
+	if len(parsed) == 1 {
+		v, err := jsonPathResult(parsed[0], doc.root)
+		if err != nil {
+			return "", true, err
+		}
+		return f.format(v), true, nil
+	}
+	response := newJSONObject()
+	for _, p := range parsed {
+		p.legacy = allLegacy
+		v, err := jsonPathResult(p, doc.root)
+		if err != nil {
+			return "", true, err
+		}
+		response.set(p.text, v)
+	}
+	return f.format(response), true, nil
+}
+
+// JSONMGet returns the value at path in the document at each key, with
+// found[i] false for keys that are missing, hold another type or, with a
+// legacy path, lack the path.
+func JSONMGet(db int, keys []string, path string) ([]string, []bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	values := make([]string, len(keys))
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		doc, ok, _ := d.jsonForRead(key)
+		if !ok {
+			continue
+		}
+		if v, err := jsonPathResult(p, doc.root); err == nil {
+			values[i], found[i] = JSONFormat{}.format(v), true
+		}
+	}
+	return values, found, nil
+}
+
+// JSONDel removes the values at path from the document at key and returns
+// how many were removed. Removing the root deletes the key.
+func JSONDel(db int, key string, path string) (int, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return 0, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	doc, ok, err := d.jsonForWrite(key)
+	if !ok || err != nil {
+		return 0, err
+	}
+	refs := p.eval(doc.root)
+	if len(refs) > 0 && refs[0].parent == nil {
+		d.removeKey(key)
+		return 1, nil
+	}
+	// array elements are removed together, as removing one at a time would
+	// shift the indexes of the others
+	removed := 0
+	indexes := make(map[*jsonArray]map[int]bool)
+	for _, r := range refs {
+		switch parent := r.parent.(type) {
+		case *jsonObject:
+			if parent.delete(r.key) {
+				removed++
+			}
+		case *jsonArray:
+			if indexes[parent] == nil {
+				indexes[parent] = make(map[int]bool)
+			}
+			indexes[parent][r.index] = true
+		}
+	}
+	for a, drop := range indexes {
+		items := make([]any, 0, len(a.items)-len(drop))
+		for i, item := range a.items {
+			if !drop[i] {
+				items = append(items, item)
+			}
+		}
+		a.items = items
+		removed += len(drop)
+	}
+	if removed > 0 {
+		d.resizeJSON(key, doc)
+	}
+	return removed, nil
+}
+
+// JSONType returns the type names of the values at path in the document at
+// key and whether the key exists.
+func JSONType(db int, key string, path string) ([]string, bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	doc, ok, err := d.jsonForRead(key)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+	refs := p.eval(doc.root)
+	types := make([]string, len(refs))
+	for i, r := range refs {
+		types[i] = jsonTypeName(r.value)
+	}
+	return types, true, nil
+}
+
+// JSONNumIncrBy adds incr to the numbers at path in the document at key and
+// returns their new values as JSON, with ok[i] false for matches that are
+// not numbers.
+func JSONNumIncrBy(db int, key string, path string, incr string) ([]string, []bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	by, err := parseJSON(incr)
+	if _, isNumber := jsonFloat(by); err != nil || !isNumber {
+		return nil, nil, ErrJSONNotNumber
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	doc, exists, err := d.jsonForWrite(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return nil, nil, ErrJSONNoKey
+	}
+	refs := p.eval(doc.root)
+	values := make([]any, len(refs))
+	ok := make([]bool, len(refs))
+	for i, r := range refs {
+		a, isNumber := jsonFloat(r.value)
+		if !isNumber {
+			continue
+		}
+		x, xInt := r.value.(int64)
+		y, yInt := by.(int64)
+		if sum := x + y; xInt && yInt && (sum > x) == (y > 0) {
+			values[i] = sum
+		} else {
+			b, _ := jsonFloat(by)
+			if math.IsInf(a+b, 0) || math.IsNaN(a+b) {
+				return nil, nil, ErrJSONNumberSize
+			}
+			values[i] = a + b
+		}
+		ok[i] = true
+	}
+	response := make([]string, len(refs))
+	for i, r := range refs {
+		if ok[i] {
+			doc.replace(r, values[i])
+			response[i] = JSONFormat{}.format(values[i])
+		}
+	}
+	d.resizeJSON(key, doc)
+	return response, ok, nil
+}
+
+// JSONArrAppend appends values to the arrays at path in the document at key
+// and returns their new lengths, with ok[i] false for matches that are not
+// arrays.
+func JSONArrAppend(db int, key string, path string, values []string) ([]int, []bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	items := make([]any, len(values))
+	for i, value := range values {
+		if items[i], err = parseJSON(value); err != nil {
+			return nil, nil, err
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	doc, exists, err := d.jsonForWrite(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return nil, nil, ErrJSONNoKey
+	}
+	refs := p.eval(doc.root)
+	lengths := make([]int, len(refs))
+	ok := make([]bool, len(refs))
+	appended := false
+	for i, r := range refs {
+		a, isArray := r.value.(*jsonArray)
+		if !isArray {
+			continue
+		}
+		for _, item := range items {
+			if appended {
+				item = cloneJSON(item)
+			}
+			a.items = append(a.items, item)
+		}
+		appended = true
+		lengths[i], ok[i] = len(a.items), true
+	}
+	if appended {
+		d.resizeJSON(key, doc)
+	}
+	return lengths, ok, nil
+}
+
+// JSONObjKeys returns the member names of the objects at path in the
+// document at key, with ok[i] false for matches that are not objects, and
+// whether the key exists.
+func JSONObjKeys(db int, key string, path string) ([][]string, []bool, bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	doc, exists, err := d.jsonForRead(key)
+	if !exists || err != nil {
+		return nil, nil, false, err
+	}
+	refs := p.eval(doc.root)
+	keys := make([][]string, len(refs))
+	ok := make([]bool, len(refs))
+	for i, r := range refs {
+		if o, isObject := r.value.(*jsonObject); isObject {
+			keys[i], ok[i] = append([]string(nil), o.keys...), true
+		}
+	}
+	return keys, ok, true, nil
+}
+
+// jsonSnapshot returns every JSON document in d as compact JSON.
+func (d *DB) jsonSnapshot() map[string]string {
+	response := make(map[string]string, len(d.JSON_data))
+	for key, doc := range d.JSON_data {
+		response[key] = JSONFormat{}.format(doc.root)
+	}
+	return response
+}
+
+// LoadJSON restores a JSON document. Memory accounting is left to
+// RebuildMeta.
+func LoadJSON(db int, key string, value string) error {
+	v, err := parseJSON(value)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	dbs[db].JSON_data[key] = &jsonDoc{root: v}
+	return nil
+}
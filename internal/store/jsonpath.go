@@ -0,0 +1,569 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSON paths are the subset of JSONPath RedisJSON users rely on:
+//
+//	$                 the root
+//	.name ['name']    a member of an object
+//	[2] [-1]          an element of an array, negative indexes from the end
+//	[0,2] [1:5:2]     several elements, or a slice [start:end:step]
+//	.* [*]            every member or element
+//	..name ..*        recursive descent
+//	[?(@.a > 1)]      members or elements a filter holds for: relative paths
+//	                  and literals compared with == != < <= > >=, or a lone
+//	                  relative path for existence, joined with && and ||
+//
+// Paths that do not start with $ use the legacy syntax of RedisJSON 1
+// (".a.b", "a[0]", "." for the root). They are evaluated the same way, but
+// commands reply with a single value instead of an array of matches.
+
+const (
+	selectName = iota
+	selectIndex
+	selectSlice
+)
+
+type jsonSelector struct {
+	kind             int
+	name             string
+	index            int
+	start, end, step int
+	hasStart, hasEnd bool
+}
+
+type jsonSegment struct {
+	recursive bool
+	wildcard  bool
+	selectors []jsonSelector
+	filter    jsonFilter
+}
+
+type jsonPath struct {
+	text     string
+	segments []jsonSegment
+	legacy   bool
+}
+
+// jsonOperand is one side of a filter comparison: a path relative to the
+// filtered value, or a literal.
+type jsonOperand struct {
+	path    *jsonPath
+	literal any
+}
+
+type jsonComparison struct {
+	left, right jsonOperand
+	op          string // "" tests that left exists
+}
+
+// jsonFilter is a disjunction of conjunctions of comparisons, nil when the
+// segment has no filter.
+type jsonFilter [][]jsonComparison
+
+// jsonRef is a value matched by a path along with where it lives, so it can
+// be replaced or removed: parent is nil for the root, otherwise the
+// *jsonObject or *jsonArray holding it under key or index.
+type jsonRef struct {
+	value  any
+	parent any
+	key    string
+	index  int
+}
+
+// LegacyJSONPath reports whether path uses the legacy syntax.
+func LegacyJSONPath(path string) bool {
+	return !strings.HasPrefix(path, "$")
+}
+
+type pathParser struct {
+	s   string
+	pos int
+}
+
+func (p *pathParser) errorf() error {
+	return fmt.Errorf("invalid JSONPath %q at position %d", p.s, p.pos)
+}
+
+func (p *pathParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *pathParser) skipSpaces() {
+	for p.peek() == ' ' {
+		p.pos++
+	}
+}
+
+func parseJSONPath(text string) (*jsonPath, error) {
+	s := text
+	legacy := LegacyJSONPath(text)
+	if legacy {
+		switch {
+		case s == "" || s == ".":
+			s = "$"
+		case s[0] == '.' || s[0] == '[':
+			s = "$" + s
+		default:
+			s = "$." + s
+		}
+	}
+	p := &pathParser{s: s, pos: 1}
+	segments, err := p.segments(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.s) {
+		return nil, p.errorf()
+	}
+	return &jsonPath{text: text, segments: segments, legacy: legacy}, nil
+}
+
+// segments parses the segments following $ or @. Inside a filter it stops
+// at the first character that cannot continue the path.
+func (p *pathParser) segments(inFilter bool) ([]jsonSegment, error) {
+	segments := make([]jsonSegment, 0)
+	for p.pos < len(p.s) {
+		var seg jsonSegment
+		var err error
+		switch p.peek() {
+		case '.':
+			p.pos++
+			if p.peek() == '.' {
+				p.pos++
+				seg.recursive = true
+			}
+			if seg.recursive && p.peek() == '[' {
+				err = p.bracket(&seg)
+			} else {
+				err = p.name(&seg, inFilter)
+			}
+		case '[':
+			err = p.bracket(&seg)
+		default:
+			if inFilter {
+				return segments, nil
+			}
+			return nil, p.errorf()
+		}
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+// name parses the member name or * after a dot.
+func (p *pathParser) name(seg *jsonSegment, inFilter bool) error {
+	if p.peek() == '*' {
+		p.pos++
+		seg.wildcard = true
+		return nil
+	}
+	start := p.pos
+	stop := ".["
+	if inFilter {
+		stop = ".[ )=!<>&|"
+	}
+	for p.pos < len(p.s) && !strings.ContainsRune(stop, rune(p.s[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		return p.errorf()
+	}
+	seg.selectors = []jsonSelector{{kind: selectName, name: p.s[start:p.pos]}}
+	return nil
+}
+
+// bracket parses [*], [?(filter)] or a comma separated list of names,
+// indexes and slices.
+func (p *pathParser) bracket(seg *jsonSegment) error {
+	p.pos++
+	p.skipSpaces()
+	switch p.peek() {
+	case '*':
+		p.pos++
+		seg.wildcard = true
+	case '?':
+		p.pos++
+		p.skipSpaces()
+		if p.peek() != '(' {
+			return p.errorf()
+		}
+		p.pos++
+		filter, err := p.filter()
+		if err != nil {
+			return err
+		}
+		p.skipSpaces()
+		if p.peek() != ')' {
+			return p.errorf()
+		}
+		p.pos++
+		seg.filter = filter
+	default:
+		for {
+			p.skipSpaces()
+			sel, err := p.selector()
+			if err != nil {
+				return err
+			}
+			seg.selectors = append(seg.selectors, sel)
+			p.skipSpaces()
+			if p.peek() != ',' {
+				break
+			}
+			p.pos++
+		}
+	}
+	p.skipSpaces()
+	if p.peek() != ']' {
+		return p.errorf()
+	}
+	p.pos++
+	return nil
+}
+
+func (p *pathParser) selector() (jsonSelector, error) {
+	if c := p.peek(); c == '\'' || c == '"' {
+		name, err := p.quoted()
+		return jsonSelector{kind: selectName, name: name}, err
+	}
+	var sel jsonSelector
+	n, ok := p.integer()
+	if p.peek() != ':' {
+		if !ok {
+			return sel, p.errorf()
+		}
+		return jsonSelector{kind: selectIndex, index: n}, nil
+	}
+	sel = jsonSelector{kind: selectSlice, start: n, hasStart: ok, step: 1}
+	p.pos++
+	p.skipSpaces()
+	sel.end, sel.hasEnd = p.integer()
+	p.skipSpaces()
+	if p.peek() == ':' {
+		p.pos++
+		p.skipSpaces()
+		step, ok := p.integer()
+		if ok {
+			sel.step = step
+		}
+		if sel.step <= 0 {
+			return sel, p.errorf()
+		}
+	}
+	return sel, nil
+}
+
+func (p *pathParser) integer() (int, bool) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	for p.peek() >= '0' && p.peek() <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.s[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return 0, false
+	}
+	return n, true
+}
+
+// quoted parses a string in single or double quotes, with backslash escapes.
+func (p *pathParser) quoted() (string, error) {
+	quote := p.peek()
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c == '\\' && p.pos < len(p.s):
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf()
+}
+
+func (p *pathParser) filter() (jsonFilter, error) {
+	var filter jsonFilter
+	for {
+		var and []jsonComparison
+		for {
+			c, err := p.comparison()
+			if err != nil {
+				return nil, err
+			}
+			and = append(and, c)
+			p.skipSpaces()
+			if !strings.HasPrefix(p.s[p.pos:], "&&") {
+				break
+			}
+			p.pos += 2
+		}
+		filter = append(filter, and)
+		if !strings.HasPrefix(p.s[p.pos:], "||") {
+			return filter, nil
+		}
+		p.pos += 2
+	}
+}
+
+func (p *pathParser) comparison() (jsonComparison, error) {
+	var c jsonComparison
+	var err error
+	if c.left, err = p.operand(); err != nil {
+		return c, err
+	}
+	p.skipSpaces()
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			p.pos += len(op)
+			c.op = op
+			c.right, err = p.operand()
+			return c, err
+		}
+	}
+	if c.left.path == nil {
+		return c, p.errorf()
+	}
+	return c, nil
+}
+
+func (p *pathParser) operand() (jsonOperand, error) {
+	p.skipSpaces()
+	switch c := p.peek(); {
+	case c == '@':
+		p.pos++
+		segments, err := p.segments(true)
+		return jsonOperand{path: &jsonPath{segments: segments}}, err
+	case c == '\'' || c == '"':
+		s, err := p.quoted()
+		return jsonOperand{literal: s}, err
+	}
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(" )=!<>&|", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	v, err := parseJSON(p.s[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return jsonOperand{}, p.errorf()
+	}
+	switch v.(type) {
+	case *jsonObject, *jsonArray:
+		p.pos = start
+		return jsonOperand{}, p.errorf()
+	}
+	return jsonOperand{literal: v}, nil
+}
+
+// eval returns the values path matches in root, in document order.
+func (path *jsonPath) eval(root any) []jsonRef {
+	refs := []jsonRef{{value: root}}
+	for _, seg := range path.segments {
+		next := make([]jsonRef, 0)
+		for _, r := range refs {
+			if seg.recursive {
+				for _, d := range descendants(r) {
+					next = seg.apply(d, next)
+				}
+			} else {
+				next = seg.apply(r, next)
+			}
+		}
+		refs = next
+	}
+	return refs
+}
+
+// children returns the members of an object or the elements of an array.
+func children(v any) []jsonRef {
+	switch v := v.(type) {
+	case *jsonObject:
+		refs := make([]jsonRef, len(v.keys))
+		for i, k := range v.keys {
+			refs[i] = jsonRef{value: v.values[k], parent: v, key: k}
+		}
+		return refs
+	case *jsonArray:
+		refs := make([]jsonRef, len(v.items))
+		for i, item := range v.items {
+			refs[i] = jsonRef{value: item, parent: v, index: i}
+		}
+		return refs
+	}
+	return nil
+}
+
+// descendants returns r and every value nested in it, parents first.
+func descendants(r jsonRef) []jsonRef {
+	response := []jsonRef{r}
+	for _, c := range children(r.value) {
+		response = append(response, descendants(c)...)
+	}
+	return response
+}
+
+// apply appends to out the children of r the segment selects.
+func (seg jsonSegment) apply(r jsonRef, out []jsonRef) []jsonRef {
+	if seg.wildcard {
+		return append(out, children(r.value)...)
+	}
+	if seg.filter != nil {
+		for _, c := range children(r.value) {
+			if seg.filter.holds(c.value) {
+				out = append(out, c)
+			}
+		}
+		return out
+	}
+	for _, sel := range seg.selectors {
+		switch v := r.value.(type) {
+		case *jsonObject:
+			if value, ok := v.values[sel.name]; ok && sel.kind == selectName {
+				out = append(out, jsonRef{value: value, parent: v, key: sel.name})
+			}
+		case *jsonArray:
+			n := len(v.items)
+			switch sel.kind {
+			case selectIndex:
+				i := sel.index
+				if i < 0 {
+					i += n
+				}
+				if i >= 0 && i < n {
+					out = append(out, jsonRef{value: v.items[i], parent: v, index: i})
+				}
+			case selectSlice:
+				start, end := 0, n
+				if sel.hasStart {
+					start = sel.start
+				}
+				if sel.hasEnd {
+					end = sel.end
+				}
+				if start < 0 {
+					start += n
+				}
+				if end < 0 {
+					end += n
+				}
+				for i := max(start, 0); i < min(end, n); i += sel.step {
+					out = append(out, jsonRef{value: v.items[i], parent: v, index: i})
+				}
+			}
+		}
+	}
+	return out
+}
+
+func (f jsonFilter) holds(v any) bool {
+	for _, and := range f {
+		all := true
+		for _, c := range and {
+			if !c.holds(v) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the value of the operand for the filtered value v.
+func (o jsonOperand) resolve(v any) (any, bool) {
+	if o.path == nil {
+		return o.literal, true
+	}
+	refs := o.path.eval(v)
+	if len(refs) == 0 {
+		return nil, false
+	}
+	return refs[0].value, true
+}
+
+func (c jsonComparison) holds(v any) bool {
+	left, ok := c.left.resolve(v)
+	if !ok {
+		return false
+	}
+	if c.op == "" {
+		return true
+	}
+	right, ok := c.right.resolve(v)
+	if !ok {
+		return false
+	}
+	order, comparable := 0, true
+	lf, lnum := jsonFloat(left)
+	rf, rnum := jsonFloat(right)
+	ls, lstr := left.(string)
+	rs, rstr := right.(string)
+	switch {
+	case lnum && rnum:
+		order = cmpFloat(lf, rf)
+	case lstr && rstr:
+		order = strings.Compare(ls, rs)
+	default:
+		comparable = false
+	}
+	switch c.op {
+	case "==":
+		return (comparable && order == 0) || (!comparable && scalarEqual(left, right))
+	case "!=":
+		return !((comparable && order == 0) || (!comparable && scalarEqual(left, right)))
+	case "<":
+		return comparable && order < 0
+	case "<=":
+		return comparable && order <= 0
+	case ">":
+		return comparable && order > 0
+	case ">=":
+		return comparable && order >= 0
+	}
+	return false
+}
+
+func cmpFloat(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// scalarEqual compares booleans and nulls; objects and arrays are never
+// equal to anything in a filter.
+func scalarEqual(a any, b any) bool {
+	switch a.(type) {
+	case *jsonObject, *jsonArray:
+		return false
+	}
+	switch b.(type) {
+	case *jsonObject, *jsonArray:
+		return false
+	}
+	return a == b
+}
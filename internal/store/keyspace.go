@@ -0,0 +1,320 @@
+package store
+
+import (
+	"errors"
+	"maps"
+)
+
+// Keyspace Functions (iteration over keys of every type)
+
+// Keys returns every live key matching pattern.
+func Keys(db int, pattern string) []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	response := make([]string, 0)
+	for key := range d.meta.all() {
+		if _, ok := d.liveMeta(key); ok && MatchPattern(pattern, key) {
+			response = append(response, key)
+		}
+	}
+	return response
+}
+
+// DBSize returns the number of keys, including expired keys that have not
+// been reclaimed yet.
+func DBSize(db int) int {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	return d.meta.len()
+}
+
+// Expires returns the number of keys with a TTL in database db.
+func Expires(db int) int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(dbs[db].Expiry)
+}
+
+// RandomKey returns a random live key.
+func RandomKey(db int) (string, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	// give up after a few tries when most keys are expired
+	for i := 0; i < 100; i++ {
+		e, ok := d.meta.random()
+		if !ok {
+			return "", false
+		}
+		if _, ok := d.liveMeta(e.key); ok {
+			return e.key, true
+		}
+	}
+	return "", false
+}
+
+// Scan walks the keyspace from cursor, visiting buckets until at least count
+// keys were seen, and returns the next cursor (0 when done) with the keys
+// that match pattern and typ. An empty pattern or typ matches everything.
+// Every key that exists for the whole iteration is returned at least once.
+func Scan(db int, cursor uint64, count int, pattern string, typ string) (uint64, []string) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	seen := make([]string, 0, count)
+	cursor = scanDict(d.meta, cursor, count, func(key string, _ *keyMeta) {
+		seen = append(seen, key)
+	})
+
+	response := make([]string, 0, len(seen))
+	for _, key := range seen {
+		if _, ok := d.liveMeta(key); !ok {
+			continue
+		}
+		if pattern != "" && !MatchPattern(pattern, key) {
+			continue
+		}
+		if typ != "" && d.keyType(key) != typ {
+			continue
+		}
+		response = append(response, key)
+	}
+	return cursor, response
+}
+
+// Type returns the data type stored at key, "none" if it does not exist.
+func Type(db int, key string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	if _, ok := d.liveMeta(key); !ok {
+		return "none"
+	}
+	return d.keyType(key)
+}
+
+// Rename moves src to dst with its TTL and access metadata, overwriting dst.
+// With nx set nothing happens when dst already exists. It returns whether src
+// exists and whether it was renamed.
+func Rename(db int, src string, dst string, nx bool) (bool, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	if _, ok := d.liveMeta(src); !ok {
+		return false, false
+	}
+	if _, exists := d.liveMeta(dst); exists && nx {
+		return true, false
+	}
+	if src == dst {
+		return true, !nx
+	}
+	d.removeKey(dst)
+	moveKey(d, src, d, dst)
+	return true, true
+}
+
+// Move moves key to database dstDB unless it already exists there.
+func Move(db int, key string, dstDB int) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	from, to := dbs[db], dbs[dstDB]
+	if _, ok := from.liveMeta(key); !ok {
+		return false
+	}
+	if _, exists := to.liveMeta(key); exists {
+		return false
+	}
+	to.removeKey(key)
+	moveKey(from, key, to, key)
+	return true
+}
+
+// moveKey transfers every value, the TTL and the metadata of src in from to
+// dst in to. dst must not exist. Callers must hold the write lock.
+func moveKey(from *DB, src string, to *DB, dst string) {
+	if v, ok := from.Redis_data[src]; ok {
+		to.Redis_data[dst] = v
+	}
+	if v, ok := from.List_data[src]; ok {
+		to.List_data[dst] = v
+	}
+	if v, ok := from.Hash_data[src]; ok {
+		to.Hash_data[dst] = v
+	}
+	if v, ok := from.Hash_expiry[src]; ok {
+		to.Hash_expiry[dst] = v
+	}
+	if v, ok := from.Set_data[src]; ok {
+		to.Set_data[dst] = v
+	}
+	if v, ok := from.ZSet_data[src]; ok {
+		to.ZSet_data[dst] = v
+	}
+	if v, ok := from.Stream_data[src]; ok {
+		to.Stream_data[dst] = v
+	}
+	if v, ok := from.JSON_data[src]; ok {
+		to.JSON_data[dst] = v
+	}
+	if v, ok := from.Bloom_data[src]; ok {
+		to.Bloom_data[dst] = v
+	}
+	if v, ok := from.CMS_data[src]; ok {
+		to.CMS_data[dst] = v
+	}
+	if v, ok := from.TS_data[src]; ok {
+		to.TS_data[dst] = v
+	}
+	if v, ok := from.Expiry[src]; ok {
+		to.Expiry[dst] = v
+	}
+	delete(from.Redis_data, src)
+	delete(from.List_data, src)
+	delete(from.Hash_data, src)
+	delete(from.Hash_expiry, src)
+	delete(from.Set_data, src)
+	delete(from.ZSet_data, src)
+	delete(from.Stream_data, src)
+	delete(from.JSON_data, src)
+	delete(from.Bloom_data, src)
+	delete(from.CMS_data, src)
+	delete(from.TS_data, src)
+	delete(from.Expiry, src)
+
+	m, _ := from.meta.get(src)
+	from.meta.delete(src)
+	to.meta.set(dst, m)
+	grow(m, len(dst)-len(src))
+	from.signalKey(src)
+	to.signalKey(dst)
+}
+
+// ErrSameObject is returned by Copy when src and dst are the same key.
+var ErrSameObject = errors.New("source and destination objects are the same")
+
+// Copy duplicates the value and TTL of src into dst in database dstDB.
+// Without replace an existing dst is left alone and false is returned.
+func Copy(db int, src string, dstDB int, dst string, replace bool) (bool, error) {
+	if db == dstDB && src == dst {
+		return false, ErrSameObject
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	from, to := dbs[db], dbs[dstDB]
+	m, ok := from.liveMeta(src)
+	if !ok {
+		return false, nil
+	}
+	if _, exists := to.liveMeta(dst); exists {
+		if !replace {
+			return false, nil
+		}
+	}
+	to.removeKey(dst)
+
+	if v, ok := from.Redis_data[src]; ok {
+		to.Redis_data[dst] = v
+	}
+	if v, ok := from.List_data[src]; ok {
+		to.List_data[dst] = v.clone()
+	}
+	if v, ok := from.Hash_data[src]; ok {
+		to.Hash_data[dst] = v.clone()
+	}
+	if v, ok := from.Hash_expiry[src]; ok {
+		to.Hash_expiry[dst] = maps.Clone(v)
+	}
+	if v, ok := from.Set_data[src]; ok {
+		to.Set_data[dst] = v.clone()
+	}
+	if v, ok := from.ZSet_data[src]; ok {
+		to.ZSet_data[dst] = v.clone()
+	}
+	if v, ok := from.Stream_data[src]; ok {
+		to.Stream_data[dst] = v.clone()
+	}
+	if v, ok := from.JSON_data[src]; ok {
+		to.JSON_data[dst] = v.clone()
+	}
+	if v, ok := from.Bloom_data[src]; ok {
+		to.Bloom_data[dst] = v.clone()
+	}
+	if v, ok := from.CMS_data[src]; ok {
+		to.CMS_data[dst] = v.clone()
+	}
+	if v, ok := from.TS_data[src]; ok {
+		to.TS_data[dst] = v.clone()
+	}
+	if v, ok := from.Expiry[src]; ok {
+		to.Expiry[dst] = v
+	}
+
+	c := to.writeKey(dst)
+	grow(c, int(m.size)-(keyOverhead+len(src)))
+	to.signalKey(dst)
+	return true, nil
+}
+
+// SwapDB exchanges the contents of two databases. Clients that selected one
+// of them see the other one's data right away.
+func SwapDB(a int, b int) {
+	mu.Lock()
+	defer mu.Unlock()
+	dbs[a], dbs[b] = dbs[b], dbs[a]
+	signalDB(dbs[a])
+	signalDB(dbs[b])
+}
+
+// FlushDB removes every key of database db. The old keyspace is detached in
+// constant time; with async set its memory accounting is settled in the
+// background instead of while holding the lock.
+func FlushDB(db int, async bool) {
+	mu.Lock()
+	old := dbs[db]
+	dbs[db] = newDB()
+	signalDB(old)
+	if async {
+		mu.Unlock()
+		go releaseDB(old)
+		return
+	}
+	releaseDB(old)
+	mu.Unlock()
+}
+
+// FlushAll removes every key of every database, see FlushDB.
+func FlushAll(async bool) {
+	mu.Lock()
+	old := dbs
+	dbs = make([]*DB, len(old))
+	for i := range dbs {
+		dbs[i] = newDB()
+		signalDB(old[i])
+	}
+	if async {
+		mu.Unlock()
+		go func() {
+			for _, d := range old {
+				releaseDB(d)
+			}
+		}()
+		return
+	}
+	for _, d := range old {
+		releaseDB(d)
+	}
+	mu.Unlock()
+}
+
+// releaseDB subtracts the memory of a detached database from the total. The
+// database must no longer be reachable from dbs.
+func releaseDB(d *DB) {
+	var size int64
+	for _, m := range d.meta.all() {
+		size += m.size
+	}
+	usedMemory.Add(-size)
+}
@@ -0,0 +1,22 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCopySameKey(t *testing.T) {
+	Init(2)
+	Set(0, "k", "v")
+	for _, replace := range []bool{false, true} {
+		if _, err := Copy(0, "k", 0, "k", replace); !errors.Is(err, ErrSameObject) {
+			t.Fatalf("COPY k k (replace %v) = %v, want ErrSameObject", replace, err)
+		}
+	}
+	if _, err := Copy(0, "missing", 0, "missing", false); !errors.Is(err, ErrSameObject) {
+		t.Fatalf("COPY of a missing key onto itself = %v, want ErrSameObject", err)
+	}
+	if copied, err := Copy(0, "k", 1, "k", false); err != nil || !copied {
+		t.Fatalf("COPY k k DB 1 = %v, %v, want it copied", copied, err)
+	}
+}
@@ -0,0 +1,383 @@
+package store
+
+import (
+	"iter"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+
+	"litekv/internal/config"
+)
+
+// Small hashes and sets use compact encodings, like Redis' listpack and
+// intset: a flat slice searched linearly costs far less memory than a dict
+// for a handful of entries, and is as fast at that size. A collection is
+// converted to a dict once it outgrows the thresholds set in the config, and
+// never converted back.
+
+// hashObject is the hash value type. As a listpack, lp holds alternating
+// fields and values; as a hashtable, ht holds the fields.
+type hashObject struct {
+	lp []string
+	ht *dict[string]
+}
+
+func newHashObject() *hashObject {
+	return &hashObject{}
+}
+
+// compact reports whether h is still a listpack.
+func (h *hashObject) compact() bool {
+	return h.ht == nil
+}
+
+func (h *hashObject) encoding() string {
+	if h.compact() {
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+func (h *hashObject) len() int {
+	if h.compact() {
+		return len(h.lp) / 2
+	}
+	return h.ht.len()
+}
+
+// find returns the position of field in lp, -1 if it is missing.
+func (h *hashObject) find(field string) int {
+	for i := 0; i < len(h.lp); i += 2 {
+		if h.lp[i] == field {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *hashObject) get(field string) (string, bool) {
+	if !h.compact() {
+		return h.ht.get(field)
+	}
+	if i := h.find(field); i >= 0 {
+		return h.lp[i+1], true
+	}
+	return "", false
+}
+
+// fits reports whether setting field to value keeps h a listpack.
+func (h *hashObject) fits(field string, value string) bool {
+	limit := config.HashMaxListpackValue()
+	if len(field) > limit || len(value) > limit {
+		return false
+	}
+	return h.len() < config.HashMaxListpackEntries() || h.find(field) >= 0
+}
+
+// convert turns a listpack into a hashtable.
+func (h *hashObject) convert() {
+	h.ht = newDict[string]()
+	for i := 0; i < len(h.lp); i += 2 {
+		h.ht.set(h.lp[i], h.lp[i+1])
+	}
+	h.lp = nil
+}
+
+// set stores value under field and reports whether the field is new. It
+// does not convert h, see fits.
+func (h *hashObject) set(field string, value string) bool {
+	if !h.compact() {
+		return h.ht.set(field, value)
+	}
+	if i := h.find(field); i >= 0 {
+		h.lp[i+1] = value
+		return false
+	}
+	h.lp = append(h.lp, field, value)
+	return true
+}
+
+func (h *hashObject) delete(field string) bool {
+	if !h.compact() {
+		return h.ht.delete(field)
+	}
+	i := h.find(field)
+	if i < 0 {
+		return false
+	}
+	h.lp = slices.Delete(h.lp, i, i+2)
+	return true
+}
+
+func (h *hashObject) all() iter.Seq2[string, string] {
+	if !h.compact() {
+		return h.ht.all()
+	}
+	return func(yield func(string, string) bool) {
+		for i := 0; i < len(h.lp); i += 2 {
+			if !yield(h.lp[i], h.lp[i+1]) {
+				return
+			}
+		}
+	}
+}
+
+func (h *hashObject) random() (dictEntry[string], bool) {
+	if !h.compact() {
+		return h.ht.random()
+	}
+	if len(h.lp) == 0 {
+		return dictEntry[string]{}, false
+	}
+	i := rand.IntN(len(h.lp)/2) * 2
+	return dictEntry[string]{key: h.lp[i], value: h.lp[i+1]}, true
+}
+
+// scan is scanDict for hashes. A listpack is returned whole in one call,
+// as Redis does.
+func (h *hashObject) scan(cursor uint64, count int, fn func(string, string)) uint64 {
+	if !h.compact() {
+		return scanDict(h.ht, cursor, count, fn)
+	}
+	for f, v := range h.all() {
+		fn(f, v)
+	}
+	return 0
+}
+
+func (h *hashObject) clone() *hashObject {
+	if !h.compact() {
+		return &hashObject{ht: h.ht.clone()}
+	}
+	return &hashObject{lp: slices.Clone(h.lp)}
+}
+
+// entrySize is the memory attributed to one field in the current encoding.
+func (h *hashObject) entrySize(field string, value string) int {
+	if h.compact() {
+		return 2*listpackOverhead + len(field) + len(value)
+	}
+	return fieldOverhead + len(field) + len(value)
+}
+
+// setObject is the set value type. A set of integers starts as an intset,
+// the sorted ints; other small sets are a listpack, the members in lp.
+// Larger sets are a hashtable, ht.
+type setObject struct {
+	ints []int64
+	lp   []string
+	ht   *dict[struct{}]
+}
+
+// newSetObject returns an empty set in the encoding suited to its first
+// member.
+func newSetObject(first string) *setObject {
+	if _, ok := intMember(first); ok {
+		return &setObject{}
+	}
+	return &setObject{lp: make([]string, 0, 1)}
+}
+
+// intMember parses member if it is an integer in canonical form, the only
+// members an intset can hold.
+func intMember(member string) (int64, bool) {
+	n, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != member {
+		return 0, false
+	}
+	return n, true
+}
+
+func (s *setObject) encoding() string {
+	switch {
+	case s.ht != nil:
+		return "hashtable"
+	case s.lp != nil:
+		return "listpack"
+	}
+	return "intset"
+}
+
+func (s *setObject) len() int {
+	switch {
+	case s.ht != nil:
+		return s.ht.len()
+	case s.lp != nil:
+		return len(s.lp)
+	}
+	return len(s.ints)
+}
+
+func (s *setObject) has(member string) bool {
+	switch {
+	case s.ht != nil:
+		_, ok := s.ht.get(member)
+		return ok
+	case s.lp != nil:
+		return slices.Contains(s.lp, member)
+	}
+	n, ok := intMember(member)
+	if !ok {
+		return false
+	}
+	_, found := slices.BinarySearch(s.ints, n)
+	return found
+}
+
+// fits reports whether adding member, which is not in s yet, keeps the
+// current encoding.
+func (s *setObject) fits(member string) bool {
+	switch {
+	case s.ht != nil:
+		return true
+	case s.lp != nil:
+		return len(s.lp) < config.SetMaxListpackEntries() && len(member) <= config.SetMaxListpackValue()
+	}
+	_, ok := intMember(member)
+	return ok && len(s.ints) < config.SetMaxIntsetEntries()
+}
+
+// convert moves s to the next encoding able to hold member as well: an
+// intset becomes a listpack if it is small enough, anything else a
+// hashtable.
+func (s *setObject) convert(member string) {
+	if s.encoding() == "intset" {
+		_, isInt := intMember(member)
+		if !isInt && len(s.ints) < config.SetMaxListpackEntries() && len(member) <= config.SetMaxListpackValue() {
+			lp := make([]string, 0, len(s.ints)+1)
+			for m := range s.all() {
+				if len(m) > config.SetMaxListpackValue() {
+					lp = nil
+					break
+				}
+				lp = append(lp, m)
+			}
+			if lp != nil {
+				s.lp, s.ints = lp, nil
+				return
+			}
+		}
+	}
+	ht := newDict[struct{}]()
+	for m := range s.all() {
+		ht.set(m, struct{}{})
+	}
+	s.ht, s.lp, s.ints = ht, nil, nil
+}
+
+// add adds member and reports whether it is new. It does not convert s, see
+// fits.
+func (s *setObject) add(member string) bool {
+	switch {
+	case s.ht != nil:
+		return s.ht.set(member, struct{}{})
+	case s.lp != nil:
+		if slices.Contains(s.lp, member) {
+			return false
+		}
+		s.lp = append(s.lp, member)
+		return true
+	}
+	n, _ := intMember(member)
+	i, found := slices.BinarySearch(s.ints, n)
+	if found {
+		return false
+	}
+	s.ints = slices.Insert(s.ints, i, n)
+	return true
+}
+
+func (s *setObject) delete(member string) bool {
+	switch {
+	case s.ht != nil:
+		return s.ht.delete(member)
+	case s.lp != nil:
+		i := slices.Index(s.lp, member)
+		if i < 0 {
+			return false
+		}
+		last := len(s.lp) - 1
+		s.lp[i] = s.lp[last]
+		s.lp[last] = ""
+		s.lp = s.lp[:last]
+		return true
+	}
+	n, ok := intMember(member)
+	if !ok {
+		return false
+	}
+	i, found := slices.BinarySearch(s.ints, n)
+	if !found {
+		return false
+	}
+	s.ints = slices.Delete(s.ints, i, i+1)
+	return true
+}
+
+func (s *setObject) all() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		switch {
+		case s.ht != nil:
+			for m := range s.ht.all() {
+				if !yield(m) {
+					return
+				}
+			}
+		case s.lp != nil:
+			for _, m := range s.lp {
+				if !yield(m) {
+					return
+				}
+			}
+		default:
+			for _, n := range s.ints {
+				if !yield(strconv.FormatInt(n, 10)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *setObject) random() (string, bool) {
+	switch {
+	case s.len() == 0:
+		return "", false
+	case s.ht != nil:
+		e, ok := s.ht.random()
+		return e.key, ok
+	case s.lp != nil:
+		return s.lp[rand.IntN(len(s.lp))], true
+	}
+	return strconv.FormatInt(s.ints[rand.IntN(len(s.ints))], 10), true
+}
+
+// scan is scanDict for sets, see hashObject.scan.
+func (s *setObject) scan(cursor uint64, count int, fn func(string)) uint64 {
+	if s.ht != nil {
+		return scanDict(s.ht, cursor, count, func(m string, _ struct{}) { fn(m) })
+	}
+	for m := range s.all() {
+		fn(m)
+	}
+	return 0
+}
+
+func (s *setObject) clone() *setObject {
+	c := &setObject{ints: slices.Clone(s.ints), lp: slices.Clone(s.lp)}
+	if s.ht != nil {
+		c.ht = s.ht.clone()
+	}
+	return c
+}
+
+// entrySize is the memory attributed to one member in the current encoding.
+func (s *setObject) entrySize(member string) int {
+	switch {
+	case s.ht != nil:
+		return memberOverhead + len(member)
+	case s.lp != nil:
+		return listpackOverhead + len(member)
+	}
+	return intsetOverhead
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+
+	"litekv/internal/config"
+)
+
+// heapPerKey returns the Go heap used per key after building n keys with
+// fill, key and metadata included.
+func heapPerKey(n int, fill func(key string)) float64 {
+	var before, after runtime.MemStats
+	Init(1)
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for i := range n {
+		fill("key:" + strconv.Itoa(i))
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(dbs)
+	return float64(after.HeapAlloc-before.HeapAlloc) / float64(n)
+}
+
+// BenchmarkEncodingMemory reports the heap per key of small hashes and
+// sets with the default thresholds and with every threshold set to 0,
+// which the encoding table of the README is taken from.
+func BenchmarkEncodingMemory(b *testing.B) {
+	values := []struct {
+		name string
+		fill func(key string)
+	}{
+		{"hash-5-fields", func(key string) {
+			for i := 1; i <= 5; i++ {
+				HSet(0, key, "f"+strconv.Itoa(i), "v"+strconv.Itoa(i))
+			}
+		}},
+		{"set-10-integers", func(key string) {
+			for i := 1; i <= 10; i++ {
+				SAdd(0, key, strconv.Itoa(i))
+			}
+		}},
+		{"set-5-strings", func(key string) {
+			SAdd(0, key, "a", "b", "c", "d", "e")
+		}},
+	}
+	thresholds := []string{
+		"hash-max-listpack-entries", "hash-max-listpack-value",
+		"set-max-intset-entries", "set-max-listpack-entries", "set-max-listpack-value",
+	}
+	defaults := []int{
+		config.HashMaxListpackEntries(), config.HashMaxListpackValue(),
+		config.SetMaxIntsetEntries(), config.SetMaxListpackEntries(), config.SetMaxListpackValue(),
+	}
+	setThresholds := func(zero bool) {
+		for i, name := range thresholds {
+			value := defaults[i]
+			if zero {
+				value = 0
+			}
+			config.Set(name, strconv.Itoa(value))
+		}
+	}
+	defer setThresholds(false)
+
+	for _, v := range values {
+		for _, mode := range []string{"compact", "hashtable"} {
+			b.Run(v.name+"/"+mode, func(b *testing.B) {
+				setThresholds(mode == "hashtable")
+				var perKey float64
+				for b.Loop() {
+					perKey = heapPerKey(100000, v.fill)
+				}
+				b.ReportMetric(perKey, "B/key")
+			})
+		}
+	}
+}
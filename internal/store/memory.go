@@ -0,0 +1,206 @@
+package store
+
+import (
+	"sort"
+)
+
+// Memory Functions (estimates used by MEMORY USAGE / STATS / DOCTOR)
+
+// MemoryUsage estimates the bytes used by key, including the key itself and
+// its expiry entry. For collections at most samples elements are measured
+// and the average is extrapolated to the whole collection; samples <= 0
+// measures every element.
+func MemoryUsage(db int, key string, samples int) (int64, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	if _, ok := d.liveMeta(key); !ok {
+		return 0, false
+	}
+	size := keyOverhead + len(key)
+	if _, ok := d.Expiry[key]; ok {
+		size += expiryOverhead
+	}
+	if v, ok := d.Redis_data[key]; ok {
+		size += stringSize(v)
+	}
+	if l, ok := d.List_data[key]; ok {
+		size += sampledSize(l.len(), samples, func(yield func(int) bool) {
+			for _, v := range l.all() {
+				if !yield(elemOverhead + len(v)) {
+					return
+				}
+			}
+		})
+	}
+	if h, ok := d.Hash_data[key]; ok {
+		size += sampledSize(h.len(), samples, func(yield func(int) bool) {
+			for f, v := range h.all() {
+				if !yield(h.entrySize(f, v)) {
+					return
+				}
+			}
+		})
+		size += len(d.Hash_expiry[key]) * expiryOverhead
+	}
+	if s, ok := d.Set_data[key]; ok {
+		size += sampledSize(s.len(), samples, func(yield func(int) bool) {
+			for m := range s.all() {
+				if !yield(s.entrySize(m)) {
+					return
+				}
+			}
+		})
+	}
+	if z, ok := d.ZSet_data[key]; ok {
+		size += sampledSize(z.len(), samples, func(yield func(int) bool) {
+			for m := range z.dict.all() {
+				if !yield(zsetOverhead + len(m)) {
+					return
+				}
+			}
+		})
+	}
+	if s, ok := d.Stream_data[key]; ok {
+		// consumer groups are small next to the entries, count them fully
+		size += streamSize(&stream{groups: s.groups})
+		size += sampledSize(s.length, samples, func(yield func(int) bool) {
+			for _, node := range s.nodes {
+				for _, e := range node {
+					if !yield(entrySize(e)) {
+						return
+					}
+				}
+			}
+		})
+	}
+	if doc, ok := d.JSON_data[key]; ok {
+		size += doc.size
+	}
+	if f, ok := d.Bloom_data[key]; ok {
+		size += bloomSize(f)
+	}
+	if s, ok := d.CMS_data[key]; ok {
+		size += cmsSize(s)
+	}
+	if s, ok := d.TS_data[key]; ok {
+		size += tsSize(s)
+	}
+	return int64(size), true
+}
+
+// sampledSize sums the element sizes produced by each, stopping after
+// samples elements and scaling the result up to total elements.
+func sampledSize(total int, samples int, each func(yield func(int) bool)) int {
+	size, seen := 0, 0
+	for elem := range each {
+		size += elem
+		seen++
+		if samples > 0 && seen >= samples {
+			break
+		}
+	}
+	if seen == 0 || seen == total {
+		return size
+	}
+	return size * total / seen
+}
+
+// keyType returns the name of the data type stored at key. Callers must
+// hold the lock.
+func (d *DB) keyType(key string) string {
+	if _, ok := d.Redis_data[key]; ok {
+		return "string"
+	}
+	if _, ok := d.List_data[key]; ok {
+		return "list"
+	}
+	if _, ok := d.Hash_data[key]; ok {
+		return "hash"
+	}
+	if _, ok := d.Set_data[key]; ok {
+		return "set"
+	}
+	if _, ok := d.ZSet_data[key]; ok {
+		return "zset"
+	}
+	if _, ok := d.Stream_data[key]; ok {
+		return "stream"
+	}
+	if _, ok := d.JSON_data[key]; ok {
+		return "ReJSON-RL"
+	}
+	if _, ok := d.Bloom_data[key]; ok {
+		return "MBbloom--"
+	}
+	if _, ok := d.CMS_data[key]; ok {
+		return "CMSk-TYPE"
+	}
+	if _, ok := d.TS_data[key]; ok {
+		return "TSDB-TYPE"
+	}
+	return "none"
+}
+
+// MemStats summarises the memory attributed to the keyspace.
+type MemStats struct {
+	UsedMemory   int64
+	Keys         int
+	Expires      int
+	ExpiresBytes int64
+	BytesByType  map[string]int64
+	KeysByType   map[string]int
+}
+
+// MemoryStats walks every key of every database once to compute per type
+// totals.
+func MemoryStats() MemStats {
+	mu.RLock()
+	defer mu.RUnlock()
+	stats := MemStats{
+		UsedMemory:  usedMemory.Load(),
+		BytesByType: make(map[string]int64),
+		KeysByType:  make(map[string]int),
+	}
+	for _, d := range dbs {
+		stats.Keys += d.meta.len()
+		stats.Expires += len(d.Expiry)
+		for key, m := range d.meta.all() {
+			typ := d.keyType(key)
+			stats.BytesByType[typ] += m.size
+			stats.KeysByType[typ]++
+		}
+	}
+	stats.ExpiresBytes = int64(stats.Expires) * expiryOverhead
+	return stats
+}
+
+// BigKey is a key reported by BigKeys.
+type BigKey struct {
+	DB   int
+	Key  string
+	Type string
+	Size int64
+}
+
+// BigKeys returns up to limit keys whose estimated size is at least
+// threshold bytes, largest first.
+func BigKeys(threshold int64, limit int) []BigKey {
+	mu.RLock()
+	defer mu.RUnlock()
+	response := make([]BigKey, 0)
+	for i, d := range dbs {
+		for key, m := range d.meta.all() {
+			if m.size >= threshold {
+				response = append(response, BigKey{DB: i, Key: key, Type: d.keyType(key), Size: m.size})
+			}
+		}
+	}
+	sort.Slice(response, func(i, j int) bool {
+		return response[i].Size > response[j].Size
+	})
+	if len(response) > limit {
+		response = response[:limit]
+	}
+	return response
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// Rough per-entry overheads (in bytes) used by the memory accounting. They
+// approximate the Go map slot and string/slice headers behind each value;
+// the field and member ones were measured on the heap for dict entries.
+const (
+	keyOverhead    = 64
+	stringOverhead = 16
+	expiryOverhead = 40
+	elemOverhead   = 16
+	fieldOverhead  = 64
+	memberOverhead = 56
+
+	// compact encodings, see listpack.go
+	listpackOverhead = 16
+	intsetOverhead   = 8
+)
+
+// LFU counter parameters, same defaults as redis.conf.
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = time.Minute
+)
+
+// keyMeta is the bookkeeping kept for every key regardless of its type.
+// size is only changed under the write lock; atime and freq are bumped by
+// readers holding the read lock, so they are atomic.
+type keyMeta struct {
+	size  int64
+	atime atomic.Int64  // last access, unix milliseconds
+	freq  atomic.Uint32 // logarithmic access counter
+}
+
+var usedMemory atomic.Int64
+
+// UsedMemory returns the estimated number of bytes held by the keyspace.
+func UsedMemory() int64 {
+	return usedMemory.Load()
+}
+
+// writeKey returns the metadata for key, creating it if the key is new.
+// Callers must hold the write lock.
+func (d *DB) writeKey(key string) *keyMeta {
+	m, ok := d.meta.get(key)
+	if !ok {
+		m = &keyMeta{}
+		m.freq.Store(lfuInitVal)
+		d.meta.set(key, m)
+		grow(m, keyOverhead+len(key))
+	}
+	m.touch()
+	return m
+}
+
+// readKey records an access to key if it exists.
+func (d *DB) readKey(key string) {
+	if m, ok := d.meta.get(key); ok {
+		m.touch()
+	}
+}
+
+// grow adds delta bytes to the memory attributed to a key.
+func grow(m *keyMeta, delta int) {
+	m.size += int64(delta)
+	usedMemory.Add(int64(delta))
+}
+
+// growKey is grow for callers that only have the key name.
+func (d *DB) growKey(key string, delta int) {
+	if m, ok := d.meta.get(key); ok {
+		grow(m, delta)
+	}
+}
+
+// removeKey deletes key from every data type and wakes clients blocked on
+// it. Callers must hold the write lock.
+func (d *DB) removeKey(key string) {
+	delete(d.Redis_data, key)
+	delete(d.Expiry, key)
+	delete(d.List_data, key)
+	delete(d.Hash_data, key)
+	delete(d.Hash_expiry, key)
+	delete(d.Set_data, key)
+	delete(d.ZSet_data, key)
+	delete(d.Stream_data, key)
+	delete(d.JSON_data, key)
+	delete(d.Bloom_data, key)
+	delete(d.CMS_data, key)
+	delete(d.TS_data, key)
+	if m, ok := d.meta.get(key); ok {
+		usedMemory.Add(-m.size)
+		d.meta.delete(key)
+		d.signalKey(key)
+	}
+}
+
+// ErrWrongType is returned by commands run against a key that holds
+// another type of value.
+var ErrWrongType = errors.New("Operation against a key holding the wrong kind of value")
+
+// lookupKey reports whether key is live and holds a value of type kind, a
+// TYPE name, recording the access. It returns ErrWrongType if the key is
+// live but of another type. Callers must hold the lock.
+func (d *DB) lookupKey(key string, kind string) (bool, error) {
+	if _, live := d.liveMeta(key); !live {
+		return false, nil
+	}
+	if d.keyType(key) != kind {
+		return false, ErrWrongType
+	}
+	d.readKey(key)
+	return true, nil
+}
+
+// lookupKeyWrite is lookupKey for commands that modify or create the key:
+// an expired key is deleted first so neither its value nor its TTL carry
+// over. Callers must hold the write lock.
+func (d *DB) lookupKeyWrite(key string, kind string) (bool, error) {
+	if _, exists := d.meta.get(key); exists {
+		if _, live := d.liveMeta(key); !live {
+			d.removeKey(key)
+		}
+	}
+	return d.lookupKey(key, kind)
+}
+
+// setExpiry sets the TTL of key, accounting for the new Expiry entry.
+func (d *DB) setExpiry(key string, when time.Time) {
+	if _, ok := d.Expiry[key]; !ok {
+		d.growKey(key, expiryOverhead)
+	}
+	d.Expiry[key] = when
+}
+
+func (m *keyMeta) touch() {
+	now := time.Now().UnixMilli()
+	counter := lfuDecr(m.freq.Load(), m.atime.Load(), now)
+	m.freq.Store(lfuIncr(counter))
+	m.atime.Store(now)
+}
+
+// idle returns how long the key has not been accessed.
+func (m *keyMeta) idle() time.Duration {
+	return time.Duration(time.Now().UnixMilli()-m.atime.Load()) * time.Millisecond
+}
+
+// frequency returns the LFU counter after applying the time decay.
+func (m *keyMeta) frequency() uint32 {
+	return lfuDecr(m.freq.Load(), m.atime.Load(), time.Now().UnixMilli())
+}
+
+// lfuIncr increments the counter logarithmically: the higher it already is,
+// the less likely an access is to bump it further.
+func lfuIncr(counter uint32) uint32 {
+	if counter >= 255 {
+		return 255
+	}
+	base := float64(0)
+	if counter > lfuInitVal {
+		base = float64(counter - lfuInitVal)
+	}
+	if rand.Float64() < 1.0/(base*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecr subtracts one for every decay period elapsed since the last
+// access, so keys that stop being used cool down.
+func lfuDecr(counter uint32, last int64, now int64) uint32 {
+	periods := uint32((now - last) / lfuDecayTime.Milliseconds())
+	if last == 0 || periods == 0 {
+		return counter
+	}
+	if periods >= counter {
+		return 0
+	}
+	return counter - periods
+}
+
+func stringSize(value string) int {
+	return stringOverhead + len(value)
+}
+
+func listSize(list *quicklist) int {
+	size := 0
+	for _, v := range list.all() {
+		size += elemOverhead + len(v)
+	}
+	return size
+}
+
+func hashSize(h *inner_Hash_data) int {
+	size := 0
+	for f, v := range h.all() {
+		size += h.entrySize(f, v)
+	}
+	return size
+}
+
+func setSize(s *inner_Set_data) int {
+	size := 0
+	for m := range s.all() {
+		size += s.entrySize(m)
+	}
+	return size
+}
+
+// RebuildMeta recomputes key metadata and memory accounting from scratch.
+// It is used after the data maps are filled directly, e.g. by persistence.Load.
+func RebuildMeta() {
+	mu.Lock()
+	defer mu.Unlock()
+	usedMemory.Store(0)
+	for _, d := range dbs {
+		d.meta = newDict[*keyMeta]()
+		for k, v := range d.Redis_data {
+			grow(d.writeKey(k), stringSize(v))
+		}
+		for k, v := range d.List_data {
+			grow(d.writeKey(k), listSize(v))
+		}
+		for k, v := range d.Hash_data {
+			grow(d.writeKey(k), hashSize(v))
+		}
+		for k, v := range d.Set_data {
+			grow(d.writeKey(k), setSize(v))
+		}
+		for k, v := range d.ZSet_data {
+			grow(d.writeKey(k), zsetSize(v))
+		}
+		for k, v := range d.Stream_data {
+			grow(d.writeKey(k), streamSize(v))
+		}
+		for k, v := range d.JSON_data {
+			v.size = jsonSize(v.root)
+			grow(d.writeKey(k), v.size)
+		}
+		for k, v := range d.Bloom_data {
+			grow(d.writeKey(k), bloomSize(v))
+		}
+		for k, v := range d.CMS_data {
+			grow(d.writeKey(k), cmsSize(v))
+		}
+		for k, v := range d.TS_data {
+			grow(d.writeKey(k), tsSize(v))
+		}
+		for k := range d.Expiry {
+			d.growKey(k, expiryOverhead)
+		}
+		for k, fields := range d.Hash_expiry {
+			d.growKey(k, len(fields)*expiryOverhead)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"strconv"
+	"time"
+)
+
+// Object Functions (introspection used by OBJECT and TOUCH)
+
+// embstrLimit mirrors Redis: short strings use the embstr encoding.
+const embstrLimit = 44
+
+// liveMeta returns the metadata of key unless the key is missing or expired.
+// Callers must hold the lock.
+func (d *DB) liveMeta(key string) (*keyMeta, bool) {
+	m, ok := d.meta.get(key)
+	if !ok {
+		return nil, false
+	}
+	if exp, ok := d.Expiry[key]; ok && !exp.After(time.Now()) {
+		return nil, false
+	}
+	return m, true
+}
+
+// ObjectEncoding reports the internal encoding of key using Redis' names.
+func ObjectEncoding(db int, key string) (string, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	if _, ok := d.liveMeta(key); !ok {
+		return "", false
+	}
+	if v, ok := d.Redis_data[key]; ok {
+		if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return "int", true
+		}
+		if len(v) <= embstrLimit {
+			return "embstr", true
+		}
+		return "raw", true
+	}
+	if l, ok := d.List_data[key]; ok {
+		return l.encoding(), true
+	}
+	if h, ok := d.Hash_data[key]; ok {
+		return h.encoding(), true
+	}
+	if s, ok := d.Set_data[key]; ok {
+		return s.encoding(), true
+	}
+	if _, ok := d.ZSet_data[key]; ok {
+		return "skiplist", true
+	}
+	if _, ok := d.Stream_data[key]; ok {
+		return "stream", true
+	}
+	if _, ok := d.JSON_data[key]; ok {
+		return "raw", true
+	}
+	if _, ok := d.Bloom_data[key]; ok {
+		return "raw", true
+	}
+	if _, ok := d.CMS_data[key]; ok {
+		return "raw", true
+	}
+	if _, ok := d.TS_data[key]; ok {
+		return "raw", true
+	}
+	return "", false
+}
+
+// ObjectIdleTime returns the seconds elapsed since key was last accessed.
+func ObjectIdleTime(db int, key string) (int, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	m, ok := d.liveMeta(key)
+	if !ok {
+		return 0, false
+	}
+	return int(m.idle().Seconds()), true
+}
+
+// ObjectFreq returns the logarithmic access counter of key.
+func ObjectFreq(db int, key string) (int, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	m, ok := d.liveMeta(key)
+	if !ok {
+		return 0, false
+	}
+	return int(m.frequency()), true
+}
+
+// ObjectRefCount returns the reference count of key. Values are never
+// shared between keys, so it is always 1.
+func ObjectRefCount(db int, key string) (int, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	if _, ok := d.liveMeta(key); !ok {
+		return 0, false
+	}
+	return 1, true
+}
+
+// Touch updates the access time of the given keys without reading them and
+// returns how many of them exist.
+func Touch(db int, keys []string) int {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	count := 0
+	for _, key := range keys {
+		if m, ok := d.liveMeta(key); ok {
+			m.touch()
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,325 @@
+package store
+
+import (
+	"iter"
+	"slices"
+
+	"litekv/internal/config"
+)
+
+// quicklist is a doubly linked list of small slices, the list value type.
+// Pushes and pops at either end only touch the first or last node, so they
+// cost O(nodeSize) at worst whatever the list length, and indexed access
+// walks nodes rather than elements. Nodes are unlinked as soon as they are
+// empty so popped elements are released.
+type quicklist struct {
+	head, tail *qlNode
+	length     int
+	nodeSize   int // maximum number of elements per node
+}
+
+type qlNode struct {
+	prev, next *qlNode
+	items      []string
+}
+
+// newQuicklist returns an empty list whose nodes hold up to
+// list-max-listpack-size elements.
+func newQuicklist() *quicklist {
+	return &quicklist{nodeSize: config.ListMaxListpackSize()}
+}
+
+// encoding reports a list that fits in a single node as a listpack, as
+// Redis does for small lists.
+func (q *quicklist) encoding() string {
+	if q.head == q.tail {
+		return "listpack"
+	}
+	return "quicklist"
+}
+
+func (q *quicklist) len() int {
+	return q.length
+}
+
+func (q *quicklist) pushFront(v string) {
+	if q.head == nil || len(q.head.items) >= q.nodeSize {
+		q.linkBefore(q.head, &qlNode{items: make([]string, 0, 8)})
+	}
+	q.head.items = slices.Insert(q.head.items, 0, v)
+	q.length++
+}
+
+func (q *quicklist) pushBack(v string) {
+	if q.tail == nil || len(q.tail.items) >= q.nodeSize {
+		q.linkAfter(q.tail, &qlNode{items: make([]string, 0, 8)})
+	}
+	q.tail.items = append(q.tail.items, v)
+	q.length++
+}
+
+func (q *quicklist) popFront() string {
+	n := q.head
+	v := n.items[0]
+	n.items[0] = ""
+	n.items = n.items[1:]
+	q.length--
+	if len(n.items) == 0 {
+		q.unlink(n)
+	}
+	return v
+}
+
+func (q *quicklist) popBack() string {
+	n := q.tail
+	last := len(n.items) - 1
+	v := n.items[last]
+	n.items[last] = ""
+	n.items = n.items[:last]
+	q.length--
+	if len(n.items) == 0 {
+		q.unlink(n)
+	}
+	return v
+}
+
+// linkBefore inserts n before at, or at the tail when at is nil.
+func (q *quicklist) linkBefore(at *qlNode, n *qlNode) {
+	if at == nil {
+		q.linkAfter(q.tail, n)
+		return
+	}
+	n.prev, n.next = at.prev, at
+	if at.prev == nil {
+		q.head = n
+	} else {
+		at.prev.next = n
+	}
+	at.prev = n
+}
+
+// linkAfter inserts n after at, or at the head when at is nil.
+func (q *quicklist) linkAfter(at *qlNode, n *qlNode) {
+	if at == nil {
+		n.next = q.head
+		if q.head != nil {
+			q.head.prev = n
+		} else {
+			q.tail = n
+		}
+		q.head = n
+		return
+	}
+	n.prev, n.next = at, at.next
+	if at.next == nil {
+		q.tail = n
+	} else {
+		at.next.prev = n
+	}
+	at.next = n
+}
+
+func (q *quicklist) unlink(n *qlNode) {
+	if n.prev == nil {
+		q.head = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		q.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+	n.prev, n.next = nil, nil
+}
+
+// locate returns the node holding element i and its offset in the node,
+// walking from whichever end is closer. i must be in range.
+func (q *quicklist) locate(i int) (*qlNode, int) {
+	if i < q.length/2 {
+		for n := q.head; ; n = n.next {
+			if i < len(n.items) {
+				return n, i
+			}
+			i -= len(n.items)
+		}
+	}
+	i = q.length - 1 - i
+	for n := q.tail; ; n = n.prev {
+		if i < len(n.items) {
+			return n, len(n.items) - 1 - i
+		}
+		i -= len(n.items)
+	}
+}
+
+func (q *quicklist) index(i int) string {
+	n, j := q.locate(i)
+	return n.items[j]
+}
+
+// set replaces element i and returns the previous value.
+func (q *quicklist) set(i int, v string) string {
+	n, j := q.locate(i)
+	old := n.items[j]
+	n.items[j] = v
+	return old
+}
+
+// insert inserts v so that it becomes element i, for i in [0, len]. A full
+// node is split in two first.
+func (q *quicklist) insert(i int, v string) {
+	if i == 0 {
+		q.pushFront(v)
+		return
+	}
+	if i == q.length {
+		q.pushBack(v)
+		return
+	}
+	n, j := q.locate(i)
+	if len(n.items) >= q.nodeSize {
+		half := len(n.items) / 2
+		q.linkAfter(n, &qlNode{items: slices.Clone(n.items[half:])})
+		clear(n.items[half:])
+		n.items = n.items[:half]
+		if j >= half {
+			n, j = n.next, j-half
+		}
+	}
+	n.items = slices.Insert(n.items, j, v)
+	q.length++
+}
+
+// all yields the elements with their index from head to tail.
+func (q *quicklist) all() iter.Seq2[int, string] {
+	return func(yield func(int, string) bool) {
+		i := 0
+		for n := q.head; n != nil; n = n.next {
+			for _, v := range n.items {
+				if !yield(i, v) {
+					return
+				}
+				i++
+			}
+		}
+	}
+}
+
+// backward yields the elements with their index from tail to head.
+func (q *quicklist) backward() iter.Seq2[int, string] {
+	return func(yield func(int, string) bool) {
+		i := q.length - 1
+		for n := q.tail; n != nil; n = n.prev {
+			for j := len(n.items) - 1; j >= 0; j-- {
+				if !yield(i, n.items[j]) {
+					return
+				}
+				i--
+			}
+		}
+	}
+}
+
+// slice returns a copy of elements start to stop inclusive, which must be
+// in range.
+func (q *quicklist) slice(start int, stop int) []string {
+	response := make([]string, 0, stop-start+1)
+	n, j := q.locate(start)
+	for ; n != nil && len(response) < cap(response); n, j = n.next, 0 {
+		take := min(len(n.items)-j, cap(response)-len(response))
+		response = append(response, n.items[j:j+take]...)
+	}
+	return response
+}
+
+// removeValue removes elements equal to value, at most limit of them unless
+// limit is 0, scanning from the tail when fromTail is set. It returns how
+// many were removed.
+func (q *quicklist) removeValue(value string, limit int, fromTail bool) int {
+	removed := 0
+	n := q.head
+	if fromTail {
+		n = q.tail
+	}
+	for n != nil && (limit == 0 || removed < limit) {
+		next := n.next
+		if fromTail {
+			next = n.prev
+		}
+		kept := make([]string, 0, len(n.items))
+		for j := range n.items {
+			if fromTail {
+				j = len(n.items) - 1 - j
+			}
+			if n.items[j] == value && (limit == 0 || removed < limit) {
+				removed++
+				continue
+			}
+			kept = append(kept, n.items[j])
+		}
+		if fromTail {
+			slices.Reverse(kept)
+		}
+		n.items = kept
+		if len(kept) == 0 {
+			q.unlink(n)
+		}
+		n = next
+	}
+	q.length -= removed
+	return removed
+}
+
+// dropFront removes the first count elements and returns their size.
+func (q *quicklist) dropFront(count int) int {
+	size := 0
+	for count > 0 {
+		n := q.head
+		take := min(count, len(n.items))
+		for _, v := range n.items[:take] {
+			size += elemOverhead + len(v)
+		}
+		count -= take
+		q.length -= take
+		if take == len(n.items) {
+			q.unlink(n)
+			continue
+		}
+		clear(n.items[:take])
+		n.items = n.items[take:]
+	}
+	return size
+}
+
+// dropBack removes the last count elements and returns their size.
+func (q *quicklist) dropBack(count int) int {
+	size := 0
+	for count > 0 {
+		n := q.tail
+		take := min(count, len(n.items))
+		keep := len(n.items) - take
+		for _, v := range n.items[keep:] {
+			size += elemOverhead + len(v)
+		}
+		count -= take
+		q.length -= take
+		if keep == 0 {
+			q.unlink(n)
+			continue
+		}
+		clear(n.items[keep:])
+		n.items = n.items[:keep]
+	}
+	return size
+}
+
+// clone returns a deep copy, used by COPY.
+func (q *quicklist) clone() *quicklist {
+	c := &quicklist{nodeSize: q.nodeSize}
+	for n := q.head; n != nil; n = n.next {
+		c.linkAfter(c.tail, &qlNode{items: slices.Clone(n.items)})
+	}
+	c.length = q.length
+	return c
+}
@@ -0,0 +1,365 @@
+package store
+
+import (
+	"math/rand/v2"
+	"slices"
+)
+
+// setForRead returns the set at key if it exists and is live.
+func (d *DB) setForRead(key string) (*inner_Set_data, bool, error) {
+	if ok, err := d.lookupKey(key, "set"); !ok {
+		return nil, false, err
+	}
+	return d.Set_data[key], true, nil
+}
+
+// setForWrite returns the set at key, or nil if the key is missing,
+// dropping it first if it expired. Callers must hold the write lock.
+func (d *DB) setForWrite(key string) (*inner_Set_data, error) {
+	if ok, err := d.lookupKeyWrite(key, "set"); !ok {
+		return nil, err
+	}
+	return d.Set_data[key], nil
+}
+
+// setAdd adds member to the set at key, creating it if needed, and reports
+// whether it is new. Callers must hold the write lock.
+func (d *DB) setAdd(key string, member string) (bool, error) {
+	s, err := d.setForWrite(key)
+	if err != nil {
+		return false, err
+	}
+	if s == nil {
+		s = newSetObject(member)
+		d.Set_data[key] = s
+	}
+	if s.has(member) {
+		return false, nil
+	}
+	m := d.writeKey(key)
+	if !s.fits(member) {
+		before := setSize(s)
+		s.convert(member)
+		grow(m, setSize(s)-before)
+	}
+	s.add(member)
+	grow(m, s.entrySize(member))
+	return true, nil
+}
+
+// setRemove removes member from the set at key and deletes the set once it
+// is empty. Callers must hold the write lock.
+func (d *DB) setRemove(key string, member string) bool {
+	s, ok := d.Set_data[key]
+	if !ok || !s.has(member) {
+		return false
+	}
+	d.growKey(key, -s.entrySize(member))
+	s.delete(member)
+	if s.len() == 0 {
+		d.removeKey(key)
+	}
+	return true
+}
+
+// SAdd adds members and returns how many were not in the set yet.
+func SAdd(db int, key string, members ...string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	added := 0
+	for _, member := range members {
+		ok, err := d.setAdd(key, member)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			added++
+		}
+	}
+	return added, nil
+}
+
+// SRem removes members and returns how many were in the set. The set is
+// deleted once empty.
+func SRem(db int, key string, members ...string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	if s, err := d.setForWrite(key); s == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if d.setRemove(key, member) {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func SMembers(db int, key string) ([]string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	response := make([]string, 0)
+	s, ok, err := d.setForRead(key)
+	if !ok {
+		return response, err
+	}
+	for value := range s.all() {
+		response = append(response, value)
+	}
+	return response, nil
+}
+
+func SIsMember(db int, key string, member string) (int, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	s, ok, err := d.setForRead(key)
+	if !ok {
+		return 0, err
+	}
+	if s.has(member) {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// SMIsMember is SIsMember for several members at once.
+func SMIsMember(db int, key string, members []string) ([]int, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	response := make([]int, len(members))
+	s, ok, err := d.setForRead(key)
+	if !ok {
+		return response, err
+	}
+	for i, member := range members {
+		if s.has(member) {
+			response[i] = 1
+		}
+	}
+	return response, nil
+}
+
+func SCard(db int, key string) (int, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	s, ok, err := d.setForRead(key)
+	if !ok {
+		return 0, err
+	}
+	return s.len(), nil
+}
+
+// combineSets computes the union, intersection or difference of the sets
+// at keys, missing keys counting as empty sets. The intersection walks the
+// smallest set and stops early once limit members were found (0 for no
+// limit); the difference removes the other sets from the first one.
+func (d *DB) combineSets(op string, keys []string, limit int) ([]string, error) {
+	sets := make([]*inner_Set_data, len(keys))
+	for i, key := range keys {
+		s, _, err := d.setForRead(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = s
+	}
+	response := make([]string, 0)
+	switch op {
+	case "union":
+		seen := make(map[string]bool)
+		for _, s := range sets {
+			if s == nil {
+				continue
+			}
+			for member := range s.all() {
+				if !seen[member] {
+					seen[member] = true
+					response = append(response, member)
+				}
+			}
+		}
+	case "inter":
+		if slices.Contains(sets, nil) {
+			return response, nil
+		}
+		slices.SortFunc(sets, func(a, b *inner_Set_data) int { return a.len() - b.len() })
+		for member := range sets[0].all() {
+			inAll := true
+			for _, other := range sets[1:] {
+				if !other.has(member) {
+					inAll = false
+					break
+				}
+			}
+			if inAll {
+				response = append(response, member)
+				if limit > 0 && len(response) >= limit {
+					break
+				}
+			}
+		}
+	case "diff":
+		if sets[0] == nil {
+			return response, nil
+		}
+		for member := range sets[0].all() {
+			inOther := false
+			for _, other := range sets[1:] {
+				if other == nil {
+					continue
+				}
+				if other.has(member) {
+					inOther = true
+					break
+				}
+			}
+			if !inOther {
+				response = append(response, member)
+			}
+		}
+	}
+	return response, nil
+}
+
+// SCombine returns the members of the union ("union"), intersection
+// ("inter") or difference ("diff") of the sets at keys.
+func SCombine(db int, op string, keys []string) ([]string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	return d.combineSets(op, keys, 0)
+}
+
+// SCombineStore is SCombine storing the result at dst, replacing any value
+// there. It returns the size of the result; an empty result deletes dst.
+func SCombineStore(db int, dst string, op string, keys []string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	members, err := d.combineSets(op, keys, 0)
+	if err != nil {
+		return 0, err
+	}
+	d.removeKey(dst)
+	for _, member := range members {
+		d.setAdd(dst, member)
+	}
+	return len(members), nil
+}
+
+// SInterCard returns the size of the intersection of the sets at keys,
+// counting no further than limit when it is not 0.
+func SInterCard(db int, keys []string, limit int) (int, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	members, err := d.combineSets("inter", keys, limit)
+	return len(members), err
+}
+
+// SMove moves member from the set at src to the one at dst. It returns 0 if
+// member is not in src.
+func SMove(db int, src string, dst string, member string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	s, err := d.setForWrite(src)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := d.setForWrite(dst); err != nil {
+		return 0, err
+	}
+	if s == nil || !s.has(member) {
+		return 0, nil
+	}
+	if src == dst {
+		return 1, nil
+	}
+	d.setRemove(src, member)
+	d.setAdd(dst, member)
+	return 1, nil
+}
+
+// randomMembers returns count random members of s: distinct ones when
+// count is positive, possibly repeated ones when it is negative.
+func randomMembers(s *inner_Set_data, count int) []string {
+	response := make([]string, 0)
+	if count < 0 {
+		for i := 0; i < -count; i++ {
+			member, _ := s.random()
+			response = append(response, member)
+		}
+		return response
+	}
+	if count*3 > s.len() {
+		// close to the whole set: shuffle all members and keep count
+		for member := range s.all() {
+			response = append(response, member)
+		}
+		rand.Shuffle(len(response), func(i, j int) { response[i], response[j] = response[j], response[i] })
+		return response[:min(count, len(response))]
+	}
+	seen := make(map[string]bool, count)
+	for len(response) < count {
+		member, _ := s.random()
+		if !seen[member] {
+			seen[member] = true
+			response = append(response, member)
+		}
+	}
+	return response
+}
+
+// SRandMember returns random members without removing them, see
+// randomMembers.
+func SRandMember(db int, key string, count int) ([]string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	s, ok, err := d.setForRead(key)
+	if !ok || count == 0 {
+		return make([]string, 0), err
+	}
+	return randomMembers(s, count), nil
+}
+
+// SPop removes and returns up to count distinct random members.
+func SPop(db int, key string, count int) ([]string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	s, err := d.setForWrite(key)
+	if s == nil || count <= 0 {
+		return make([]string, 0), err
+	}
+	members := randomMembers(s, count)
+	for _, member := range members {
+		d.setRemove(key, member)
+	}
+	return members, nil
+}
+
+// SScan is HScan for sets.
+func SScan(db int, key string, cursor uint64, count int, pattern string) (uint64, []string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	response := make([]string, 0)
+	s, ok, err := d.setForRead(key)
+	if !ok {
+		return 0, response, err
+	}
+	cursor = s.scan(cursor, count, func(member string) {
+		if pattern == "" || MatchPattern(pattern, member) {
+			response = append(response, member)
+		}
+	})
+	return cursor, response, nil
+}
@@ -1,386 +1,612 @@
 package store
 
 import (
+	"errors"
 	"sync"
 	"time"
 )
 
-var Redis_data = make(map[string]string)
-var Expiry = make(map[string]time.Time)
-var List_data = make(map[string][]string)
+// Hashes and sets are compact while small (see listpack.go) and use dict
+// instead of Go maps past that, so HSCAN/SSCAN cursors stay valid while the
+// collection is resized.
+type inner_Hash_data = hashObject
+type inner_Set_data = setObject
+
+// DB is one logical database, an independent keyspace selected with SELECT.
+type DB struct {
+	Redis_data  map[string]string
+	Expiry      map[string]time.Time
+	List_data   map[string]*quicklist
+	Hash_data   map[string]*inner_Hash_data
+	Set_data    map[string]*inner_Set_data
+	ZSet_data   map[string]*zset
+	Stream_data map[string]*stream
+	JSON_data   map[string]*jsonDoc
+	Bloom_data  map[string]*bloomFilter
+	CMS_data    map[string]*countMinSketch
+	TS_data     map[string]*timeSeries
+
+	// Hash_expiry holds the TTLs of hash fields, per key then per field.
+	// Only hashes with at least one field TTL have an entry.
+	Hash_expiry map[string]map[string]time.Time
+
+	// meta indexes every key of every type. It is a dict rather than a map
+	// so the keyspace can be walked with SCAN cursors.
+	meta *dict[*keyMeta]
+}
 
-type inner_Hash_data map[string]string
-type inner_Set_data map[string]bool
+func newDB() *DB {
+	return &DB{
+		Redis_data:  make(map[string]string),
+		Expiry:      make(map[string]time.Time),
+		List_data:   make(map[string]*quicklist),
+		Hash_data:   make(map[string]*inner_Hash_data),
+		Set_data:    make(map[string]*inner_Set_data),
+		ZSet_data:   make(map[string]*zset),
+		Stream_data: make(map[string]*stream),
+		JSON_data:   make(map[string]*jsonDoc),
+		Bloom_data:  make(map[string]*bloomFilter),
+		CMS_data:    make(map[string]*countMinSketch),
+		TS_data:     make(map[string]*timeSeries),
+		Hash_expiry: make(map[string]map[string]time.Time),
+		meta:        newDict[*keyMeta](),
+	}
+}
 
-var Hash_data = make(map[string]inner_Hash_data)
-var Set_data = make(map[string]inner_Set_data)
+var dbs = []*DB{newDB()}
 var mu sync.RWMutex
 
-func Exists(key string) bool {
+// Init creates n empty databases. It must be called before the store is used.
+func Init(n int) {
+	mu.Lock()
+	defer mu.Unlock()
+	dbs = make([]*DB, n)
+	for i := range dbs {
+		dbs[i] = newDB()
+	}
+}
+
+// Databases returns the number of logical databases.
+func Databases() int {
 	mu.RLock()
 	defer mu.RUnlock()
-	_, ok := Redis_data[key]
-	if ok {
-		if data, ok := Expiry[key]; ok {
-			ttl := int(time.Until(data).Truncate(time.Second).Seconds())
-			if ttl <= 0 {
-				return false
-			}
-		}
-	}
+	return len(dbs)
+}
+
+// GetDB returns database index for direct access, e.g. by persistence.Load.
+func GetDB(index int) *DB {
+	mu.RLock()
+	defer mu.RUnlock()
+	return dbs[index]
+}
+
+// Exists reports whether key holds a live value of any type.
+func Exists(db int, key string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := dbs[db].liveMeta(key)
 	return ok
 }
 
-func Delete(key string) bool {
+// Delete removes key, whatever its type, and reports whether it was live.
+func Delete(db int, key string) bool {
 	mu.Lock()
 	defer mu.Unlock()
-	if _, ok := Redis_data[key]; ok {
-		delete(Redis_data, key)
-		delete(Expiry, key)
-		return true
-	}
-	return false
+	d := dbs[db]
+	_, live := d.liveMeta(key)
+	d.removeKey(key)
+	return live
 }
 
-func Get(key string) (string, bool) {
+func Get(db int, key string) (string, bool, error) {
 	mu.RLock()
 	defer mu.RUnlock()
-	data, ok := Redis_data[key]
-	if ok {
-		if data, ok := Expiry[key]; ok {
-			ttl := int(time.Until(data).Truncate(time.Second).Seconds())
-			if ttl <= 0 {
-				return "", false
-			}
-		}
-		return data, true
-	}
-	return "", false
+	d := dbs[db]
+	return d.stringForRead(key)
 }
 
-func SetWithExpiry(key string, value string, seconds time.Time) {
+func SetWithExpiry(db int, key string, value string, seconds time.Time) {
 	mu.Lock()
 	defer mu.Unlock()
-	Redis_data[key] = value
-	Expiry[key] = seconds
+	d := dbs[db]
+	d.setString(key, value)
+	d.setExpiry(key, seconds)
 }
 
-func GetTTL(key string) (time.Time, bool) {
+// GetTTL returns when key expires and whether it has a TTL at all. exists
+// is false if the key is missing or already expired.
+func GetTTL(db int, key string) (when time.Time, hasTTL bool, exists bool) {
 	mu.RLock()
 	defer mu.RUnlock()
-	if data, ok := Expiry[key]; ok {
-		return data, true
+	d := dbs[db]
+	if _, live := d.liveMeta(key); !live {
+		return time.Time{}, false, false
 	}
-	return time.Time{}, false
+	when, hasTTL = d.Expiry[key]
+	return when, hasTTL, true
 }
 
-func SetExpire(key string, seconds time.Time) bool {
+// SetExpire sets the TTL of key, whatever its type, and reports whether it
+// was live.
+func SetExpire(db int, key string, seconds time.Time) bool {
 	mu.Lock()
 	defer mu.Unlock()
-	if _, ok := Redis_data[key]; ok {
-		Expiry[key] = seconds
-		return true
+	d := dbs[db]
+	if _, live := d.liveMeta(key); !live {
+		d.removeKey(key)
+		return false
 	}
-	return false
+	d.setExpiry(key, seconds)
+	return true
 }
 
-func Set(key string, value string) bool {
+func Set(db int, key string, value string) bool {
 	mu.Lock()
 	defer mu.Unlock()
-	if Redis_data == nil {
+	d := dbs[db]
+	if d.Redis_data == nil {
 		return false
 	}
 
-	Redis_data[key] = value
+	d.setString(key, value)
 	return true
 }
 
-// Clean data that is expired
-func CleanUp() {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-	for range ticker.C {
-		now := time.Now()
-		mu.Lock()
-		for key, exp := range Expiry {
-			if !exp.After(now) {
-				delete(Expiry, key)
-				delete(Redis_data, key)
-			}
+// setString stores a string value and updates the key's memory accounting.
+// A value of another type at key is replaced, as SET does.
+func (d *DB) setString(key string, value string) {
+	if _, err := d.lookupKeyWrite(key, "string"); err != nil {
+		d.removeKey(key)
+	}
+	m := d.writeKey(key)
+	if old, ok := d.Redis_data[key]; ok {
+		grow(m, -stringSize(old))
+	}
+	grow(m, stringSize(value))
+	d.Redis_data[key] = value
+}
+
+// ExpireSample looks at up to count keys that carry a TTL and removes the
+// ones that are already expired. Go randomises map iteration order, so every
+// call inspects a different slice of the Expiry map. It returns how many keys
+// were sampled and how many of them were expired.
+func ExpireSample(db int, count int) (int, int) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	now := time.Now()
+	sampled, expired := 0, 0
+	for key, exp := range d.Expiry {
+		if sampled >= count {
+			break
+		}
+		sampled++
+		if !exp.After(now) {
+			d.removeKey(key)
+			expired++
 		}
-		mu.Unlock()
 	}
+	return sampled, expired
 }
 
 // LIST Fucntions
 
-func LPush(key string, value string) int {
+// LPush pushes values one after the other to the head of the list, so
+// LPUSH k a b c leaves c first.
+func LPush(db int, key string, values ...string) (int, error) {
 	mu.Lock()
 	defer mu.Unlock()
-	data := List_data[key]
-	data = append([]string{value}, data...)
-	List_data[key] = data
-	return len(data)
+	d := dbs[db]
+	return d.listPush(key, true, values)
 }
 
-func RPush(key string, value string) int {
+func RPush(db int, key string, values ...string) (int, error) {
 	mu.Lock()
 	defer mu.Unlock()
-	data := List_data[key]
-	data = append(data, value)
-	List_data[key] = data
-	return len(data)
+	d := dbs[db]
+	return d.listPush(key, false, values)
 }
 
-func LPop(key string) (string, bool) {
+// LPushX is LPush only if the list already exists. It returns 0 otherwise.
+func LPushX(db int, key string, values ...string) (int, error) {
 	mu.Lock()
 	defer mu.Unlock()
-	if len(List_data) <= 0 {
-		return "", false
-	}
-	if data, ok := List_data[key]; ok {
-		response := data[0]
-		List_data[key] = data[1:]
-		return response, true
+	d := dbs[db]
+	if data, err := d.listForWrite(key); data == nil {
+		return 0, err
 	}
-
-	return "", false
+	return d.listPush(key, true, values)
 }
 
-func RPop(key string) (string, bool) {
+func RPushX(db int, key string, values ...string) (int, error) {
 	mu.Lock()
 	defer mu.Unlock()
-	if len(List_data) <= 0 {
-		return "", false
+	d := dbs[db]
+	if data, err := d.listForWrite(key); data == nil {
+		return 0, err
 	}
-	if data, ok := List_data[key]; ok {
-		response := data[len(data)-1]
-		List_data[key] = data[:len(data)-1]
-		return response, true
+	return d.listPush(key, false, values)
+}
+
+// listPush adds values one by one to the left or right end of the list at
+// key and returns its new length. Clients blocked on the key are served
+// afterwards. Callers must hold the write lock.
+func (d *DB) listPush(key string, left bool, values []string) (int, error) {
+	data, err := d.listForWrite(key)
+	if err != nil {
+		return 0, err
+	}
+	if data == nil {
+		data = newQuicklist()
+		d.List_data[key] = data
+	}
+	m := d.writeKey(key)
+	for _, v := range values {
+		if left {
+			data.pushFront(v)
+		} else {
+			data.pushBack(v)
+		}
+		grow(m, elemOverhead+len(v))
 	}
+	length := data.len()
+	d.signalKey(key)
+	return length, nil
+}
 
-	return "", false
+// listPop removes up to count elements from the left or right end of the
+// list at key, in pop order, and deletes the list once it is empty.
+// Callers must hold the write lock.
+func (d *DB) listPop(key string, left bool, count int) ([]string, error) {
+	data, err := d.listForWrite(key)
+	if data == nil {
+		return nil, err
+	}
+	count = min(count, data.len())
+	response := make([]string, 0, count)
+	size := 0
+	for range count {
+		var v string
+		if left {
+			v = data.popFront()
+		} else {
+			v = data.popBack()
+		}
+		response = append(response, v)
+		size += elemOverhead + len(v)
+	}
+	d.growKey(key, -size)
+	if data.len() == 0 {
+		d.removeKey(key)
+	}
+	return response, nil
 }
 
-func LRange(key string, start int, stop int) ([]string, bool) {
-	mu.Lock()
-	defer mu.Unlock()
-	if start < 0 || stop > len(List_data[key]) {
-		return nil, false
+// LRange returns a copy of the elements between start and stop inclusive.
+// Negative indexes count from the tail and out of range indexes are
+// clamped, so a missing key or an empty range gives an empty slice.
+func LRange(db int, key string, start int, stop int) ([]string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	data, ok, err := d.listForRead(key)
+	if !ok {
+		return []string{}, err
 	}
-	if start == 0 && stop <= -1 {
-		return List_data[key], true
+	start, stop, ok = listRange(data.len(), start, stop)
+	if !ok {
+		return []string{}, nil
 	}
-	response := make(map[string][]string)
-	i := 0
-	if _, ok := List_data[key]; !ok {
-		return nil, false
+	return data.slice(start, stop), nil
+}
+
+// listRange applies Redis' range semantics to start and stop for a list of
+// length elements, reporting whether the range is empty.
+func listRange(length int, start int, stop int) (int, int, bool) {
+	if start < 0 {
+		start = max(start+length, 0)
 	}
-	for _, value := range List_data[key] {
-		if i >= start && i <= stop {
-			response[key] = append(response[key], value)
-		}
-		i++
+	if stop < 0 {
+		stop += length
 	}
-	return response[key], true
+	stop = min(stop, length-1)
+	return start, stop, start <= stop
 }
 
-func LLen(key string) int {
+func LLen(db int, key string) (int, error) {
 	mu.RLock()
 	defer mu.RUnlock()
-	if data, ok := List_data[key]; ok {
-		return len(data)
+	d := dbs[db]
+	data, ok, err := d.listForRead(key)
+	if !ok {
+		return 0, err
 	}
-	return 0
+	return data.len(), nil
 }
 
-// Hash Functions
-func HSet(key string, field string, value string) int {
-	mu.Lock()
-	defer mu.Unlock()
-
-	// ensure inner map exists
-	if _, ok := Hash_data[key]; !ok {
-		Hash_data[key] = make(inner_Hash_data)
+// listForRead returns the list at key if it exists and is live.
+func (d *DB) listForRead(key string) (*quicklist, bool, error) {
+	if ok, err := d.lookupKey(key, "list"); !ok {
+		return nil, false, err
 	}
+	return d.List_data[key], true, nil
+}
 
-	if _, existed := Hash_data[key][field]; existed {
-		Hash_data[key][field] = value
-		return 0
+// listForWrite returns the list at key, or nil if the key is missing. An
+// expired list is dropped, not revived with its old elements. Callers must
+// hold the write lock.
+func (d *DB) listForWrite(key string) (*quicklist, error) {
+	if ok, err := d.lookupKeyWrite(key, "list"); !ok {
+		return nil, err
 	}
-	Hash_data[key][field] = value
-	return 1
+	return d.List_data[key], nil
 }
 
-func HGet(key string, field string) (string, bool) {
-	mu.RLock()
-	defer mu.RUnlock()
-	if m, ok := Hash_data[key]; ok {
-		if response, ok2 := m[field]; ok2 {
-			return response, true
-		}
+// listIndex resolves a possibly negative index into the list, reporting
+// whether it is in range.
+func listIndex(data *quicklist, index int) (int, bool) {
+	if data == nil {
+		return 0, false
 	}
-	return "", false
+	if index < 0 {
+		index += data.len()
+	}
+	return index, index >= 0 && index < data.len()
 }
 
-func HDel(key string, field string) int {
+// ListPop pops for p without blocking, as LMPOP and LMOVE do.
+func ListPop(db int, p BlockedPop) (PopResult, bool, error) {
 	mu.Lock()
 	defer mu.Unlock()
-	if m, ok := Hash_data[key]; ok {
-		if _, ok2 := m[field]; ok2 {
-			delete(m, field)
-			return 1
+	d := dbs[db]
+	for _, key := range p.Keys {
+		result, ok, err := d.popFor(&p, key)
+		if err != nil || ok {
+			return result, ok, err
 		}
 	}
-	return 0
+	return PopResult{}, false, nil
 }
 
-func HGetAll(key string) []string {
+// LIndex returns the element at index, negative indexes counting from the
+// tail.
+func LIndex(db int, key string, index int) (string, bool, error) {
 	mu.RLock()
 	defer mu.RUnlock()
-	response := make([]string, 0)
-	if m, ok := Hash_data[key]; ok {
-		for k, v := range m {
-			response = append(response, k)
-			response = append(response, v)
-		}
+	d := dbs[db]
+	data, _, err := d.listForRead(key)
+	i, ok := listIndex(data, index)
+	if !ok {
+		return "", false, err
 	}
-	return response
+	return data.index(i), true, nil
 }
 
-func HKeys(key string) []string {
-	mu.RLock()
-	defer mu.RUnlock()
-	response := make([]string, 0)
-	if m, ok := Hash_data[key]; ok {
-		for field := range m {
-			response = append(response, field)
-		}
+// LSet replaces the element at index.
+func LSet(db int, key string, index int, value string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	data, err := d.listForWrite(key)
+	if err != nil {
+		return err
 	}
-	return response
-}
-
-func HLen(key string) int {
-	mu.RLock()
-	defer mu.RUnlock()
-	_, ok := Hash_data[key]
+	if data == nil {
+		return errors.New("no such key")
+	}
+	i, ok := listIndex(data, index)
 	if !ok {
-		return 0
+		return errors.New("index out of range")
 	}
-	return len(Hash_data[key])
+	old := data.set(i, value)
+	d.growKey(key, len(value)-len(old))
+	return nil
 }
 
-// SETS (unordered) Functions
-
-func SAdd(key string, value string) int {
+// LInsert inserts value before or after the first occurrence of pivot. It
+// returns the new length, -1 if pivot was not found and 0 if the list does
+// not exist.
+func LInsert(db int, key string, before bool, pivot string, value string) (int, error) {
 	mu.Lock()
 	defer mu.Unlock()
-	// ensure inner map exists
-	if _, ok := Set_data[key]; !ok {
-		Set_data[key] = make(inner_Set_data)
-	}
-	if _, ok := Set_data[key]; ok {
-		if _, ok := Set_data[key][value]; ok {
-			Set_data[key][value] = true
-			return 0
+	d := dbs[db]
+	data, err := d.listForWrite(key)
+	if data == nil {
+		return 0, err
+	}
+	for i, v := range data.all() {
+		if v != pivot {
+			continue
+		}
+		if !before {
+			i++
 		}
-		Set_data[key][value] = true
-		return 1
+		data.insert(i, value)
+		d.growKey(key, elemOverhead+len(value))
+		return data.len(), nil
 	}
-	Set_data[key][value] = true
-	return 1
+	return -1, nil
 }
 
-func SRem(key string, value string) int {
+// LRem removes elements equal to value: the first count ones when count is
+// positive, the last -count ones when it is negative, all of them when it
+// is 0. It returns how many were removed.
+func LRem(db int, key string, count int, value string) (int, error) {
 	mu.Lock()
 	defer mu.Unlock()
-	if _, ok := Set_data[key][value]; ok {
-		delete(Set_data[key], value)
-		return 1
+	d := dbs[db]
+	data, err := d.listForWrite(key)
+	if data == nil {
+		return 0, err
+	}
+	limit := count
+	if limit < 0 {
+		limit = -limit
 	}
-	return 0
+	removed := data.removeValue(value, limit, count < 0)
+	d.growKey(key, -removed*(elemOverhead+len(value)))
+	if data.len() == 0 {
+		d.removeKey(key)
+	}
+	return removed, nil
 }
 
-func SMembers(key string) []string {
-	mu.RLock()
-	defer mu.RUnlock()
-	response := make([]string, 0)
-	for value, ok := range Set_data[key] {
-		if ok {
-			response = append(response, value)
-		}
+// LTrim keeps only the elements between start and stop inclusive, with
+// Redis' index semantics, deleting the list if nothing is left.
+func LTrim(db int, key string, start int, stop int) error {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	data, err := d.listForWrite(key)
+	if data == nil {
+		return err
 	}
-	return response
+	length := data.len()
+	start, stop, ok := listRange(length, start, stop)
+	if !ok {
+		d.removeKey(key)
+		return nil
+	}
+	size := data.dropBack(length-1-stop) + data.dropFront(start)
+	d.growKey(key, -size)
+	return nil
 }
 
-func SIsMember(key string, member string) int {
+// LPos returns the indexes of elements equal to value. rank selects the
+// first match to return, counting from the tail when negative; count is
+// the maximum number of matches (0 for all) and maxLen the maximum number
+// of elements compared (0 for all).
+func LPos(db int, key string, value string, rank int, count int, maxLen int) ([]int, error) {
 	mu.RLock()
 	defer mu.RUnlock()
-	if _, ok := Set_data[key][member]; ok {
-		return 1
+	d := dbs[db]
+	response := make([]int, 0)
+	data, ok, err := d.listForRead(key)
+	if !ok {
+		return response, err
+	}
+	skip := rank - 1
+	elements := data.all()
+	if rank < 0 {
+		skip = -rank - 1
+		elements = data.backward()
+	}
+	compared := 0
+	for i, v := range elements {
+		if maxLen > 0 && compared >= maxLen {
+			break
+		}
+		compared++
+		if v != value {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		response = append(response, i)
+		if count > 0 && len(response) >= count {
+			break
+		}
 	}
-	return 0
+	return response, nil
 }
 
-func SCard(key string) int {
-	mu.RLock()
-	defer mu.RUnlock()
-	response := 0
-
-	for _, ok := range Set_data[key] {
-		if ok {
-			response++
+// scanDict runs d.scan until at least count entries were visited or the walk
+// is over, with the same iteration cap as SCAN.
+func scanDict[V any](d *dict[V], cursor uint64, count int, fn func(string, V)) uint64 {
+	seen := 0
+	for iterations := count * 10; iterations > 0; iterations-- {
+		cursor = d.scan(cursor, func(key string, value V) {
+			seen++
+			fn(key, value)
+		})
+		if cursor == 0 || seen >= count {
+			break
 		}
 	}
-	return response
+	return cursor
+}
+
+// DBSnapshot is a copy of one database, taken for persistence.
+type DBSnapshot struct {
+	Strings    map[string]string
+	Expiry     map[string]time.Time
+	Lists      map[string][]string
+	Hashes     map[string]map[string]string
+	Sets       map[string]map[string]bool
+	HashExpiry map[string]map[string]time.Time
+	ZSets      map[string][]ZMember
+	Streams    map[string]StreamDump
+	JSON       map[string]string
+	Blooms     map[string]BloomDump
+	Sketches   map[string]CMSDump
+	TimeSeries map[string]TSDump
 }
 
 // SnapShot (to avoid slow write operation and save data later, user won't be stopped)
-func GetSnapshot() (
-	map[string]string,
-	map[string]time.Time,
-	map[string][]string,
-	map[string]map[string]string,
-	map[string]map[string]bool,
-) {
+// Every database is copied under the same read lock, so the snapshot is
+// consistent across databases and types.
+func GetSnapshot() []DBSnapshot {
 	mu.RLock()
 	defer mu.RUnlock()
+	response := make([]DBSnapshot, len(dbs))
+	for i, d := range dbs {
+		response[i] = d.snapshot()
+	}
+	return response
+}
 
+// snapshot copies d. Callers must hold the read lock.
+func (d *DB) snapshot() DBSnapshot {
 	// Strings
 	redis_data := make(map[string]string)
-	for k, v := range Redis_data {
+	for k, v := range d.Redis_data {
 		redis_data[k] = v
 	}
 
 	// Expiry
 	expiry := make(map[string]time.Time)
-	for k, v := range Expiry {
+	for k, v := range d.Expiry {
 		expiry[k] = v
 	}
 
 	// Lists
 	lists := make(map[string][]string)
-	for k, v := range List_data {
-		lists[k] = v
+	for k, v := range d.List_data {
+		lists[k] = v.slice(0, v.len()-1)
 	}
 
 	// Hash
 	hashes := make(map[string]map[string]string)
-	for k, v := range Hash_data {
+	for k, v := range d.Hash_data {
 		hashes[k] = make(map[string]string)
-		for k1, v1 := range v {
+		for k1, v1 := range v.all() {
 			hashes[k][k1] = v1
 		}
 	}
 
 	// Sets
 	sets := make(map[string]map[string]bool)
-	for k, v := range Set_data {
+	for k, v := range d.Set_data {
 		sets[k] = make(map[string]bool)
-		for k1, v1 := range v {
-			sets[k][k1] = v1
+		for k1 := range v.all() {
+			sets[k][k1] = true
 		}
 	}
 
-	return redis_data, expiry, lists, hashes, sets
+	return DBSnapshot{
+		Strings:    redis_data,
+		Expiry:     expiry,
+		Lists:      lists,
+		Hashes:     hashes,
+		Sets:       sets,
+		HashExpiry: d.hashExpirySnapshot(),
+		ZSets:      d.zsetSnapshot(),
+		Streams:    d.streamSnapshot(),
+		JSON:       d.jsonSnapshot(),
+		Blooms:     d.bloomSnapshot(),
+		Sketches:   d.cmsSnapshot(),
+		TimeSeries: d.tsSnapshot(),
+	}
 }
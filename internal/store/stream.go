@@ -0,0 +1,1375 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A stream is an append-only log of field/value entries identified by
+// increasing ms-seq IDs. Entries are stored in macro nodes of up to
+// streamNodeMaxEntries entries, like the listpacks hanging off Redis' radix
+// tree: appending and trimming the head only touch the first or last node,
+// and lookups binary search the nodes and then the entries of one node.
+
+const streamNodeMaxEntries = 100
+
+// Overheads used by the memory accounting: an entry with its ID and field
+// slice, a consumer group, a consumer and a pending entries list record.
+const (
+	streamEntryOverhead = 48
+	groupOverhead       = 96
+	consumerOverhead    = 64
+	pendingOverhead     = 56
+)
+
+// StreamID identifies a stream entry.
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less reports whether id sorts before other.
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+func (id StreamID) isZero() bool {
+	return id.Ms == 0 && id.Seq == 0
+}
+
+// next returns the smallest ID after id, false if id is the largest one.
+func (id StreamID) next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{id.Ms, id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{id.Ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// Prev returns the largest ID before id, false if id is 0-0.
+func (id StreamID) Prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{id.Ms, id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// MaxStreamID is the largest possible stream ID, what "+" stands for.
+var MaxStreamID = StreamID{math.MaxUint64, math.MaxUint64}
+
+var errStreamID = errors.New("Invalid stream ID specified as stream command argument")
+
+// ParseStreamID parses "ms-seq" or "ms". A lone "ms" takes missingSeq as
+// its sequence number: 0 for range starts, the maximum for range ends.
+func ParseStreamID(s string, missingSeq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, errStreamID
+	}
+	if !hasSeq {
+		return StreamID{ms, missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, errStreamID
+	}
+	return StreamID{ms, seq}, nil
+}
+
+// ParseStreamRange parses the start and end of XRANGE, accepting "-", "+"
+// and exclusive bounds prefixed with "(".
+func ParseStreamRange(start string, end string) (StreamID, StreamID, error) {
+	first, err := parseRangeBound(start, 0, true)
+	if err != nil {
+		return first, first, err
+	}
+	last, err := parseRangeBound(end, math.MaxUint64, false)
+	return first, last, err
+}
+
+func parseRangeBound(s string, missingSeq uint64, isStart bool) (StreamID, error) {
+	switch s {
+	case "-":
+		return StreamID{}, nil
+	case "+":
+		return MaxStreamID, nil
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	id, err := ParseStreamID(strings.TrimPrefix(s, "("), missingSeq)
+	if err != nil || !exclusive {
+		return id, err
+	}
+	var ok bool
+	if isStart {
+		id, ok = id.next()
+	} else {
+		id, ok = id.Prev()
+	}
+	if !ok {
+		return id, errors.New("invalid start ID for the interval")
+	}
+	return id, nil
+}
+
+// StreamEntry is a stream entry as returned to clients. Fields holds
+// field/value pairs; it is nil for an entry that was deleted while it was
+// still pending in a consumer group.
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+type stream struct {
+	nodes        [][]StreamEntry
+	length       int
+	lastID       StreamID
+	maxDeletedID StreamID
+	entriesAdded int64
+	groups       map[string]*streamGroup
+}
+
+type streamGroup struct {
+	lastID      StreamID
+	entriesRead int64 // -1 when unknown
+	// pel is the pending entries list: delivered but not acknowledged
+	// entries, ordered by ID.
+	pel       []*pendingEntry
+	pending   map[StreamID]*pendingEntry
+	consumers map[string]*streamConsumer
+}
+
+type pendingEntry struct {
+	id            StreamID
+	consumer      *streamConsumer
+	deliveryTime  int64 // unix ms
+	deliveryCount int64
+}
+
+type streamConsumer struct {
+	name       string
+	seenTime   int64 // last interaction, unix ms
+	activeTime int64 // last successful read or claim, -1 if never
+	pending    int
+}
+
+func newStream() *stream {
+	return &stream{groups: make(map[string]*streamGroup)}
+}
+
+func entrySize(e StreamEntry) int {
+	size := streamEntryOverhead
+	for _, f := range e.Fields {
+		size += len(f)
+	}
+	return size
+}
+
+func streamSize(s *stream) int {
+	size := 0
+	for _, node := range s.nodes {
+		for _, e := range node {
+			size += entrySize(e)
+		}
+	}
+	for name, g := range s.groups {
+		size += groupOverhead + len(name) + len(g.pel)*pendingOverhead
+		for cname := range g.consumers {
+			size += consumerOverhead + len(cname)
+		}
+	}
+	return size
+}
+
+func (s *stream) firstID() StreamID {
+	if s.length == 0 {
+		return StreamID{}
+	}
+	return s.nodes[0][0].ID
+}
+
+// seek returns the position of the first entry whose ID is at least id.
+func (s *stream) seek(id StreamID) (int, int) {
+	n := sort.Search(len(s.nodes), func(i int) bool {
+		node := s.nodes[i]
+		return !node[len(node)-1].ID.Less(id)
+	})
+	if n == len(s.nodes) {
+		return n, 0
+	}
+	node := s.nodes[n]
+	return n, sort.Search(len(node), func(i int) bool { return !node[i].ID.Less(id) })
+}
+
+func (s *stream) get(id StreamID) (StreamEntry, bool) {
+	n, i := s.seek(id)
+	if n < len(s.nodes) && s.nodes[n][i].ID == id {
+		return s.nodes[n][i], true
+	}
+	return StreamEntry{}, false
+}
+
+func (s *stream) append(e StreamEntry) {
+	last := len(s.nodes) - 1
+	if last < 0 || len(s.nodes[last]) >= streamNodeMaxEntries {
+		s.nodes = append(s.nodes, make([]StreamEntry, 0, 8))
+		last++
+	}
+	s.nodes[last] = append(s.nodes[last], e)
+	s.length++
+	s.lastID = e.ID
+	s.entriesAdded++
+}
+
+// delete removes the entry id and returns its size, 0 if it is missing.
+func (s *stream) delete(id StreamID) int {
+	n, i := s.seek(id)
+	if n == len(s.nodes) || s.nodes[n][i].ID != id {
+		return 0
+	}
+	size := entrySize(s.nodes[n][i])
+	node := s.nodes[n]
+	copy(node[i:], node[i+1:])
+	node[len(node)-1] = StreamEntry{}
+	s.nodes[n] = node[:len(node)-1]
+	if len(s.nodes[n]) == 0 {
+		s.nodes = append(s.nodes[:n], s.nodes[n+1:]...)
+	}
+	s.length--
+	if s.maxDeletedID.Less(id) {
+		s.maxDeletedID = id
+	}
+	return size
+}
+
+// rangeEntries returns up to count entries between first and last
+// inclusive, from the end when rev is set. count <= 0 means all of them.
+func (s *stream) rangeEntries(first StreamID, last StreamID, count int, rev bool) []StreamEntry {
+	response := make([]StreamEntry, 0)
+	if last.Less(first) {
+		return response
+	}
+	if !rev {
+		n, i := s.seek(first)
+		for ; n < len(s.nodes); n, i = n+1, 0 {
+			for ; i < len(s.nodes[n]); i++ {
+				e := s.nodes[n][i]
+				if last.Less(e.ID) || (count > 0 && len(response) >= count) {
+					return response
+				}
+				response = append(response, e)
+			}
+		}
+		return response
+	}
+
+	n, i := len(s.nodes), 0
+	if next, ok := last.next(); ok {
+		n, i = s.seek(next)
+	}
+	// step back from the first entry after last
+	for {
+		if i == 0 {
+			n--
+			if n < 0 {
+				return response
+			}
+			i = len(s.nodes[n])
+		}
+		i--
+		e := s.nodes[n][i]
+		if e.ID.Less(first) || (count > 0 && len(response) >= count) {
+			return response
+		}
+		response = append(response, e)
+	}
+}
+
+// StreamTrim describes the MAXLEN or MINID trimming of XADD and XTRIM.
+type StreamTrim struct {
+	MinID     bool // trim by MINID instead of MAXLEN
+	MaxLen    int64
+	Threshold StreamID
+	// Approx allows keeping extra entries so only whole nodes are dropped,
+	// and Limit caps the number of entries it removes. A Limit of 0 means
+	// the default of 100 nodes, a negative one no limit.
+	Approx bool
+	Limit  int
+}
+
+// trim removes entries from the head and returns how many were removed and
+// their size.
+func (s *stream) trim(t StreamTrim) (int, int) {
+	removed, size := 0, 0
+	limit := t.Limit
+	if limit == 0 {
+		limit = 100 * streamNodeMaxEntries
+	}
+	keep := func(e StreamEntry, left int) bool {
+		if t.MinID {
+			return !e.ID.Less(t.Threshold)
+		}
+		return int64(left) <= t.MaxLen
+	}
+	for len(s.nodes) > 0 {
+		node := s.nodes[0]
+		if keep(node[0], s.length) {
+			break
+		}
+		if t.Approx {
+			// only drop the node if every entry in it has to go
+			if !(t.MinID && node[len(node)-1].ID.Less(t.Threshold)) &&
+				!(!t.MinID && int64(s.length-len(node)) >= t.MaxLen) {
+				break
+			}
+			if limit > 0 && removed+len(node) > limit {
+				break
+			}
+			for _, e := range node {
+				size += entrySize(e)
+			}
+			removed += len(node)
+			s.length -= len(node)
+			s.nodes = s.nodes[1:]
+			continue
+		}
+		i := 0
+		for i < len(node) && !keep(node[i], s.length-i) {
+			size += entrySize(node[i])
+			i++
+		}
+		removed += i
+		s.length -= i
+		if i == len(node) {
+			s.nodes = s.nodes[1:]
+		} else {
+			s.nodes[0] = append(node[:0:0], node[i:]...)
+		}
+	}
+	return removed, size
+}
+
+// hasTombstones reports whether entries after start may have been deleted,
+// in which case counters such as a group's entries-read cannot be trusted.
+func (s *stream) hasTombstones(start StreamID) bool {
+	if s.length == 0 || s.maxDeletedID.isZero() {
+		return false
+	}
+	if start.Less(s.firstID()) {
+		start = s.firstID()
+	}
+	return !s.maxDeletedID.Less(start)
+}
+
+// distanceFromFirst estimates how many entries were ever added up to id,
+// the entries-read of a group whose last delivered ID is id. It returns -1
+// when that cannot be known because of deleted entries.
+func (s *stream) distanceFromFirst(id StreamID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+	if s.length == 0 && id.Less(s.lastID) {
+		return s.entriesAdded
+	}
+	if !id.Less(s.lastID) {
+		return s.entriesAdded
+	}
+	if !s.hasTombstones(StreamID{}) && id.Less(s.firstID()) {
+		return s.entriesAdded - int64(s.length)
+	}
+	return -1
+}
+
+// lag returns how many entries the group has not read yet, -1 if unknown.
+func (s *stream) lag(g *streamGroup) int64 {
+	if s.entriesAdded == 0 || !g.lastID.Less(s.lastID) {
+		return 0
+	}
+	if g.entriesRead >= 0 && !s.hasTombstones(g.lastID) {
+		return s.entriesAdded - g.entriesRead
+	}
+	return -1
+}
+
+func (s *stream) clone() *stream {
+	c := &stream{
+		nodes:        make([][]StreamEntry, len(s.nodes)),
+		length:       s.length,
+		lastID:       s.lastID,
+		maxDeletedID: s.maxDeletedID,
+		entriesAdded: s.entriesAdded,
+		groups:       make(map[string]*streamGroup, len(s.groups)),
+	}
+	for i, node := range s.nodes {
+		c.nodes[i] = append([]StreamEntry(nil), node...)
+	}
+	for name, g := range s.groups {
+		cg := &streamGroup{
+			lastID:      g.lastID,
+			entriesRead: g.entriesRead,
+			pending:     make(map[StreamID]*pendingEntry, len(g.pending)),
+			consumers:   make(map[string]*streamConsumer, len(g.consumers)),
+		}
+		for cname, consumer := range g.consumers {
+			copied := *consumer
+			cg.consumers[cname] = &copied
+		}
+		for _, p := range g.pel {
+			copied := *p
+			copied.consumer = cg.consumers[p.consumer.name]
+			cg.pel = append(cg.pel, &copied)
+			cg.pending[p.id] = &copied
+		}
+		c.groups[name] = cg
+	}
+	return c
+}
+
+// StreamError is an error replied with its own code, such as NOGROUP or
+// BUSYGROUP, instead of ERR.
+type StreamError struct {
+	Code string
+	Msg  string
+}
+
+func (e *StreamError) Error() string {
+	return e.Code + " " + e.Msg
+}
+
+func noGroupError(key string, group string) error {
+	return &StreamError{"NOGROUP", "No such key '" + key + "' or consumer group '" + group + "'"}
+}
+
+var errStreamExhausted = errors.New("The stream has exhausted the last possible ID, unable to add more items")
+
+// nextStreamID resolves the ID argument of XADD: "*", "ms-*" or "ms-seq".
+func (s *stream) nextStreamID(arg string) (StreamID, error) {
+	if arg == "*" {
+		ms := uint64(time.Now().UnixMilli())
+		if ms > s.lastID.Ms {
+			return StreamID{ms, 0}, nil
+		}
+		id, ok := s.lastID.next()
+		if !ok {
+			return id, errStreamExhausted
+		}
+		return id, nil
+	}
+
+	var id StreamID
+	if msPart, ok := strings.CutSuffix(arg, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return id, errStreamID
+		}
+		switch {
+		case ms == s.lastID.Ms && s.length+int(s.entriesAdded) > 0:
+			if s.lastID.Seq == math.MaxUint64 {
+				return id, errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+			}
+			id = StreamID{ms, s.lastID.Seq + 1}
+		case ms == 0:
+			id = StreamID{0, 1}
+		default:
+			id = StreamID{ms, 0}
+		}
+	} else {
+		var err error
+		id, err = ParseStreamID(arg, 0)
+		if err != nil {
+			return id, err
+		}
+	}
+	if id.isZero() {
+		return id, errors.New("The ID specified in XADD must be greater than 0-0")
+	}
+	if !s.lastID.Less(id) {
+		return id, errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+	return id, nil
+}
+
+// streamForWrite returns the stream at key, or nil if the key is missing,
+// dropping it first if it expired. Callers must hold the write lock.
+func (d *DB) streamForWrite(key string) (*stream, error) {
+	ok, err := d.lookupKeyWrite(key, "stream")
+	if !ok {
+		return nil, err
+	}
+	return d.Stream_data[key], nil
+}
+
+// streamForRead returns the stream at key if it exists and is live.
+func (d *DB) streamForRead(key string) (*stream, bool, error) {
+	ok, err := d.lookupKey(key, "stream")
+	if !ok {
+		return nil, false, err
+	}
+	return d.Stream_data[key], true, nil
+}
+
+// XAdd appends an entry with the given field/value pairs and returns its
+// ID. With noMkStream a missing stream is not created and false is
+// returned. trim, if not nil, is applied after the entry is added.
+func XAdd(db int, key string, idArg string, fields []string, noMkStream bool, trim *StreamTrim) (StreamID, bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	s, err := d.streamForWrite(key)
+	if err != nil {
+		return StreamID{}, false, err
+	}
+	if s == nil {
+		if noMkStream {
+			return StreamID{}, false, nil
+		}
+		s = newStream()
+	}
+	id, err := s.nextStreamID(idArg)
+	if err != nil {
+		return id, false, err
+	}
+	d.Stream_data[key] = s
+	m := d.writeKey(key)
+	e := StreamEntry{ID: id, Fields: append([]string(nil), fields...)}
+	s.append(e)
+	grow(m, entrySize(e))
+	if trim != nil {
+		_, size := s.trim(*trim)
+		grow(m, -size)
+	}
+	d.signalKey(key)
+	return id, true, nil
+}
+
+// XLen returns the number of entries in a stream.
+func XLen(db int, key string) (int, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	s, ok, err := d.streamForRead(key)
+	if !ok {
+		return 0, err
+	}
+	return s.length, nil
+}
+
+// XRange returns up to count entries between first and last inclusive,
+// starting from last when rev is set. count <= 0 returns all of them.
+func XRange(db int, key string, first StreamID, last StreamID, count int, rev bool) ([]StreamEntry, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	s, ok, err := d.streamForRead(key)
+	if !ok {
+		return make([]StreamEntry, 0), err
+	}
+	return s.rangeEntries(first, last, count, rev), nil
+}
+
+// XDel deletes entries by ID and returns how many existed. Like in Redis a
+// stream left empty is kept, as it may still carry consumer groups.
+func XDel(db int, key string, ids []StreamID) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	s, err := d.streamForWrite(key)
+	if s == nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, id := range ids {
+		if size := s.delete(id); size > 0 {
+			d.growKey(key, -size)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// XTrim trims a stream and returns the number of entries removed.
+func XTrim(db int, key string, trim StreamTrim) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	s, err := d.streamForWrite(key)
+	if s == nil {
+		return 0, err
+	}
+	removed, size := s.trim(trim)
+	d.growKey(key, -size)
+	return removed, nil
+}
+
+// XLastID returns the ID of the last entry added to a stream, what "$"
+// stands for in XREAD.
+func XLastID(db int, key string) (StreamID, bool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	s, ok, err := d.streamForRead(key)
+	if !ok {
+		return StreamID{}, false, err
+	}
+	return s.lastID, true, nil
+}
+
+// XLastEntryID returns the ID of the last entry still in a stream, what
+// "+" stands for in XREAD.
+func XLastEntryID(db int, key string) (StreamID, bool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	s, ok, err := d.streamForRead(key)
+	if !ok || s.length == 0 {
+		return StreamID{}, false, err
+	}
+	node := s.nodes[len(s.nodes)-1]
+	return node[len(node)-1].ID, true, nil
+}
+
+// XRead returns up to count entries with an ID greater than after.
+func XRead(db int, key string, after StreamID, count int) ([]StreamEntry, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	s, ok, err := d.streamForRead(key)
+	if !ok {
+		return make([]StreamEntry, 0), err
+	}
+	first, ok := after.next()
+	if !ok {
+		return make([]StreamEntry, 0), nil
+	}
+	return s.rangeEntries(first, MaxStreamID, count, false), nil
+}
+
+// resolveGroupID parses the ID given to XGROUP CREATE and SETID, where "$"
+// is the last ID of the stream.
+func (s *stream) resolveGroupID(arg string) (StreamID, error) {
+	if arg == "$" {
+		return s.lastID, nil
+	}
+	return ParseStreamID(arg, 0)
+}
+
+// XGroupCreate creates a consumer group starting after id ("$" for the end
+// of the stream). mkStream creates an empty stream if key is missing.
+// entriesRead is -1 when not given.
+func XGroupCreate(db int, key string, group string, id string, mkStream bool, entriesRead int64) error {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	s, err := d.streamForWrite(key)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		if !mkStream {
+			return errors.New("The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+		}
+		s = newStream()
+		d.Stream_data[key] = s
+		d.writeKey(key)
+	}
+	start, err := s.resolveGroupID(id)
+	if err != nil {
+		return err
+	}
+	if _, exists := s.groups[group]; exists {
+		return &StreamError{"BUSYGROUP", "Consumer Group name already exists"}
+	}
+	if entriesRead < 0 {
+		entriesRead = s.distanceFromFirst(start)
+	}
+	s.groups[group] = &streamGroup{
+		lastID:      start,
+		entriesRead: entriesRead,
+		pending:     make(map[StreamID]*pendingEntry),
+		consumers:   make(map[string]*streamConsumer),
+	}
+	d.growKey(key, groupOverhead+len(group))
+	return nil
+}
+
+// streamGroupForRead returns a consumer group, or a NOGROUP error.
+func (d *DB) streamGroupForRead(key string, group string) (*stream, *streamGroup, error) {
+	s, ok, err := d.streamForRead(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, noGroupError(key, group)
+	}
+	g, ok := s.groups[group]
+	if !ok {
+		return nil, nil, noGroupError(key, group)
+	}
+	return s, g, nil
+}
+
+// XGroupSetID moves the last delivered ID of a group.
+func XGroupSetID(db int, key string, group string, id string, entriesRead int64) error {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	s, g, err := d.streamGroupForRead(key, group)
+	if err != nil {
+		return err
+	}
+	start, err := s.resolveGroupID(id)
+	if err != nil {
+		return err
+	}
+	if entriesRead < 0 {
+		entriesRead = s.distanceFromFirst(start)
+	}
+	g.lastID, g.entriesRead = start, entriesRead
+	return nil
+}
+
+// XGroupDestroy deletes a consumer group and its pending entries. Clients
+// blocked reading from it are woken up and get an error.
+func XGroupDestroy(db int, key string, group string) (bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	s, err := d.streamForWrite(key)
+	if err != nil {
+		return false, err
+	}
+	if s == nil {
+		return false, errors.New("The XGROUP subcommand requires the key to exist")
+	}
+	g, ok := s.groups[group]
+	if !ok {
+		return false, nil
+	}
+	size := groupOverhead + len(group) + len(g.pel)*pendingOverhead
+	for name := range g.consumers {
+		size += consumerOverhead + len(name)
+	}
+	delete(s.groups, group)
+	d.growKey(key, -size)
+	d.signalKey(key)
+	return true, nil
+}
+
+// consumer returns the named consumer of a group, creating it if needed.
+// created reports whether it is new.
+func (d *DB) consumer(key string, g *streamGroup, name string) (*streamConsumer, bool) {
+	c, ok := g.consumers[name]
+	if ok {
+		c.seenTime = time.Now().UnixMilli()
+		return c, false
+	}
+	c = &streamConsumer{name: name, seenTime: time.Now().UnixMilli(), activeTime: -1}
+	g.consumers[name] = c
+	d.growKey(key, consumerOverhead+len(name))
+	return c, true
+}
+
+// XGroupCreateConsumer adds a consumer to a group and reports whether it
+// is new.
+func XGroupCreateConsumer(db int, key string, group string, consumer string) (bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	_, g, err := d.streamGroupForRead(key, group)
+	if err != nil {
+		return false, err
+	}
+	_, created := d.consumer(key, g, consumer)
+	return created, nil
+}
+
+// XGroupDelConsumer removes a consumer and its pending entries, returning
+// how many pending entries it had.
+func XGroupDelConsumer(db int, key string, group string, consumer string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	_, g, err := d.streamGroupForRead(key, group)
+	if err != nil {
+		return 0, err
+	}
+	c, ok := g.consumers[consumer]
+	if !ok {
+		return 0, nil
+	}
+	pending := c.pending
+	kept := g.pel[:0]
+	for _, p := range g.pel {
+		if p.consumer == c {
+			delete(g.pending, p.id)
+			continue
+		}
+		kept = append(kept, p)
+	}
+	clear(g.pel[len(kept):])
+	g.pel = kept
+	delete(g.consumers, consumer)
+	d.growKey(key, -(consumerOverhead + len(consumer) + pending*pendingOverhead))
+	return pending, nil
+}
+
+// addPending records that id was delivered to c, or re-delivered if it is
+// already pending. It returns the change in memory.
+func (g *streamGroup) addPending(id StreamID, c *streamConsumer, now int64) int {
+	if p, ok := g.pending[id]; ok {
+		p.consumer.pending--
+		p.consumer = c
+		p.deliveryTime = now
+		p.deliveryCount++
+		c.pending++
+		return 0
+	}
+	p := &pendingEntry{id: id, consumer: c, deliveryTime: now, deliveryCount: 1}
+	i := sort.Search(len(g.pel), func(i int) bool { return !g.pel[i].id.Less(id) })
+	g.pel = append(g.pel, nil)
+	copy(g.pel[i+1:], g.pel[i:])
+	g.pel[i] = p
+	g.pending[id] = p
+	c.pending++
+	return pendingOverhead
+}
+
+// removePending acknowledges id and reports whether it was pending.
+func (g *streamGroup) removePending(id StreamID) bool {
+	p, ok := g.pending[id]
+	if !ok {
+		return false
+	}
+	i := sort.Search(len(g.pel), func(i int) bool { return !g.pel[i].id.Less(id) })
+	copy(g.pel[i:], g.pel[i+1:])
+	g.pel[len(g.pel)-1] = nil
+	g.pel = g.pel[:len(g.pel)-1]
+	delete(g.pending, id)
+	p.consumer.pending--
+	return true
+}
+
+// XReadGroup reads as consumer of group. With id ">" it delivers entries
+// never delivered to the group, adding them to the pending entries list
+// unless noAck is set; otherwise it returns the consumer's own pending
+// entries after id.
+func XReadGroup(db int, key string, group string, consumer string, id string, count int, noAck bool) ([]StreamEntry, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	s, g, err := d.streamGroupForRead(key, group)
+	if errors.Is(err, ErrWrongType) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &StreamError{"NOGROUP", "No such key '" + key + "' or consumer group '" + group + "' in XREADGROUP with GROUP option"}
+	}
+	c, _ := d.consumer(key, g, consumer)
+	now := time.Now().UnixMilli()
+
+	if id != ">" {
+		after, err := ParseStreamID(id, 0)
+		if err != nil {
+			return nil, err
+		}
+		response := make([]StreamEntry, 0)
+		for _, p := range g.pel {
+			if count > 0 && len(response) >= count {
+				break
+			}
+			if p.consumer != c || !after.Less(p.id) {
+				continue
+			}
+			e, ok := s.get(p.id)
+			if !ok {
+				e = StreamEntry{ID: p.id}
+			}
+			p.deliveryTime = now
+			p.deliveryCount++
+			response = append(response, e)
+		}
+		return response, nil
+	}
+
+	first, ok := g.lastID.next()
+	if !ok {
+		return make([]StreamEntry, 0), nil
+	}
+	response := s.rangeEntries(first, MaxStreamID, count, false)
+	if len(response) == 0 {
+		return response, nil
+	}
+	c.activeTime = now
+	for _, e := range response {
+		if g.entriesRead >= 0 && !s.hasTombstones(g.lastID) {
+			g.entriesRead++
+		} else {
+			g.entriesRead = s.distanceFromFirst(e.ID)
+		}
+		g.lastID = e.ID
+		if !noAck {
+			d.growKey(key, g.addPending(e.ID, c, now))
+		}
+	}
+	return response, nil
+}
+
+// XAck acknowledges pending entries and returns how many were pending.
+func XAck(db int, key string, group string, ids []StreamID) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	_, g, err := d.streamGroupForRead(key, group)
+	if errors.Is(err, ErrWrongType) {
+		return 0, err
+	}
+	if err != nil {
+		return 0, nil
+	}
+	acked := 0
+	for _, id := range ids {
+		if g.removePending(id) {
+			d.growKey(key, -pendingOverhead)
+			acked++
+		}
+	}
+	return acked, nil
+}
+
+// PendingSummary is the reply of XPENDING without a range.
+type PendingSummary struct {
+	Count     int
+	First     StreamID
+	Last      StreamID
+	Consumers []ConsumerPending
+}
+
+// ConsumerPending is the number of pending entries of a consumer.
+type ConsumerPending struct {
+	Name    string
+	Pending int
+}
+
+// PendingEntry is one entry of the extended XPENDING reply.
+type PendingEntry struct {
+	ID            StreamID
+	Consumer      string
+	Idle          int64 // ms
+	DeliveryCount int64
+}
+
+// XPendingSummary summarises the pending entries list of a group.
+func XPendingSummary(db int, key string, group string) (PendingSummary, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	var response PendingSummary
+	_, g, err := d.streamGroupForRead(key, group)
+	if err != nil {
+		return response, err
+	}
+	response.Count = len(g.pel)
+	if len(g.pel) == 0 {
+		return response, nil
+	}
+	response.First, response.Last = g.pel[0].id, g.pel[len(g.pel)-1].id
+	for name, c := range g.consumers {
+		if c.pending > 0 {
+			response.Consumers = append(response.Consumers, ConsumerPending{name, c.pending})
+		}
+	}
+	sort.Slice(response.Consumers, func(i, j int) bool {
+		return response.Consumers[i].Name < response.Consumers[j].Name
+	})
+	return response, nil
+}
+
+// XPendingRange lists up to count pending entries between first and last
+// that have been idle for at least minIdle ms, optionally only those of one
+// consumer.
+func XPendingRange(db int, key string, group string, minIdle int64, first StreamID, last StreamID, count int, consumer string) ([]PendingEntry, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	_, g, err := d.streamGroupForRead(key, group)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	response := make([]PendingEntry, 0)
+	i := sort.Search(len(g.pel), func(i int) bool { return !g.pel[i].id.Less(first) })
+	for ; i < len(g.pel) && len(response) < count; i++ {
+		p := g.pel[i]
+		if last.Less(p.id) {
+			break
+		}
+		idle := now - p.deliveryTime
+		if idle < minIdle || (consumer != "" && p.consumer.name != consumer) {
+			continue
+		}
+		response = append(response, PendingEntry{p.id, p.consumer.name, idle, p.deliveryCount})
+	}
+	return response, nil
+}
+
+// XClaimOptions are the options of XCLAIM. Idle and Time are -1 when not
+// given, RetryCount is -1 when not given.
+type XClaimOptions struct {
+	Idle       int64
+	Time       int64
+	RetryCount int64
+	Force      bool
+	JustID     bool
+	LastID     *StreamID
+}
+
+// claim transfers a pending entry to c if it has been idle for minIdle ms.
+// It returns the entry, whether it was claimed, and whether the entry no
+// longer exists in the stream (it is then dropped from the PEL).
+func (d *DB) claim(key string, s *stream, g *streamGroup, c *streamConsumer, id StreamID, minIdle int64, opts XClaimOptions, now int64) (StreamEntry, bool, bool) {
+	e, exists := s.get(id)
+	p, pending := g.pending[id]
+	if !pending {
+		if !opts.Force || !exists {
+			return e, false, false
+		}
+		// FORCE creates the pending entry, which is then always claimed
+		d.growKey(key, g.addPending(id, c, now))
+		p = g.pending[id]
+		p.deliveryCount = 0
+		minIdle = 0
+	}
+	if !exists {
+		g.removePending(id)
+		d.growKey(key, -pendingOverhead)
+		return e, false, true
+	}
+	if minIdle > 0 && now-p.deliveryTime < minIdle {
+		return e, false, false
+	}
+
+	deliveryTime := now
+	if opts.Idle >= 0 {
+		deliveryTime = now - opts.Idle
+	} else if opts.Time >= 0 {
+		deliveryTime = opts.Time
+	}
+	g.addPending(id, c, deliveryTime)
+	switch {
+	case opts.RetryCount >= 0:
+		p.deliveryCount = opts.RetryCount
+	case opts.JustID:
+		// JUSTID does not count as a delivery
+		p.deliveryCount--
+	}
+	c.activeTime = now
+	return e, true, false
+}
+
+// XClaim changes the owner of pending entries idle for at least minIdle
+// ms and returns the claimed entries.
+func XClaim(db int, key string, group string, consumer string, minIdle int64, ids []StreamID, opts XClaimOptions) ([]StreamEntry, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	s, g, err := d.streamGroupForRead(key, group)
+	if err != nil {
+		return nil, err
+	}
+	if opts.LastID != nil && g.lastID.Less(*opts.LastID) {
+		g.lastID = *opts.LastID
+	}
+	c, _ := d.consumer(key, g, consumer)
+	now := time.Now().UnixMilli()
+	response := make([]StreamEntry, 0)
+	for _, id := range ids {
+		if e, claimed, _ := d.claim(key, s, g, c, id, minIdle, opts, now); claimed {
+			response = append(response, e)
+		}
+	}
+	return response, nil
+}
+
+// XAutoClaim claims up to count pending entries idle for at least minIdle
+// ms, scanning the PEL from start. It returns the cursor to continue from
+// (0-0 when the scan is over), the claimed entries and the IDs of pending
+// entries that no longer exist in the stream, which are dropped.
+func XAutoClaim(db int, key string, group string, consumer string, minIdle int64, start StreamID, count int, justID bool) (StreamID, []StreamEntry, []StreamID, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	s, g, err := d.streamGroupForRead(key, group)
+	if err != nil {
+		return StreamID{}, nil, nil, err
+	}
+	c, _ := d.consumer(key, g, consumer)
+	now := time.Now().UnixMilli()
+	opts := XClaimOptions{Idle: -1, Time: -1, RetryCount: -1, JustID: justID}
+
+	claimed := make([]StreamEntry, 0)
+	deleted := make([]StreamID, 0)
+	// bound the work done when most entries are not idle enough
+	attempts := count * 10
+	i := sort.Search(len(g.pel), func(i int) bool { return !g.pel[i].id.Less(start) })
+	for i < len(g.pel) && len(claimed) < count && attempts > 0 {
+		attempts--
+		id := g.pel[i].id
+		e, ok, gone := d.claim(key, s, g, c, id, minIdle, opts, now)
+		if gone {
+			deleted = append(deleted, id)
+			continue // the PEL shrank, i already points to the next entry
+		}
+		if ok {
+			claimed = append(claimed, e)
+		}
+		i++
+	}
+	var next StreamID
+	if i < len(g.pel) {
+		next = g.pel[i].id
+	}
+	return next, claimed, deleted, nil
+}
+
+// StreamInfo is the reply of XINFO STREAM.
+type StreamInfo struct {
+	Length        int
+	Nodes         int
+	LastID        StreamID
+	MaxDeletedID  StreamID
+	EntriesAdded  int64
+	Groups        int
+	FirstEntry    *StreamEntry
+	LastEntry     *StreamEntry
+	RecordedFirst StreamID
+}
+
+// GroupInfo is one element of the reply of XINFO GROUPS.
+type GroupInfo struct {
+	Name        string
+	Consumers   int
+	Pending     int
+	LastID      StreamID
+	EntriesRead int64 // -1 when unknown
+	Lag         int64 // -1 when unknown
+}
+
+// ConsumerInfo is one element of the reply of XINFO CONSUMERS.
+type ConsumerInfo struct {
+	Name     string
+	Pending  int
+	Idle     int64
+	Inactive int64 // -1 if the consumer never read anything
+}
+
+// XInfoStream describes a stream.
+func XInfoStream(db int, key string) (StreamInfo, bool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	s, ok, err := d.streamForRead(key)
+	if !ok {
+		return StreamInfo{}, false, err
+	}
+	response := StreamInfo{
+		Length:        s.length,
+		Nodes:         len(s.nodes),
+		LastID:        s.lastID,
+		MaxDeletedID:  s.maxDeletedID,
+		EntriesAdded:  s.entriesAdded,
+		Groups:        len(s.groups),
+		RecordedFirst: s.firstID(),
+	}
+	if s.length > 0 {
+		first := s.nodes[0][0]
+		node := s.nodes[len(s.nodes)-1]
+		last := node[len(node)-1]
+		response.FirstEntry, response.LastEntry = &first, &last
+	}
+	return response, true, nil
+}
+
+// XInfoGroups describes the consumer groups of a stream, sorted by name.
+func XInfoGroups(db int, key string) ([]GroupInfo, bool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	s, ok, err := d.streamForRead(key)
+	if !ok {
+		return nil, false, err
+	}
+	response := make([]GroupInfo, 0, len(s.groups))
+	for name, g := range s.groups {
+		response = append(response, GroupInfo{
+			Name:        name,
+			Consumers:   len(g.consumers),
+			Pending:     len(g.pel),
+			LastID:      g.lastID,
+			EntriesRead: g.entriesRead,
+			Lag:         s.lag(g),
+		})
+	}
+	sort.Slice(response, func(i, j int) bool { return response[i].Name < response[j].Name })
+	return response, true, nil
+}
+
+// XInfoConsumers describes the consumers of a group, sorted by name.
+func XInfoConsumers(db int, key string, group string) ([]ConsumerInfo, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	_, g, err := d.streamGroupForRead(key, group)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	response := make([]ConsumerInfo, 0, len(g.consumers))
+	for name, c := range g.consumers {
+		inactive := int64(-1)
+		if c.activeTime >= 0 {
+			inactive = now - c.activeTime
+		}
+		response = append(response, ConsumerInfo{name, c.pending, now - c.seenTime, inactive})
+	}
+	sort.Slice(response, func(i, j int) bool { return response[i].Name < response[j].Name })
+	return response, nil
+}
+
+// StreamDump is the form in which streams are persisted: the entries plus
+// everything needed to keep IDs monotonic and consumer groups intact
+// across restarts.
+type StreamDump struct {
+	Entries      []StreamEntryDump    `json:"entries"`
+	LastID       string               `json:"last_id"`
+	MaxDeletedID string               `json:"max_deleted_id"`
+	EntriesAdded int64                `json:"entries_added"`
+	Groups       map[string]GroupDump `json:"groups,omitempty"`
+}
+
+type StreamEntryDump struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+type GroupDump struct {
+	LastID      string                  `json:"last_id"`
+	EntriesRead int64                   `json:"entries_read"`
+	Consumers   map[string]ConsumerDump `json:"consumers,omitempty"`
+	Pending     []PendingDump           `json:"pending,omitempty"`
+}
+
+type ConsumerDump struct {
+	SeenTime   int64 `json:"seen_time"`
+	ActiveTime int64 `json:"active_time"`
+}
+
+type PendingDump struct {
+	ID            string `json:"id"`
+	Consumer      string `json:"consumer"`
+	DeliveryTime  int64  `json:"delivery_time"`
+	DeliveryCount int64  `json:"delivery_count"`
+}
+
+// streamSnapshot returns a dump of every stream in d.
+func (d *DB) streamSnapshot() map[string]StreamDump {
+	response := make(map[string]StreamDump, len(d.Stream_data))
+	for key, s := range d.Stream_data {
+		dump := StreamDump{
+			Entries:      make([]StreamEntryDump, 0, s.length),
+			LastID:       s.lastID.String(),
+			MaxDeletedID: s.maxDeletedID.String(),
+			EntriesAdded: s.entriesAdded,
+			Groups:       make(map[string]GroupDump, len(s.groups)),
+		}
+		for _, node := range s.nodes {
+			for _, e := range node {
+				dump.Entries = append(dump.Entries, StreamEntryDump{e.ID.String(), e.Fields})
+			}
+		}
+		for name, g := range s.groups {
+			gd := GroupDump{
+				LastID:      g.lastID.String(),
+				EntriesRead: g.entriesRead,
+				Consumers:   make(map[string]ConsumerDump, len(g.consumers)),
+			}
+			for cname, c := range g.consumers {
+				gd.Consumers[cname] = ConsumerDump{c.seenTime, c.activeTime}
+			}
+			for _, p := range g.pel {
+				gd.Pending = append(gd.Pending, PendingDump{p.id.String(), p.consumer.name, p.deliveryTime, p.deliveryCount})
+			}
+			dump.Groups[name] = gd
+		}
+		response[key] = dump
+	}
+	return response
+}
+
+// LoadStream restores a stream from its dump. Memory accounting is left to
+// RebuildMeta.
+func LoadStream(db int, key string, dump StreamDump) error {
+	s := newStream()
+	for _, e := range dump.Entries {
+		id, err := ParseStreamID(e.ID, 0)
+		if err != nil {
+			return err
+		}
+		if s.length > 0 && !s.lastID.Less(id) {
+			return errors.New("stream entries are not in increasing ID order")
+		}
+		s.append(StreamEntry{ID: id, Fields: e.Fields})
+	}
+	var err error
+	if s.lastID, err = ParseStreamID(dump.LastID, 0); err != nil {
+		return err
+	}
+	if s.maxDeletedID, err = ParseStreamID(dump.MaxDeletedID, 0); err != nil {
+		return err
+	}
+	s.entriesAdded = dump.EntriesAdded
+	for name, gd := range dump.Groups {
+		g := &streamGroup{
+			entriesRead: gd.EntriesRead,
+			pending:     make(map[StreamID]*pendingEntry),
+			consumers:   make(map[string]*streamConsumer),
+		}
+		if g.lastID, err = ParseStreamID(gd.LastID, 0); err != nil {
+			return err
+		}
+		for cname, cd := range gd.Consumers {
+			g.consumers[cname] = &streamConsumer{name: cname, seenTime: cd.SeenTime, activeTime: cd.ActiveTime}
+		}
+		for _, pd := range gd.Pending {
+			id, err := ParseStreamID(pd.ID, 0)
+			if err != nil {
+				return err
+			}
+			c, ok := g.consumers[pd.Consumer]
+			if !ok {
+				c = &streamConsumer{name: pd.Consumer, activeTime: -1}
+				g.consumers[pd.Consumer] = c
+			}
+			g.addPending(id, c, pd.DeliveryTime)
+			g.pending[id].deliveryCount = pd.DeliveryCount
+		}
+		s.groups[name] = g
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	dbs[db].Stream_data[key] = s
+	return nil
+}
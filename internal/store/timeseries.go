@@ -0,0 +1,612 @@
+package store
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"math/bits"
+	"slices"
+	"sort"
+)
+
+// A time series keeps its samples in chunks compressed as in Facebook's
+// Gorilla: a timestamp is written as the difference between its delta and
+// the previous one in a variable number of bits, a single bit for a regular
+// interval, and a value as its XOR with the previous value, of which only
+// the bits between the leading and trailing zeros are written. Samples are
+// appended to the last chunk until it reaches tsChunkSize bytes; an older
+// sample is inserted by re-encoding the chunk it falls in.
+const (
+	tsChunkSize     = 4096
+	tsOverhead      = 96
+	tsChunkOverhead = 64
+)
+
+var (
+	ErrTSExists    = errors.New("TSDB: key already exists")
+	ErrTSNoKey     = errors.New("TSDB: the key does not exist")
+	ErrTSDuplicate = errors.New("TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode")
+	ErrTSTooOld    = errors.New("TSDB: Timestamp is older than retention")
+)
+
+// TSSample is a timestamp in milliseconds and its value.
+type TSSample struct {
+	Timestamp int64
+	Value     float64
+}
+
+// TSLabel is a name/value pair used to select series in TS.MRANGE.
+type TSLabel struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// TSOptions are the parameters of a new series. A zero Retention keeps
+// samples forever.
+type TSOptions struct {
+	Retention int64
+	Labels    []TSLabel
+}
+
+// TSAggregation groups samples into buckets of Bucket milliseconds aligned
+// to the epoch, each reduced to one sample by Type. A zero Bucket returns
+// the raw samples.
+type TSAggregation struct {
+	Type   string
+	Bucket int64
+}
+
+// TSFilter matches the series whose Label is one of Values, or none of them
+// if Negate is set. A missing label has the empty value, so an empty Values
+// entry selects series without the label.
+type TSFilter struct {
+	Label  string
+	Values []string
+	Negate bool
+}
+
+type bitWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *bitWriter) write(v uint64, nbits int) {
+	for i := nbits - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if v>>i&1 == 1 {
+			w.buf[w.n/8] |= 0x80 >> (w.n % 8)
+		}
+		w.n++
+	}
+}
+
+type bitReader struct {
+	buf     []byte
+	n       int
+	overrun bool
+}
+
+func (r *bitReader) read(nbits int) uint64 {
+	v := uint64(0)
+	for range nbits {
+		if r.n/8 >= len(r.buf) {
+			r.overrun = true
+			return 0
+		}
+		v = v<<1 | uint64(r.buf[r.n/8]>>(7-r.n%8)&1)
+		r.n++
+	}
+	return v
+}
+
+func signExtend(v uint64, nbits int) int64 {
+	return int64(v<<(64-nbits)) >> (64 - nbits)
+}
+
+type tsChunk struct {
+	bitWriter
+	count       int
+	first, last int64
+	// encoder state for the next sample
+	delta             int64
+	value             uint64
+	leading, trailing int // -1 before the first XOR window
+}
+
+func (c *tsChunk) append(ts int64, v float64) {
+	value := math.Float64bits(v)
+	if c.count == 0 {
+		c.write(uint64(ts), 64)
+		c.write(value, 64)
+		c.first, c.last, c.value, c.leading = ts, ts, value, -1
+		c.count = 1
+		return
+	}
+	delta := ts - c.last
+	switch dod := delta - c.delta; {
+	case dod == 0:
+		c.write(0, 1)
+	case dod >= -64 && dod < 64:
+		c.write(0b10, 2)
+		c.write(uint64(dod), 7)
+	case dod >= -256 && dod < 256:
+		c.write(0b110, 3)
+		c.write(uint64(dod), 9)
+	case dod >= -2048 && dod < 2048:
+		c.write(0b1110, 4)
+		c.write(uint64(dod), 12)
+	default:
+		c.write(0b1111, 4)
+		c.write(uint64(dod), 64)
+	}
+	xor := value ^ c.value
+	switch leading, trailing := bits.LeadingZeros64(xor), bits.TrailingZeros64(xor); {
+	case xor == 0:
+		c.write(0, 1)
+	case c.leading >= 0 && leading >= c.leading && trailing >= c.trailing:
+		// the meaningful bits fit in the previous window
+		c.write(0b10, 2)
+		c.write(xor>>c.trailing, 64-c.leading-c.trailing)
+	default:
+		c.write(0b11, 2)
+		c.write(uint64(leading), 6)
+		c.write(uint64(64-leading-trailing-1), 6)
+		c.write(xor>>trailing, 64-leading-trailing)
+		c.leading, c.trailing = leading, trailing
+	}
+	c.last, c.delta, c.value = ts, delta, value
+	c.count++
+}
+
+// samples decodes the chunk. It reports false if the data ends early.
+func (c *tsChunk) samples() ([]TSSample, bool) {
+	r := bitReader{buf: c.buf}
+	response := make([]TSSample, 0, c.count)
+	var ts, delta int64
+	var value uint64
+	leading, trailing := 0, 0
+	for i := range c.count {
+		if i == 0 {
+			ts, value = int64(r.read(64)), r.read(64)
+			response = append(response, TSSample{ts, math.Float64frombits(value)})
+			continue
+		}
+		prefix := 0
+		for prefix < 4 && r.read(1) == 1 {
+			prefix++
+		}
+		switch prefix {
+		case 1:
+			delta += signExtend(r.read(7), 7)
+		case 2:
+			delta += signExtend(r.read(9), 9)
+		case 3:
+			delta += signExtend(r.read(12), 12)
+		case 4:
+			delta += int64(r.read(64))
+		}
+		ts += delta
+		if r.read(1) == 1 {
+			if r.read(1) == 1 {
+				leading = int(r.read(6))
+				trailing = 64 - leading - int(r.read(6)) - 1
+			}
+			value ^= r.read(64-leading-trailing) << trailing
+		}
+		response = append(response, TSSample{ts, math.Float64frombits(value)})
+	}
+	return response, !r.overrun
+}
+
+func chunkSize(c *tsChunk) int {
+	return tsChunkOverhead + len(c.buf)
+}
+
+// encodeChunks compresses samples, which must be in timestamp order, into
+// as many chunks as needed.
+func encodeChunks(samples []TSSample) []*tsChunk {
+	var chunks []*tsChunk
+	for _, s := range samples {
+		if len(chunks) == 0 || len(chunks[len(chunks)-1].buf) >= tsChunkSize {
+			chunks = append(chunks, &tsChunk{})
+		}
+		chunks[len(chunks)-1].append(s.Timestamp, s.Value)
+	}
+	return chunks
+}
+
+type timeSeries struct {
+	chunks    []*tsChunk
+	retention int64
+	labels    []TSLabel
+	size      int // of the chunks
+}
+
+func (s *timeSeries) lastTimestamp() int64 {
+	return s.chunks[len(s.chunks)-1].last
+}
+
+// add inserts a sample, which must not share the timestamp of another one
+// nor be older than the retention period.
+func (s *timeSeries) add(ts int64, v float64) error {
+	n := len(s.chunks)
+	if n == 0 || ts > s.lastTimestamp() {
+		if n == 0 || len(s.chunks[n-1].buf) >= tsChunkSize {
+			s.chunks = append(s.chunks, &tsChunk{})
+			s.size += tsChunkOverhead
+		}
+		c := s.chunks[len(s.chunks)-1]
+		before := len(c.buf)
+		c.append(ts, v)
+		s.size += len(c.buf) - before
+		s.trim()
+		return nil
+	}
+	if s.retention > 0 && ts < s.lastTimestamp()-s.retention {
+		return ErrTSTooOld
+	}
+	i := sort.Search(n, func(i int) bool { return s.chunks[i].last >= ts })
+	samples, _ := s.chunks[i].samples()
+	j, found := slices.BinarySearchFunc(samples, ts, func(a TSSample, ts int64) int {
+		return cmp.Compare(a.Timestamp, ts)
+	})
+	if found {
+		return ErrTSDuplicate
+	}
+	chunks := encodeChunks(slices.Insert(samples, j, TSSample{ts, v}))
+	s.size -= chunkSize(s.chunks[i])
+	for _, c := range chunks {
+		s.size += chunkSize(c)
+	}
+	s.chunks = slices.Replace(s.chunks, i, i+1, chunks...)
+	return nil
+}
+
+// trim drops the chunks whose samples are all older than the retention
+// period. Older samples left in the first chunk are skipped by queries.
+func (s *timeSeries) trim() {
+	if s.retention == 0 {
+		return
+	}
+	oldest := s.lastTimestamp() - s.retention
+	n := 0
+	for n < len(s.chunks)-1 && s.chunks[n].last < oldest {
+		s.size -= chunkSize(s.chunks[n])
+		n++
+	}
+	s.chunks = slices.Delete(s.chunks, 0, n)
+}
+
+// samples returns the samples between from and to inclusive.
+func (s *timeSeries) samples(from int64, to int64) []TSSample {
+	if len(s.chunks) == 0 {
+		return nil
+	}
+	if s.retention > 0 {
+		from = max(from, s.lastTimestamp()-s.retention)
+	}
+	var response []TSSample
+	for _, c := range s.chunks {
+		if c.first > to {
+			break
+		}
+		if c.last < from {
+			continue
+		}
+		samples, _ := c.samples()
+		for _, sample := range samples {
+			if sample.Timestamp >= from && sample.Timestamp <= to {
+				response = append(response, sample)
+			}
+		}
+	}
+	return response
+}
+
+func (s *timeSeries) label(name string) string {
+	for _, l := range s.labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+func (s *timeSeries) matches(filters []TSFilter) bool {
+	for _, f := range filters {
+		if slices.Contains(f.Values, s.label(f.Label)) == f.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *timeSeries) clone() *timeSeries {
+	c := &timeSeries{retention: s.retention, labels: slices.Clone(s.labels), size: s.size}
+	for _, chunk := range s.chunks {
+		copied := *chunk
+		copied.buf = slices.Clone(chunk.buf)
+		c.chunks = append(c.chunks, &copied)
+	}
+	return c
+}
+
+func tsSize(s *timeSeries) int {
+	size := tsOverhead + s.size
+	for _, l := range s.labels {
+		size += len(l.Name) + len(l.Value)
+	}
+	return size
+}
+
+// ValidTSAggregation reports whether name is a supported aggregation type.
+func ValidTSAggregation(name string) bool {
+	switch name {
+	case "avg", "sum", "min", "max", "count", "first", "last", "range":
+		return true
+	}
+	return false
+}
+
+func tsReduce(kind string, samples []TSSample) float64 {
+	switch kind {
+	case "count":
+		return float64(len(samples))
+	case "first":
+		return samples[0].Value
+	case "last":
+		return samples[len(samples)-1].Value
+	}
+	sum, lo, hi := 0.0, math.Inf(1), math.Inf(-1)
+	for _, s := range samples {
+		sum += s.Value
+		lo, hi = min(lo, s.Value), max(hi, s.Value)
+	}
+	switch kind {
+	case "avg":
+		return sum / float64(len(samples))
+	case "min":
+		return lo
+	case "max":
+		return hi
+	case "range":
+		return hi - lo
+	}
+	return sum
+}
+
+// aggregate reduces samples to one per non empty bucket, timestamped with
+// the start of the bucket.
+func aggregate(samples []TSSample, agg TSAggregation) []TSSample {
+	if agg.Bucket == 0 {
+		return samples
+	}
+	var response []TSSample
+	for i := 0; i < len(samples); {
+		start := samples[i].Timestamp - samples[i].Timestamp%agg.Bucket
+		j := i + 1
+		for j < len(samples) && samples[j].Timestamp-start < agg.Bucket {
+			j++
+		}
+		response = append(response, TSSample{start, tsReduce(agg.Type, samples[i:j])})
+		i = j
+	}
+	return response
+}
+
+func queryRange(s *timeSeries, from int64, to int64, count int, agg TSAggregation) []TSSample {
+	samples := aggregate(s.samples(from, to), agg)
+	if count > 0 && len(samples) > count {
+		samples = samples[:count]
+	}
+	return samples
+}
+
+// tsForRead returns the series at key if it exists and is live, or
+// ErrWrongType if the key holds another type.
+func (d *DB) tsForRead(key string) (*timeSeries, bool, error) {
+	if ok, err := d.lookupKey(key, "TSDB-TYPE"); !ok {
+		return nil, false, err
+	}
+	return d.TS_data[key], true, nil
+}
+
+// tsForWrite is tsForRead for commands that modify the series: an expired
+// key is deleted first so its TTL does not carry over.
+func (d *DB) tsForWrite(key string) (*timeSeries, bool, error) {
+	if ok, err := d.lookupKeyWrite(key, "TSDB-TYPE"); !ok {
+		return nil, false, err
+	}
+	return d.TS_data[key], true, nil
+}
+
+func (d *DB) createTS(key string, opts TSOptions) *timeSeries {
+	s := &timeSeries{retention: opts.Retention, labels: slices.Clone(opts.Labels)}
+	d.TS_data[key] = s
+	grow(d.writeKey(key), tsSize(s))
+	return s
+}
+
+// addTS adds a sample to s, stored at key, keeping the memory accounting.
+func (d *DB) addTS(key string, s *timeSeries, ts int64, v float64) error {
+	before := tsSize(s)
+	if err := s.add(ts, v); err != nil {
+		return err
+	}
+	grow(d.writeKey(key), tsSize(s)-before)
+	return nil
+}
+
+// TSCreate creates an empty series at key, which must not exist.
+func TSCreate(db int, key string, opts TSOptions) error {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	if _, ok, err := d.tsForWrite(key); ok || err != nil {
+		if err == nil || errors.Is(err, ErrWrongType) {
+			err = ErrTSExists
+		}
+		return err
+	}
+	d.createTS(key, opts)
+	return nil
+}
+
+// TSAdd adds a sample to the series at key, creating it with opts if
+// needed. Options are ignored for an existing series.
+func TSAdd(db int, key string, ts int64, v float64, opts TSOptions) error {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	s, ok, err := d.tsForWrite(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s = d.createTS(key, opts)
+	}
+	return d.addTS(key, s, ts, v)
+}
+
+// TSAddItem is one sample of TSMAdd.
+type TSAddItem struct {
+	Key       string
+	Timestamp int64
+	Value     float64
+}
+
+// TSMAdd adds samples to existing series and returns the error of each, nil
+// for the samples added.
+func TSMAdd(db int, items []TSAddItem) []error {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	response := make([]error, len(items))
+	for i, item := range items {
+		s, ok, err := d.tsForWrite(item.Key)
+		if err == nil && !ok {
+			err = ErrTSNoKey
+		}
+		if err == nil {
+			err = d.addTS(item.Key, s, item.Timestamp, item.Value)
+		}
+		response[i] = err
+	}
+	return response
+}
+
+// TSGet returns the last sample of the series at key, or false if it has
+// none.
+func TSGet(db int, key string) (TSSample, bool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	s, ok, err := dbs[db].tsForRead(key)
+	if err != nil {
+		return TSSample{}, false, err
+	}
+	if !ok {
+		return TSSample{}, false, ErrTSNoKey
+	}
+	if len(s.chunks) == 0 {
+		return TSSample{}, false, nil
+	}
+	c := s.chunks[len(s.chunks)-1]
+	return TSSample{c.last, math.Float64frombits(c.value)}, true, nil
+}
+
+// TSRange returns the samples of the series at key between from and to
+// inclusive, aggregated by agg, and at most count of them if count > 0.
+func TSRange(db int, key string, from int64, to int64, count int, agg TSAggregation) ([]TSSample, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	s, ok, err := dbs[db].tsForRead(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTSNoKey
+	}
+	return queryRange(s, from, to, count, agg), nil
+}
+
+// TSSeries is one series of a TSMRange reply.
+type TSSeries struct {
+	Key     string
+	Labels  []TSLabel
+	Samples []TSSample
+}
+
+// TSMRange runs TSRange on every series matching all filters, in key order.
+func TSMRange(db int, from int64, to int64, count int, agg TSAggregation, filters []TSFilter) []TSSeries {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	var response []TSSeries
+	for key, s := range d.TS_data {
+		if _, live := d.liveMeta(key); !live || !s.matches(filters) {
+			continue
+		}
+		response = append(response, TSSeries{key, slices.Clone(s.labels), queryRange(s, from, to, count, agg)})
+	}
+	slices.SortFunc(response, func(a, b TSSeries) int { return cmp.Compare(a.Key, b.Key) })
+	return response
+}
+
+// TSDump is the snapshot of a series; chunks keep their compressed data,
+// encoded as base64.
+type TSDump struct {
+	Retention int64         `json:"retention,omitempty"`
+	Labels    []TSLabel     `json:"labels,omitempty"`
+	Chunks    []TSChunkDump `json:"chunks"`
+}
+
+type TSChunkDump struct {
+	Data  []byte `json:"data"`
+	Count int    `json:"count"`
+}
+
+// tsSnapshot returns a dump of every series in d.
+func (d *DB) tsSnapshot() map[string]TSDump {
+	response := make(map[string]TSDump, len(d.TS_data))
+	for key, s := range d.TS_data {
+		dump := TSDump{Retention: s.retention, Labels: slices.Clone(s.labels)}
+		for _, c := range s.chunks {
+			dump.Chunks = append(dump.Chunks, TSChunkDump{slices.Clone(c.buf), c.count})
+		}
+		response[key] = dump
+	}
+	return response
+}
+
+// LoadTS restores a series from its dump. Chunks are decoded and encoded
+// again to rebuild the encoder state. Memory accounting is left to
+// RebuildMeta.
+func LoadTS(db int, key string, dump TSDump) error {
+	s := &timeSeries{retention: dump.Retention, labels: dump.Labels}
+	last := int64(math.MinInt64)
+	for _, cd := range dump.Chunks {
+		if cd.Count <= 0 {
+			return errors.New("series chunk is empty")
+		}
+		samples, ok := (&tsChunk{bitWriter: bitWriter{buf: cd.Data}, count: cd.Count}).samples()
+		if !ok {
+			return errors.New("series chunk is truncated")
+		}
+		c := &tsChunk{}
+		for _, sample := range samples {
+			if sample.Timestamp <= last {
+				return errors.New("series samples are out of order")
+			}
+			last = sample.Timestamp
+			c.append(sample.Timestamp, sample.Value)
+		}
+		s.chunks = append(s.chunks, c)
+		s.size += chunkSize(c)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	dbs[db].TS_data[key] = s
+	return nil
+}
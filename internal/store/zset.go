@@ -0,0 +1,819 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sorted set Functions
+
+// zsetOverhead approximates a skiplist node plus its dict entry.
+const zsetOverhead = 80
+
+// zset is a sorted set: the dict maps members to scores for O(1) lookups and
+// the skiplist keeps them ordered for ranks and ranges.
+type zset struct {
+	dict *dict[float64]
+	zsl  *zskiplist
+}
+
+func newZSet() *zset {
+	return &zset{dict: newDict[float64](), zsl: newZSkiplist()}
+}
+
+// ZMember is a member with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ZAddOptions are the ZADD flags.
+type ZAddOptions struct {
+	NX bool // only add new members
+	XX bool // only update existing members
+	GT bool // only update when the new score is greater
+	LT bool // only update when the new score is lower
+	CH bool // count changed members, not only added ones
+}
+
+// ScoreRange is a score interval as written in ZRANGE BYSCORE, e.g. (1 +inf.
+type ScoreRange struct {
+	Min, Max     float64
+	MinEx, MaxEx bool
+}
+
+// LexBound is one end of a lexicographic range: "-", "+", "[value" or
+// "(value".
+type LexBound struct {
+	Value     string
+	Inf       int // -1 for "-", 1 for "+", 0 otherwise
+	Exclusive bool
+}
+
+type LexRange struct {
+	Min, Max LexBound
+}
+
+// ZRangeSpec describes a ZRANGE query. By is "rank", "score" or "lex".
+// Count < 0 means no limit.
+type ZRangeSpec struct {
+	By          string
+	Start, Stop int
+	Score       ScoreRange
+	Lex         LexRange
+	Rev         bool
+	Offset      int
+	Count       int
+}
+
+var errNotFloat = errors.New("min or max is not a float")
+var errLexRange = errors.New("min or max not valid string range item")
+
+// ParseScore parses a score, accepting inf, +inf and -inf but not NaN.
+func ParseScore(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errors.New("value is not a valid float")
+	}
+	return f, nil
+}
+
+func parseScoreBound(s string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	f, err := ParseScore(s)
+	if err != nil {
+		return 0, false, errNotFloat
+	}
+	return f, exclusive, nil
+}
+
+// ParseScoreRange parses the min and max arguments of a score range.
+func ParseScoreRange(min string, max string) (ScoreRange, error) {
+	var r ScoreRange
+	var err error
+	if r.Min, r.MinEx, err = parseScoreBound(min); err != nil {
+		return r, err
+	}
+	if r.Max, r.MaxEx, err = parseScoreBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+func parseLexBound(s string) (LexBound, error) {
+	switch {
+	case s == "-":
+		return LexBound{Inf: -1}, nil
+	case s == "+":
+		return LexBound{Inf: 1}, nil
+	case strings.HasPrefix(s, "["):
+		return LexBound{Value: s[1:]}, nil
+	case strings.HasPrefix(s, "("):
+		return LexBound{Value: s[1:], Exclusive: true}, nil
+	}
+	return LexBound{}, errLexRange
+}
+
+// ParseLexRange parses the min and max arguments of a lexicographic range.
+func ParseLexRange(min string, max string) (LexRange, error) {
+	var r LexRange
+	var err error
+	if r.Min, err = parseLexBound(min); err != nil {
+		return r, err
+	}
+	if r.Max, err = parseLexBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+func (r ScoreRange) aboveMin(n *zskiplistNode) bool {
+	if r.MinEx {
+		return n.score > r.Min
+	}
+	return n.score >= r.Min
+}
+
+func (r ScoreRange) belowMax(n *zskiplistNode) bool {
+	if r.MaxEx {
+		return n.score < r.Max
+	}
+	return n.score <= r.Max
+}
+
+func (r ScoreRange) empty() bool {
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinEx || r.MaxEx))
+}
+
+func (r LexRange) aboveMin(n *zskiplistNode) bool {
+	switch {
+	case r.Min.Inf < 0:
+		return true
+	case r.Min.Inf > 0:
+		return false
+	case r.Min.Exclusive:
+		return n.member > r.Min.Value
+	}
+	return n.member >= r.Min.Value
+}
+
+func (r LexRange) belowMax(n *zskiplistNode) bool {
+	switch {
+	case r.Max.Inf > 0:
+		return true
+	case r.Max.Inf < 0:
+		return false
+	case r.Max.Exclusive:
+		return n.member < r.Max.Value
+	}
+	return n.member <= r.Max.Value
+}
+
+func (r LexRange) empty() bool {
+	if r.Min.Inf > 0 || r.Max.Inf < 0 {
+		return true
+	}
+	if r.Min.Inf < 0 || r.Max.Inf > 0 {
+		return false
+	}
+	return r.Min.Value > r.Max.Value ||
+		(r.Min.Value == r.Max.Value && (r.Min.Exclusive || r.Max.Exclusive))
+}
+
+func (z *zset) len() int {
+	return z.dict.len()
+}
+
+// set adds member or moves it to a new score. It returns whether the member
+// is new.
+func (z *zset) set(member string, score float64) bool {
+	if old, ok := z.dict.get(member); ok {
+		if old != score {
+			z.zsl.delete(old, member)
+			z.zsl.insert(score, member)
+			z.dict.set(member, score)
+		}
+		return false
+	}
+	z.zsl.insert(score, member)
+	z.dict.set(member, score)
+	return true
+}
+
+func (z *zset) remove(member string) bool {
+	score, ok := z.dict.get(member)
+	if !ok {
+		return false
+	}
+	z.zsl.delete(score, member)
+	z.dict.delete(member)
+	return true
+}
+
+func (z *zset) clone() *zset {
+	c := newZSet()
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		c.set(x.member, x.score)
+	}
+	return c
+}
+
+// byRank returns the members between the 0-based ranks start and stop,
+// accepting negative indexes from the end like ZRANGE.
+func (z *zset) byRank(start int, stop int, rev bool) []ZMember {
+	response := make([]ZMember, 0)
+	length := z.len()
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop || start >= length {
+		return response
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+
+	var x *zskiplistNode
+	if rev {
+		x = z.zsl.byRank(length - start)
+	} else {
+		x = z.zsl.byRank(start + 1)
+	}
+	for i := start; i <= stop && x != nil; i++ {
+		response = append(response, ZMember{Member: x.member, Score: x.score})
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return response
+}
+
+// byRange walks the members between aboveMin and belowMax, skipping offset
+// of them and returning at most count (all if count < 0).
+func (z *zset) byRange(aboveMin func(*zskiplistNode) bool, belowMax func(*zskiplistNode) bool, rev bool, offset int, count int) []ZMember {
+	response := make([]ZMember, 0)
+	var x *zskiplistNode
+	if rev {
+		x = z.zsl.last(aboveMin, belowMax)
+	} else {
+		x = z.zsl.first(aboveMin, belowMax)
+	}
+	for x != nil && offset > 0 {
+		offset--
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	for x != nil && count != 0 {
+		if rev && !aboveMin(x) || !rev && !belowMax(x) {
+			break
+		}
+		response = append(response, ZMember{Member: x.member, Score: x.score})
+		count--
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return response
+}
+
+// query runs a ZRANGE style query.
+func (z *zset) query(spec ZRangeSpec) []ZMember {
+	switch spec.By {
+	case "score":
+		if spec.Score.empty() {
+			return make([]ZMember, 0)
+		}
+		return z.byRange(spec.Score.aboveMin, spec.Score.belowMax, spec.Rev, spec.Offset, spec.Count)
+	case "lex":
+		if spec.Lex.empty() {
+			return make([]ZMember, 0)
+		}
+		return z.byRange(spec.Lex.aboveMin, spec.Lex.belowMax, spec.Rev, spec.Offset, spec.Count)
+	}
+	return z.byRank(spec.Start, spec.Stop, spec.Rev)
+}
+
+// countRange returns how many members fall between aboveMin and belowMax
+// using the skiplist ranks.
+func (z *zset) countRange(aboveMin func(*zskiplistNode) bool, belowMax func(*zskiplistNode) bool) int {
+	first := z.zsl.first(aboveMin, belowMax)
+	if first == nil {
+		return 0
+	}
+	last := z.zsl.last(aboveMin, belowMax)
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+func zsetSize(z *zset) int {
+	size := 0
+	for member := range z.dict.all() {
+		size += zsetOverhead + len(member)
+	}
+	return size
+}
+
+// zsetForWrite returns the sorted set at key, creating it if needed and
+// create is set, or nil. Callers must hold the write lock.
+func (d *DB) zsetForWrite(key string, create bool) (*zset, error) {
+	ok, err := d.lookupKeyWrite(key, "zset")
+	if err != nil || (!ok && !create) {
+		return nil, err
+	}
+	if !ok {
+		d.ZSet_data[key] = newZSet()
+	}
+	return d.ZSet_data[key], nil
+}
+
+// zsetForRead returns the sorted set at key if it exists and is live.
+func (d *DB) zsetForRead(key string) (*zset, bool, error) {
+	if ok, err := d.lookupKey(key, "zset"); !ok {
+		return nil, false, err
+	}
+	return d.ZSet_data[key], true, nil
+}
+
+// deleteIfEmptyZSet removes a sorted set that lost its last member, as
+// Redis never keeps empty collections around.
+func (d *DB) deleteIfEmptyZSet(key string) {
+	if z, ok := d.ZSet_data[key]; ok && z.len() == 0 {
+		d.removeKey(key)
+	}
+}
+
+// ZAdd adds or updates members and returns the number of added members, or
+// of added and updated members with CH.
+func ZAdd(db int, key string, opts ZAddOptions, items []ZMember) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	z, err := d.zsetForWrite(key, !opts.XX)
+	if z == nil {
+		return 0, err
+	}
+	m := d.writeKey(key)
+	added, changed := 0, 0
+	for _, item := range items {
+		old, exists := z.dict.get(item.Member)
+		if exists {
+			if opts.NX || (opts.GT && item.Score <= old) || (opts.LT && item.Score >= old) {
+				continue
+			}
+			if old != item.Score {
+				z.set(item.Member, item.Score)
+				changed++
+			}
+			continue
+		}
+		if opts.XX {
+			continue
+		}
+		z.set(item.Member, item.Score)
+		grow(m, zsetOverhead+len(item.Member))
+		added++
+	}
+	d.deleteIfEmptyZSet(key)
+	if opts.CH {
+		return added + changed, nil
+	}
+	return added, nil
+}
+
+// ZIncrBy adds incr to the score of member (ZINCRBY, ZADD INCR). It returns
+// false when the options prevented the update.
+func ZIncrBy(db int, key string, opts ZAddOptions, incr float64, member string) (float64, bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	z, err := d.zsetForWrite(key, !opts.XX)
+	if z == nil {
+		return 0, false, err
+	}
+	m := d.writeKey(key)
+	old, exists := z.dict.get(member)
+	if (exists && opts.NX) || (!exists && opts.XX) {
+		d.deleteIfEmptyZSet(key)
+		return 0, false, nil
+	}
+	score := old + incr
+	if math.IsNaN(score) {
+		d.deleteIfEmptyZSet(key)
+		return 0, false, errors.New("resulting score is not a number (NaN)")
+	}
+	if exists && ((opts.GT && score <= old) || (opts.LT && score >= old)) {
+		return 0, false, nil
+	}
+	if z.set(member, score) {
+		grow(m, zsetOverhead+len(member))
+	}
+	return score, true, nil
+}
+
+// ZRem removes members and returns how many existed.
+func ZRem(db int, key string, members []string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	z, err := d.zsetForWrite(key, false)
+	if z == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if z.remove(member) {
+			d.growKey(key, -(zsetOverhead + len(member)))
+			removed++
+		}
+	}
+	d.deleteIfEmptyZSet(key)
+	return removed, nil
+}
+
+func ZScore(db int, key string, member string) (float64, bool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	z, ok, err := d.zsetForRead(key)
+	if !ok {
+		return 0, false, err
+	}
+	score, ok := z.dict.get(member)
+	return score, ok, nil
+}
+
+// ZMScore returns the scores of members; found[i] is false for missing ones.
+func ZMScore(db int, key string, members []string) ([]float64, []bool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	scores := make([]float64, len(members))
+	found := make([]bool, len(members))
+	z, ok, err := d.zsetForRead(key)
+	if !ok {
+		return scores, found, err
+	}
+	for i, member := range members {
+		scores[i], found[i] = z.dict.get(member)
+	}
+	return scores, found, nil
+}
+
+func ZCard(db int, key string) (int, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	z, ok, err := d.zsetForRead(key)
+	if !ok {
+		return 0, err
+	}
+	return z.len(), nil
+}
+
+// ZCount returns the number of members with a score in r.
+func ZCount(db int, key string, r ScoreRange) (int, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	z, ok, err := d.zsetForRead(key)
+	if !ok || r.empty() {
+		return 0, err
+	}
+	return z.countRange(r.aboveMin, r.belowMax), nil
+}
+
+// ZRank returns the 0-based rank of member, from the highest score when rev
+// is set, along with its score.
+func ZRank(db int, key string, member string, rev bool) (int, float64, bool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	z, ok, err := d.zsetForRead(key)
+	if !ok {
+		return 0, 0, false, err
+	}
+	score, ok := z.dict.get(member)
+	if !ok {
+		return 0, 0, false, nil
+	}
+	rank := z.zsl.rank(score, member)
+	if rev {
+		return z.len() - rank, score, true, nil
+	}
+	return rank - 1, score, true, nil
+}
+
+// ZRange runs a ZRANGE query.
+func ZRange(db int, key string, spec ZRangeSpec) ([]ZMember, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	z, ok, err := d.zsetForRead(key)
+	if !ok {
+		return make([]ZMember, 0), err
+	}
+	return z.query(spec), nil
+}
+
+// ZScan is HScan for sorted sets.
+func ZScan(db int, key string, cursor uint64, count int, pattern string) (uint64, []ZMember, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	response := make([]ZMember, 0)
+	z, ok, err := d.zsetForRead(key)
+	if !ok {
+		return 0, response, err
+	}
+	cursor = scanDict(z.dict, cursor, count, func(member string, score float64) {
+		if pattern == "" || MatchPattern(pattern, member) {
+			response = append(response, ZMember{Member: member, Score: score})
+		}
+	})
+	return cursor, response, nil
+}
+
+// zsetSnapshot copies every sorted set of d for persistence.
+func (d *DB) zsetSnapshot() map[string][]ZMember {
+	response := make(map[string][]ZMember)
+	for key, z := range d.ZSet_data {
+		response[key] = z.byRank(0, -1, false)
+	}
+	return response
+}
+
+// zsetInput returns the members of key as scores, treating plain sets as
+// sorted sets where every member scores 1, as ZUNION and ZINTER do.
+func (d *DB) zsetInput(key string) (map[string]float64, error) {
+	response := make(map[string]float64)
+	if _, live := d.liveMeta(key); !live {
+		return response, nil
+	}
+	if z, ok := d.ZSet_data[key]; ok {
+		for member, score := range z.dict.all() {
+			response[member] = score
+		}
+	} else if s, ok := d.Set_data[key]; ok {
+		for member := range s.all() {
+			response[member] = 1
+		}
+	} else {
+		return nil, ErrWrongType
+	}
+	d.readKey(key)
+	return response, nil
+}
+
+// aggregateScores merges two scores for ZUNION/ZINTER. Undefined results
+// such as inf + -inf become 0 like in Redis.
+func aggregateScores(aggregate string, a float64, b float64) float64 {
+	var response float64
+	switch aggregate {
+	case "MIN":
+		response = math.Min(a, b)
+	case "MAX":
+		response = math.Max(a, b)
+	default:
+		response = a + b
+	}
+	if math.IsNaN(response) {
+		return 0
+	}
+	return response
+}
+
+func weighted(score float64, weight float64) float64 {
+	response := score * weight
+	if math.IsNaN(response) {
+		return 0
+	}
+	return response
+}
+
+// combine computes ZUNION, ZINTER or ZDIFF (op "union", "inter", "diff") of
+// keys. weights has one entry per key.
+func (d *DB) combine(op string, keys []string, weights []float64, aggregate string) (*zset, error) {
+	inputs := make([]map[string]float64, len(keys))
+	for i, key := range keys {
+		input, err := d.zsetInput(key)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = input
+	}
+	scores := make(map[string]float64)
+
+	switch op {
+	case "union":
+		for i, input := range inputs {
+			for member, score := range input {
+				score = weighted(score, weights[i])
+				if old, ok := scores[member]; ok {
+					score = aggregateScores(aggregate, old, score)
+				}
+				scores[member] = score
+			}
+		}
+	case "inter":
+		// walk the smallest input and look members up in the others
+		order := make([]int, len(inputs))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(a, b int) bool {
+			return len(inputs[order[a]]) < len(inputs[order[b]])
+		})
+	members:
+		for member, score := range inputs[order[0]] {
+			score = weighted(score, weights[order[0]])
+			for _, i := range order[1:] {
+				other, ok := inputs[i][member]
+				if !ok {
+					continue members
+				}
+				score = aggregateScores(aggregate, score, weighted(other, weights[i]))
+			}
+			scores[member] = score
+		}
+	case "diff":
+	diff:
+		for member, score := range inputs[0] {
+			for _, input := range inputs[1:] {
+				if _, ok := input[member]; ok {
+					continue diff
+				}
+			}
+			scores[member] = score
+		}
+	}
+
+	response := newZSet()
+	for member, score := range scores {
+		response.set(member, score)
+	}
+	return response, nil
+}
+
+// storeZSet replaces dst with z, deleting dst when z is empty, and returns
+// the number of members stored. Callers must hold the write lock.
+func (d *DB) storeZSet(dst string, z *zset) int {
+	d.removeKey(dst)
+	if z.len() == 0 {
+		return 0
+	}
+	d.ZSet_data[dst] = z
+	grow(d.writeKey(dst), zsetSize(z))
+	return z.len()
+}
+
+// ZCombine returns ZUNION, ZINTER or ZDIFF of keys ordered by score.
+func ZCombine(db int, op string, keys []string, weights []float64, aggregate string) ([]ZMember, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	z, err := d.combine(op, keys, weights, aggregate)
+	if err != nil {
+		return nil, err
+	}
+	return z.byRank(0, -1, false), nil
+}
+
+// ZCombineStore is ZCombine storing the result in dst. It returns the size
+// of the result.
+func ZCombineStore(db int, dst string, op string, keys []string, weights []float64, aggregate string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	z, err := d.combine(op, keys, weights, aggregate)
+	if err != nil {
+		return 0, err
+	}
+	return d.storeZSet(dst, z), nil
+}
+
+// ZRangeStore stores the result of a ZRANGE query on src into dst.
+func ZRangeStore(db int, dst string, src string, spec ZRangeSpec) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	z, ok, err := d.zsetForRead(src)
+	if err != nil {
+		return 0, err
+	}
+	result := newZSet()
+	if ok {
+		for _, item := range z.query(spec) {
+			result.set(item.Member, item.Score)
+		}
+	}
+	return d.storeZSet(dst, result), nil
+}
+
+// ZPop removes and returns up to count members with the lowest scores, or
+// the highest ones when max is set.
+func ZPop(db int, key string, count int, max bool) ([]ZMember, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	z, err := d.zsetForWrite(key, false)
+	if z == nil {
+		return make([]ZMember, 0), err
+	}
+	response := z.byRank(0, count-1, max)
+	for _, item := range response {
+		z.remove(item.Member)
+		d.growKey(key, -(zsetOverhead + len(item.Member)))
+	}
+	d.deleteIfEmptyZSet(key)
+	return response, nil
+}
+
+// ZRandMember returns random members. A positive count returns distinct
+// members, at most the size of the set; a negative count returns exactly
+// -count members that may repeat.
+func ZRandMember(db int, key string, count int) ([]ZMember, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	response := make([]ZMember, 0)
+	z, ok, err := d.zsetForRead(key)
+	if !ok || count == 0 {
+		return response, err
+	}
+	length := z.len()
+	if count < 0 {
+		for i := 0; i < -count; i++ {
+			x := z.zsl.byRank(rand.IntN(length) + 1)
+			response = append(response, ZMember{Member: x.member, Score: x.score})
+		}
+		return response, nil
+	}
+	if count >= length {
+		all := z.byRank(0, -1, false)
+		rand.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+		return all, nil
+	}
+	// draw distinct ranks, retrying duplicates; fine as count < length
+	seen := make(map[int]bool, count)
+	for len(response) < count {
+		rank := rand.IntN(length) + 1
+		if seen[rank] {
+			continue
+		}
+		seen[rank] = true
+		x := z.zsl.byRank(rank)
+		response = append(response, ZMember{Member: x.member, Score: x.score})
+	}
+	return response, nil
+}
+
+// ZRemRange removes the members matched by a rank, score or lex range and
+// returns how many were removed.
+func ZRemRange(db int, key string, spec ZRangeSpec) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := dbs[db]
+	z, err := d.zsetForWrite(key, false)
+	if z == nil {
+		return 0, err
+	}
+	spec.Rev, spec.Offset, spec.Count = false, 0, -1
+	removed := z.query(spec)
+	for _, item := range removed {
+		z.remove(item.Member)
+		d.growKey(key, -(zsetOverhead + len(item.Member)))
+	}
+	d.deleteIfEmptyZSet(key)
+	return len(removed), nil
+}
+
+// ZLexCount returns the number of members in a lexicographic range.
+func ZLexCount(db int, key string, r LexRange) (int, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d := dbs[db]
+	z, ok, err := d.zsetForRead(key)
+	if !ok || r.empty() {
+		return 0, err
+	}
+	return z.countRange(r.aboveMin, r.belowMax), nil
+}